@@ -8,6 +8,8 @@ import (
     "errors"
     "fmt"
 	"os"
+	"sort"
+	"strings"
 	"github.com/apache/iotdb-client-go/client"
 )
 
@@ -1880,3 +1882,71 @@ func ExecuteAlterStatements() {
     //executeStatements("Altering root.opsd.timeseries", opsd_timeseries())
 }
 
+
+// netcdf retag <group>[,<group>]|all [dryrun=true] applies the statements of a dataset group again as UPSERTs, so changed values replace the old ones.
+var retagGroups = map[string]func() []string{
+	"combed.iiitd":     combed_iiitd,
+	"ecobee":           ecobee,
+	"amp.vancouver":    func() []string { return append(append(AMP_Vancouver1(), AMP_Vancouver2()...), AMP_Vancouver3()...) },
+	"homec.weather":    homec_weather,
+	"toniot.synthetic": toniot_synthetic,
+	"opsd.household":   opsd_household,
+	"opsd.timeseries":  opsd_timeseries,
+}
+
+// ALTER timeseries <path> ADD TAGS 'units'='A'; => ALTER timeseries <path> UPSERT TAGS('units'='A');
+func upsertStatement(statement string) string {
+	statement = strings.TrimSuffix(strings.TrimSpace(statement), ";")
+	for _, keyword := range []string{"ATTRIBUTES", "TAGS"} {
+		if path, pair, found := strings.Cut(statement, " ADD "+keyword+" "); found {
+			return path + " UPSERT " + keyword + "(" + pair + ");"
+		}
+	}
+	return statement + ";"
+}
+
+func ProcessRetag(programArgs []string) {
+	if len(programArgs) < 3 {
+		checkErr("ProcessRetag", errors.New("expected a dataset group or all; groups: "+strings.Join(retagGroupNames(), ", ")))
+	}
+	groups := strings.Split(strings.ToLower(programArgs[2]), ",")
+	if groups[0] == "all" {
+		groups = retagGroupNames()
+	}
+	ioTDbAccess := IoTDbAccess{DryRun: isDryRun(programArgs)}
+	if !ioTDbAccess.DryRun {
+		iotdbConnection, ok := Init_IoTDB(true)
+		if !ok {
+			checkErr("ProcessRetag(Init_IoTDB): ", errors.New(iotdbConnection))
+		}
+		ioTDbAccess.session = client.NewSession(clientConfig)
+		if err := ioTDbAccess.session.Open(false, 0); err != nil {
+			checkErr("ProcessRetag(session.Open): ", err)
+		}
+		defer ioTDbAccess.session.Close()
+	}
+	for _, group := range groups {
+		statements, ok := retagGroups[group]
+		if !ok {
+			checkErr("ProcessRetag", errors.New("unknown dataset group "+group+"; groups: "+strings.Join(retagGroupNames(), ", ")))
+		}
+		fmt.Print("Retagging root." + group)
+		for _, statement := range statements() {
+			err := ioTDbAccess.executeNonQuery(upsertStatement(statement))
+			checkErr("ExecuteNonQueryStatement(upsertStatement)", err)
+			if !ioTDbAccess.DryRun {
+				fmt.Print(".")
+			}
+		}
+		fmt.Println()
+	}
+}
+
+func retagGroupNames() []string {
+	names := make([]string, 0, len(retagGroups))
+	for name := range retagGroups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
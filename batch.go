@@ -0,0 +1,432 @@
+package main // batch.go runs the data file jobs of a job file, replacing one netcdf command line per file in create.sh.
+// netcdf batch <jobs.yaml> [concurrency=4] [dryrun=true] [logs=batch_logs]
+//   concurrency: 4
+//   jobs:
+//     - sources: [/data/AMP/Electricity_*.csv, /data/AMP/Water_DWW.csv]  # globs; one string is also accepted
+//       time-column: unix_ts
+//       commands: createdb,createts,insert
+//     - sources: /data/ecobee/clean_data/*_clean.nc
+//       format: nc                                # csv or nc; by default the file extension
+//       type: netCDF-4                            # NetCDF file type of nc sources
+//       time-column: time1
+//       commands: createts,insert
+//       options: {timezone: Europe/Berlin}        # key=value parameters of the data files: timelayout, timezone, ambiguous, epoch
+// Globs skip the summary_ and quarantine_ files next to the data files, and a file listed twice runs once (with its first job).
+// createdb is not passed on to the jobs: the database of each source is read from its summary file, and each missing database is
+// created once before the jobs start. Each source then runs as a netcdf process of its own, at most concurrency at a time, with its
+// output in <logs>/<nnn>_<dataset>.log (nnn numbers the sources, which may share a name); a failed source does not stop the others. A table of outcomes is printed at the end.
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/apache/iotdb-client-go/client"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	batchOk      = "ok"
+	batchFailed  = "failed"
+	batchSkipped = "skipped"
+	batchPlanned = "planned"
+)
+
+var batchParameters = map[string]string{"concurrency": "", "dryrun": "false", "logs": "batch_logs"}
+var batchFormats = []string{"csv", "nc"}
+
+// Files next to data files that globs must not pick up.
+var batchIgnoredPrefixes = []string{"summary_", "quarantine_"}
+
+// The line printed by WriteQualityReport.
+var validatedPattern = regexp.MustCompile(`^Validated (\d+) rows of .*: (\d+) accepted, (\d+) rejected`)
+
+// stringList reads a YAML sequence or a single string.
+type stringList []string
+
+func (sl *stringList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*sl = stringList{value.Value}
+		return nil
+	}
+	var values []string
+	if err := value.Decode(&values); err != nil {
+		return err
+	}
+	*sl = values
+	return nil
+}
+
+// BatchJob is one entry of the job file.
+type BatchJob struct {
+	Sources    stringList        `yaml:"sources" json:"sources"`
+	TimeColumn string            `yaml:"time-column" json:"timecolumn"`
+	Format     string            `yaml:"format" json:"format"`
+	Type       string            `yaml:"type" json:"type"`
+	Commands   string            `yaml:"commands" json:"commands"`
+	Options    map[string]string `yaml:"options" json:"options"`
+}
+
+// BatchFile is the job file.
+type BatchFile struct {
+	Concurrency int        `yaml:"concurrency" json:"concurrency"`
+	Jobs        []BatchJob `yaml:"jobs" json:"jobs"`
+}
+
+// BatchTask runs one source file of a job.
+type BatchTask struct {
+	Job      int           `json:"job"` // 1-based entry of the job file
+	Source   string        `json:"source"`
+	Database string        `json:"database"`
+	Args     []string      `json:"args"` // program arguments of the netcdf process
+	Status   string        `json:"status"`
+	Message  string        `json:"message"`
+	Accepted int           `json:"accepted"` // -1 when no rows were validated
+	Rejected int           `json:"rejected"`
+	Duration time.Duration `json:"duration"`
+	LogPath  string        `json:"logpath"`
+}
+
+func ReadBatchFile(fileName string) (BatchFile, error) {
+	var batch BatchFile
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return batch, err
+	}
+	if err = yaml.Unmarshal(data, &batch); err != nil {
+		return batch, errors.New(fileName + ": " + err.Error())
+	}
+	if len(batch.Jobs) == 0 {
+		return batch, errors.New(fileName + " has no jobs")
+	}
+	return batch, nil
+}
+
+// The database (root.<group>) that the summary file of dataFilePath names after its last field.
+func summaryDatabase(dataFilePath string) (string, error) {
+	file, err := OpenSource(GetSummaryFilename(dataFilePath))
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	csvReader := csv.NewReader(file)
+	csvReader.FieldsPerRecord = -1
+	records, err := csvReader.ReadAll()
+	if err != nil {
+		return "", err
+	}
+	for _, record := range records[min(1, len(records)):] {
+		if len(record) > 1 && (record[0] == interpolated || strings.TrimSpace(record[0]) == endOfFields) {
+			return strings.TrimSpace(record[1]), nil
+		}
+	}
+	return "", errors.New("no database after the fields of " + GetSummaryFilename(dataFilePath))
+}
+
+func isBatchIgnored(fileName string) bool {
+	for _, prefix := range batchIgnoredPrefixes {
+		if strings.HasPrefix(filepath.Base(fileName), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Expand the jobs into one task per source file; the databases that need createdb are returned in order.
+func (batch BatchFile) Tasks() ([]*BatchTask, []string) {
+	tasks := make([]*BatchTask, 0)
+	databases := make([]string, 0)
+	seen := make(map[string]bool, 0)
+	for ndx, job := range batch.Jobs {
+		fail := func(source, message string) {
+			tasks = append(tasks, &BatchTask{Job: ndx + 1, Source: source, Status: batchFailed, Message: message, Accepted: -1})
+		}
+		commands := splitList(job.Commands)
+		createDatabase := false
+		var jobErr error
+		for _, command := range commands {
+			switch {
+			case command == timeSeriesCommands[0]:
+				createDatabase = true
+			case !contains(timeSeriesCommands, command):
+				jobErr = errors.New("unknown command " + command + "; expected " + strings.Join(timeSeriesCommands, ", "))
+			}
+		}
+		if len(job.TimeColumn) == 0 {
+			jobErr = errors.New("no time-column")
+		}
+		if len(job.Format) > 0 && !contains(batchFormats, strings.ToLower(job.Format)) {
+			jobErr = errors.New("format must be one of " + strings.Join(batchFormats, ", "))
+		}
+		if len(job.Sources) == 0 {
+			jobErr = errors.New("no sources")
+		}
+		if jobErr != nil {
+			fail(strings.Join(job.Sources, ","), jobErr.Error())
+			continue
+		}
+		for _, pattern := range job.Sources {
+			matches, err := globSources(pattern) // also archive members
+			if err != nil {
+				fail(pattern, err.Error())
+				continue
+			}
+			sort.Strings(matches)
+			found := false
+			for _, source := range matches {
+				if isBatchIgnored(source) {
+					continue
+				}
+				found = true
+				if seen[source] {
+					tasks = append(tasks, &BatchTask{Job: ndx + 1, Source: source, Status: batchSkipped, Message: "listed by an earlier job", Accepted: -1})
+					continue
+				}
+				seen[source] = true
+				task := &BatchTask{Job: ndx + 1, Source: source, Accepted: -1}
+				task.Args, err = job.programArgs(source, commands)
+				if err == nil {
+					task.Database, err = summaryDatabase(source)
+				}
+				if err != nil {
+					task.Status = batchFailed
+					task.Message = err.Error()
+				} else if createDatabase && !contains(databases, task.Database) {
+					databases = append(databases, task.Database)
+				}
+				tasks = append(tasks, task)
+			}
+			if !found {
+				fail(pattern, "no files match")
+			}
+		}
+	}
+	return tasks, databases
+}
+
+// <source.csv> <timeMeasurementName> <commands> or <source.nc> <cdfType> <timeMeasurementName> <commands>, without createdb.
+func (job BatchJob) programArgs(source string, commands []string) ([]string, error) {
+	format := strings.ToLower(job.Format)
+	if len(format) == 0 {
+		format = strings.TrimPrefix(sourceExt(source), ".")
+	}
+	args := []string{source}
+	switch format {
+	case "nc":
+		if len(job.Type) == 0 {
+			return nil, errors.New("nc sources need a type: HDF5, netCDF-4 or classic")
+		}
+		args = append(args, job.Type)
+	case "csv":
+	default:
+		return nil, errors.New("unknown format of " + source + "; set format: csv or nc")
+	}
+	args = append(args, job.TimeColumn)
+	for _, command := range commands {
+		if command != timeSeriesCommands[0] {
+			args = append(args, command)
+		}
+	}
+	options := make([]string, 0, len(job.Options))
+	for key, value := range job.Options {
+		options = append(options, key+"="+value)
+	}
+	sort.Strings(options)
+	return append(append(args, options...), "precision="+iotdbPrecision), nil
+}
+
+// Create the databases that do not exist yet; returns the error of each database that could not be created.
+func (iot *IoTDbAccess) createBatchDatabases(databases []string) map[string]error {
+	failures := make(map[string]error, 0)
+	existing := make(map[string]bool, 0)
+	if !iot.DryRun {
+		timeout := tailQueryTimeout
+		sds, err := iot.session.ExecuteQueryStatement("SHOW DATABASES", &timeout)
+		if err != nil {
+			for _, database := range databases {
+				failures[database] = err
+			}
+			return failures
+		}
+		for next, err := sds.Next(); next && err == nil; next, err = sds.Next() {
+			existing[sds.GetText("Database")] = true
+		}
+		sds.Close()
+	}
+	for _, database := range databases {
+		if existing[database] {
+			fmt.Println("Database " + database + " exists")
+			continue
+		}
+		sql := "CREATE DATABASE " + database
+		if err := iot.executeNonQuery(sql); err != nil {
+			failures[database] = err
+			continue
+		}
+		if !iot.DryRun {
+			fmt.Println(sql)
+		}
+	}
+	return failures
+}
+
+// Run the netcdf process of the task with its output in the log folder; number keeps the logs of sources with the same name apart.
+func (task *BatchTask) Run(executable, logFolder string, number int) {
+	started := time.Now()
+	defer func() { task.Duration = time.Since(started) }()
+	datasetName := sourceBase(task.Source)
+	task.LogPath = filepath.Join(logFolder, fmt.Sprintf("%03d_%s.log", number, datasetName))
+	logFile, err := os.Create(task.LogPath)
+	if err != nil {
+		task.Status, task.Message = batchFailed, err.Error()
+		return
+	}
+	cmd := exec.Command(executable, task.Args...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	runErr := cmd.Run()
+	logFile.Close()
+
+	lastLine := task.readLog()
+	if runErr != nil {
+		task.Status, task.Message = batchFailed, runErr.Error()
+		if len(lastLine) > 0 {
+			task.Message = lastLine
+		}
+		return
+	}
+	task.Status = batchOk
+}
+
+// Pick the validated row counts out of the log; returns its last line.
+func (task *BatchTask) readLog() string {
+	file, err := os.Open(task.LogPath)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	lastLine := ""
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024) // insert progress dots share one line
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if match := validatedPattern.FindStringSubmatch(line); match != nil {
+			task.Accepted, _ = strconv.Atoi(match[2])
+			task.Rejected, _ = strconv.Atoi(match[3])
+		}
+		if len(line) > 0 {
+			lastLine = line
+		}
+	}
+	return lastLine
+}
+
+// Print one row per task; returns the number of failed tasks.
+func PrintBatchSummary(tasks []*BatchTask) int {
+	counts := make(map[string]int, 0)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "JOB\tSOURCE\tDATABASE\tSTATUS\tACCEPTED\tREJECTED\tSECONDS\tMESSAGE")
+	for _, task := range tasks {
+		counts[task.Status]++
+		accepted, rejected := "", ""
+		if task.Accepted >= 0 {
+			accepted, rejected = strconv.Itoa(task.Accepted), strconv.Itoa(task.Rejected)
+		}
+		message := task.Message
+		if task.Status == batchFailed && len(task.LogPath) > 0 {
+			message += " (" + task.LogPath + ")"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%.1f\t%s\n", task.Job, filepath.Base(task.Source), task.Database, task.Status, accepted, rejected, task.Duration.Seconds(), message)
+	}
+	w.Flush()
+	fmt.Printf("%d sources: %d ok, %d failed, %d skipped, %d planned\n", len(tasks), counts[batchOk], counts[batchFailed], counts[batchSkipped], counts[batchPlanned])
+	return counts[batchFailed]
+}
+
+// programArgs: batch <jobs.yaml> [concurrency=4] [dryrun=true] [logs=batch_logs]
+func ProcessBatch(programArgs []string) {
+	if len(programArgs) < 3 {
+		checkErr("batch", errors.New("expected: batch <jobs.yaml> [concurrency=4] [dryrun=true] [logs=batch_logs]"))
+	}
+	parameters := getProgramParameters(programArgs, batchParameters)
+	batch, err := ReadBatchFile(programArgs[2])
+	checkErr("ReadBatchFile", err)
+	concurrency := batch.Concurrency
+	if len(parameters["concurrency"]) > 0 {
+		concurrency, err = strconv.Atoi(parameters["concurrency"])
+		checkErr("concurrency", err)
+	}
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	tasks, databases := batch.Tasks()
+
+	iot := IoTDbAccess{DryRun: parameters["dryrun"] == "true"}
+	if !iot.DryRun {
+		iotdbConnection, ok := Init_IoTDB(true)
+		if !ok {
+			checkErr("Init_IoTDB: ", errors.New(iotdbConnection))
+		}
+		iot.session = client.NewSession(clientConfig)
+		checkErr("Open", iot.session.Open(false, 0))
+		iot.ActiveSession = true
+	}
+	failures := iot.createBatchDatabases(databases)
+	if iot.ActiveSession {
+		iot.session.Close()
+	}
+	pending := make([]*BatchTask, 0, len(tasks))
+	for _, task := range tasks {
+		if len(task.Status) > 0 {
+			continue
+		}
+		if err, ok := failures[task.Database]; ok {
+			task.Status, task.Message = batchFailed, "CREATE DATABASE "+task.Database+": "+err.Error()
+			continue
+		}
+		if iot.DryRun {
+			task.Status, task.Message = batchPlanned, "netcdf "+strings.Join(task.Args, " ")
+			continue
+		}
+		pending = append(pending, task)
+	}
+
+	if len(pending) > 0 {
+		executable, err := os.Executable()
+		checkErr("os.Executable", err)
+		checkErr("logs", os.MkdirAll(parameters["logs"], 0755))
+		fmt.Printf("Running %d sources, %d at a time; logs in %s\n", len(pending), concurrency, parameters["logs"])
+		var wg sync.WaitGroup
+		var mutex sync.Mutex
+		slots := make(chan struct{}, concurrency)
+		done := 0
+		for ndx, task := range pending {
+			wg.Add(1)
+			slots <- struct{}{}
+			go func(task *BatchTask, number int) {
+				defer wg.Done()
+				task.Run(executable, parameters["logs"], number)
+				<-slots
+				mutex.Lock()
+				done++
+				fmt.Printf("[%d/%d] %-7s %s (%.1fs)\n", done, len(pending), task.Status, task.Source, task.Duration.Seconds())
+				mutex.Unlock()
+			}(task, ndx+1)
+		}
+		wg.Wait()
+	}
+	if PrintBatchSummary(tasks) > 0 {
+		os.Exit(1)
+	}
+}
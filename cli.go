@@ -0,0 +1,513 @@
+package main // cli.go is the command tree of the netcdf program: netcdf <command> [flags] <arguments>; flags may come before or after the arguments.
+//   netcdf ingest --time-column utc_timestamp --commands createdb,createts,insert [--dry-run] <dataFile.csv>
+//   netcdf ingest --type HDF5 --time-column time <dataFile.nc>
+//   netcdf ingest --time-column timestamp --commands createdb,createts,insert /data/combed   (a folder or glob: one database, a device per file)
+//   netcdf query --format csv "SELECT * FROM root.ecobee.household.<id> LIMIT 2"
+//   netcdf export --format parquet --start 2017-01-01 root.ecobee.household.*
+//   netcdf serve | netcdf retag ecobee | netcdf describe root.ecobee.household.* | netcdf batch create.yaml
+// netcdf help <command> (or netcdf <command> --help) prints the flags of a command.
+// Settings are layered: netcdf.env, then a profile of the config file, then the command line. netcdf.env is read from the working directory
+// for the variables the shell has not exported. The config file is --config, $NETCDF_CONFIG or netcdf.yaml|netcdf.toml in the working directory:
+//   profile: lab                 # the profile used without --profile or $NETCDF_PROFILE
+//   profiles:
+//     lab:
+//       IOTDB_HOST: 10.103.4.83  # upper case keys are environment variables
+//       format: parquet          # the default of --format for every command that has it
+//       ingest.commands: createts,insert
+// The same profiles in TOML are [profiles.lab] tables of key = "value" lines ("ingest.commands" = "createts,insert").
+// Each command is translated into the positional arguments of the original form (netcdf <dataFile.csv> <timeMeasurementName> createts insert), which still works.
+
+import (
+	"bufio"
+	"errors"
+	"filesystem" // work module
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	envFileName       = "netcdf.env"
+	configFileName    = "netcdf"
+	defaultProfile    = "default"
+	environmentPrefix = "$" // cliFlag.Parameter of a flag that sets an environment variable
+	consumedParameter = "-" // cliFlag.Parameter of a flag that the command translates itself
+	commandKeySep     = "." // ingest.commands: a profile key of one command
+)
+
+var configExtensions = []string{".yaml", ".yml", ".toml"}
+
+// Defaults of the IoTDB connection when neither netcdf.env nor a profile gives them; the same as the --host... flags of configureIotdbAccess.
+var iotdbEnvironmentDefaults = map[string]string{"IOTDB_HOST": "127.0.0.1", "IOTDB_PORT": "6667", "IOTDB_USER": "root", "IOTDB_PASSWORD": "root"}
+
+// cliFlag is a named flag of a command.
+type cliFlag struct {
+	Name      string // without dashes: time-column
+	Parameter string // key=value program parameter; "" is Name without hyphens, $NAME an environment variable, "-" consumed by the command
+	Default   string
+	Usage     string
+	Bool      bool
+}
+
+// cliCommand is one command of the tree.
+type cliCommand struct {
+	Name      string
+	Arguments string // usage of the positional arguments
+	Summary   string
+	MinArgs   int
+	Flags     []cliFlag
+	Run       func(programArgs []string)
+	// The program arguments for Run, of which the last trailing are the key=value arguments given in the original form; the flag parameters
+	// go before those. nil uses: netcdf <command> <arguments> <key=value parameters>
+	ProgramArgs func(arguments []string, values map[string]string) (programArgs []string, trailing int, err error)
+}
+
+// ConfigFile holds the profiles of netcdf.yaml or netcdf.toml.
+type ConfigFile struct {
+	Profile  string                       `yaml:"profile" json:"profile"`
+	Profiles map[string]map[string]string `yaml:"profiles" json:"profiles"`
+}
+
+var iotdbFlags = []cliFlag{
+	{Name: "host", Parameter: "$IOTDB_HOST", Usage: "IoTDB host (IOTDB_HOST)"},
+	{Name: "port", Parameter: "$IOTDB_PORT", Usage: "IoTDB port (IOTDB_PORT)"},
+	{Name: "user", Parameter: "$IOTDB_USER", Usage: "IoTDB user (IOTDB_USER)"},
+	{Name: "password", Parameter: "$IOTDB_PASSWORD", Usage: "IoTDB password (IOTDB_PASSWORD)"},
+	{Name: "precision", Parameter: "$IOTDB_TIMESTAMP_PRECISION", Usage: "IoTDB timestamp_precision: ms, us or ns (IOTDB_TIMESTAMP_PRECISION)"},
+}
+
+var timeFlags = []cliFlag{
+	{Name: "time-layout", Usage: "Go layouts of the time column, separated by |"},
+	{Name: "timezone", Usage: "IANA zone of wall-clock times, e.g. Europe/Berlin (default UTC)"},
+	{Name: "ambiguous", Usage: "time of a repeated wall-clock hour: earlier, later or error"},
+	{Name: "epoch", Usage: "unit of epoch integers: auto, s, ms, us or ns"},
+}
+
+var cliCommands = []cliCommand{
+	{
+		Name: "ingest", Arguments: "<dataFile.csv|dataFile.nc|folder|glob ...>", MinArgs: 1,
+		Summary: "copy sensor data files into IoTDB; a folder or glob of CSV files becomes one database with a device per file",
+		Flags: append(append([]cliFlag{
+			{Name: "time-column", Parameter: consumedParameter, Usage: "case-sensitive name of the time column (default the primaryKey of datapackage.json)"},
+			{Name: "type", Parameter: consumedParameter, Usage: "NetCDF file type of a .nc file: HDF5, netCDF-4 or classic"},
+			{Name: "commands", Parameter: consumedParameter, Default: "createts,insert", Usage: "comma-separated: " + strings.Join(timeSeriesCommands, ", ")},
+			{Name: "datapackage", Usage: "Frictionless datapackage.json of the types, units and descriptions (default the one next to the data file)"},
+			{Name: "database", Usage: "database of a folder or glob of CSV files (default the identifier of the first summary file)"},
+			{Name: "adapter", Default: "auto", Usage: "adapter of raw dataset files: auto, toniot (date and time columns merged into utc_timestamp) or none"},
+			{Name: "dry-run", Bool: true, Usage: "print the IoTDB statements instead of executing them"},
+		}, timeFlags...), iotdbFlags...),
+		Run:         ProcessDataFile,
+		ProgramArgs: ingestProgramArgs,
+	},
+	{
+		Name: "query", Arguments: "\"<IoTDB SQL>\"", MinArgs: 1,
+		Summary: "run IoTDB statements separated by ';' and print the result sets",
+		Flags: append([]cliFlag{
+			{Name: "format", Default: "table", Usage: "table, csv or json"},
+			{Name: "fetch-size", Default: "1024", Usage: "rows fetched per page"},
+		}, iotdbFlags...),
+		Run: ProcessQuery,
+	},
+	{
+		Name: "export", Arguments: "<root.device|pattern>", MinArgs: 1,
+		Summary: "write a time range of devices to CSV or Parquet files",
+		Flags: append([]cliFlag{
+			{Name: "start", Usage: "first time (ISO 8601 or epoch)"},
+			{Name: "end", Usage: "time after the last row (default now)"},
+			{Name: "measurements", Usage: "comma-separated measurement aliases (default all)"},
+			{Name: "format", Default: "csv", Usage: "csv or parquet"},
+			{Name: "output", Default: ".", Usage: "output folder"},
+			{Name: "chunk-mb", Default: "256", Usage: "megabytes per file; 0 writes one file per device"},
+			{Name: "names", Default: "original", Usage: "column headers: original or alias"},
+			{Name: "source", Usage: "the ingested data file, whose summary names the series created without ATTRIBUTES('name')"},
+			{Name: "datapackage", Usage: "datapackage.json of the original data whose description, licenses and sources the exported datapackage.json keeps"},
+			{Name: "license", Usage: "license of the exported data package, e.g. ODC-BY-1.0"},
+		}, iotdbFlags...),
+		Run: ProcessExport,
+	},
+	{
+		Name:    "serve",
+		Summary: "stream sensor data over gRPC, WebSocket, REST and Server-Sent Events",
+		Flags: append([]cliFlag{
+			{Name: "grpc-port", Parameter: "$GRPCPORT", Usage: "gRPC port (GRPCPORT)"},
+			{Name: "ws-port", Parameter: "$WSPORT", Usage: "WebSocket port (WSPORT)"},
+			{Name: "http-port", Parameter: "$HTTPPORT", Usage: "REST and SSE port (HTTPPORT)"},
+		}, iotdbFlags...),
+		Run: ServeSensorStreams,
+	},
+	{
+		Name: "retag", Arguments: "<group>[,<group>]|all", MinArgs: 1,
+		Summary: "upsert the ATTRIBUTES and TAGS of a dataset group: " + strings.Join(retagGroupNames(), ", "),
+		Flags: append([]cliFlag{
+			{Name: "dry-run", Bool: true, Usage: "print the ALTER statements instead of executing them"},
+		}, iotdbFlags...),
+		Run: ProcessRetag,
+	},
+	{
+		Name: "describe", Arguments: "<root.device|pattern>", MinArgs: 1,
+		Summary: "list the devices of a pattern and the attributes and units of their time series",
+		Flags: append([]cliFlag{
+			{Name: "format", Default: "table", Usage: "table, csv or json"},
+		}, iotdbFlags...),
+		Run: ProcessDescribe,
+	},
+	{
+		Name: "batch", Arguments: "<jobs.yaml>", MinArgs: 1,
+		Summary: "ingest the sources of a job file concurrently, creating each database once, and print a table of outcomes",
+		Flags: append([]cliFlag{
+			{Name: "concurrency", Usage: "sources ingested at a time (default the job file's concurrency, else 1)"},
+			{Name: "dry-run", Bool: true, Usage: "print the plan instead of running it"},
+			{Name: "logs", Default: "batch_logs", Usage: "folder of the output of each source"},
+		}, iotdbFlags...),
+		Run: ProcessBatch,
+	},
+}
+
+// Run the data file command of the original form: .csv or .nc.
+func ProcessDataFile(programArgs []string) {
+	if sourceExt(programArgs[1]) == ".nc" {
+		ProcessNcSensorData(programArgs)
+	} else {
+		ProcessCsvSensorData(programArgs)
+	}
+}
+
+// <dataFile.csv>... <timeMeasurementName> <commands> or <dataFile.nc> <cdfType> <timeMeasurementName> <commands>, then the key=value arguments.
+func ingestProgramArgs(arguments []string, values map[string]string) ([]string, int, error) {
+	sources := 1 // a folder, glob or several CSV files: ingestdir.go
+	for sources < len(arguments) && sourceExt(arguments[0]) == csvExtension && isIngestSource(arguments[sources]) {
+		sources++
+	}
+	timeColumn := values["time-column"]
+	if files, _, err := expandIngestSources(append([]string{os.Args[0]}, arguments[:sources]...)); len(timeColumn) == 0 && err == nil && len(files) > 0 {
+		timeColumn = dataPackageTimeColumn(files[0], []string{"datapackage=" + values["datapackage"]}) // primaryKey of datapackage.json
+		if len(timeColumn) == 0 && values["adapter"] != "none" && isToniotFile(files[0]) {
+			timeColumn = toniotTimeColumn // merged from the date and time columns
+		}
+	}
+	if len(timeColumn) == 0 {
+		return nil, 0, errors.New("ingest needs --time-column, or a datapackage.json with a primaryKey")
+	}
+	programArgs := append([]string{os.Args[0]}, arguments[:sources]...)
+	if sourceExt(arguments[0]) == ".nc" {
+		if len(values["type"]) == 0 {
+			return nil, 0, errors.New("ingest of a .nc file needs --type HDF5|netCDF-4|classic")
+		}
+		programArgs = append(programArgs, values["type"])
+	}
+	programArgs = append(programArgs, timeColumn)
+	for _, command := range splitList(values["commands"]) {
+		if !contains(timeSeriesCommands, command) {
+			return nil, 0, errors.New("unknown command " + command + "; expected " + strings.Join(timeSeriesCommands, ", "))
+		}
+		programArgs = append(programArgs, command)
+	}
+	return append(programArgs, arguments[sources:]...), len(arguments) - sources, nil
+}
+
+func findCliCommand(name string) (cliCommand, bool) {
+	for _, command := range cliCommands {
+		if command.Name == strings.ToLower(name) {
+			return command, true
+		}
+	}
+	return cliCommand{}, false
+}
+
+func (flag cliFlag) parameter() string {
+	if len(flag.Parameter) == 0 {
+		return strings.ReplaceAll(flag.Name, "-", "")
+	}
+	return flag.Parameter
+}
+
+func (command cliCommand) usage() string {
+	return "netcdf " + command.Name + " [flags] " + command.Arguments
+}
+
+func (command cliCommand) PrintHelp() {
+	fmt.Println(command.usage())
+	fmt.Println("  " + command.Summary)
+	fs := command.flagSet(nil)
+	fs.SetOutput(os.Stdout)
+	fs.PrintDefaults()
+}
+
+// The flags of the command with the defaults of profile.
+func (command cliCommand) flagSet(profile map[string]string) *flag.FlagSet {
+	fs := flag.NewFlagSet(command.Name, flag.ContinueOnError)
+	for _, cf := range command.Flags {
+		value := cf.Default
+		if profileValue, ok := profile[cf.Name]; ok {
+			value = profileValue
+		}
+		if profileValue, ok := profile[command.Name+commandKeySep+cf.Name]; ok {
+			value = profileValue
+		}
+		if cf.Bool {
+			fs.Bool(cf.Name, value == "true", cf.Usage)
+		} else {
+			fs.String(cf.Name, value, cf.Usage)
+		}
+	}
+	fs.String("config", "", "config file of profiles (default $NETCDF_CONFIG or netcdf.yaml|netcdf.toml)")
+	fs.String("profile", "", "profile of the config file (default $NETCDF_PROFILE, the file's profile, or default)")
+	fs.Usage = command.PrintHelp
+	return fs
+}
+
+// Flags may follow the arguments: the standard flag package stops at the first argument, so parse again after each one.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	arguments := make([]string, 0)
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return arguments, nil
+		}
+		arguments = append(arguments, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// The value of --name in args before they are parsed, so the profile can provide the defaults of the other flags.
+func peekFlag(args []string, name string) string {
+	for ndx, arg := range args {
+		arg = strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+		if value, found := strings.CutPrefix(arg, name+"="); found {
+			return value
+		}
+		if arg == name && ndx+1 < len(args) && len(args[ndx]) > len(name) {
+			return args[ndx+1]
+		}
+	}
+	return ""
+}
+
+// Read the export KEY=value lines of netcdf.env; variables already in the environment win.
+func loadEnvFile(fileName string) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, found := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		if !found {
+			continue
+		}
+		if comment := strings.Index(value, " #"); comment >= 0 {
+			value = value[:comment]
+		}
+		key = strings.TrimSpace(key)
+		if _, ok := os.LookupEnv(key); !ok {
+			os.Setenv(key, strings.Trim(strings.TrimSpace(value), "\"'"))
+		}
+	}
+	return scanner.Err()
+}
+
+// Find and read the config file; "" with no error when there is none.
+func LoadConfigFile(fileName string) (ConfigFile, error) {
+	var config ConfigFile
+	if len(fileName) == 0 {
+		fileName = os.Getenv("NETCDF_CONFIG")
+	}
+	if len(fileName) == 0 {
+		for _, extension := range configExtensions {
+			if exists, _ := filesystem.FileExists(configFileName + extension); exists {
+				fileName = configFileName + extension
+				break
+			}
+		}
+		if len(fileName) == 0 {
+			return config, nil
+		}
+	}
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return config, err
+	}
+	if strings.ToLower(filepath.Ext(fileName)) == ".toml" {
+		config, err = parseTomlProfiles(string(data))
+	} else {
+		err = yaml.Unmarshal(data, &config)
+	}
+	if err != nil {
+		return config, errors.New(fileName + ": " + err.Error())
+	}
+	return config, nil
+}
+
+// The profile named by --profile, $NETCDF_PROFILE or the config file; an unknown name is an error unless it is the default profile.
+func (config ConfigFile) SelectProfile(name string) (map[string]string, error) {
+	if len(name) == 0 {
+		name = os.Getenv("NETCDF_PROFILE")
+	}
+	if len(name) == 0 {
+		name = config.Profile
+	}
+	if len(name) == 0 {
+		name = defaultProfile
+	}
+	profile, ok := config.Profiles[name]
+	if !ok && name != defaultProfile {
+		names := make([]string, 0, len(config.Profiles))
+		for profileName := range config.Profiles {
+			names = append(names, profileName)
+		}
+		sort.Strings(names)
+		return nil, errors.New("no profile " + name + " in the config file; profiles: " + strings.Join(names, ", "))
+	}
+	return profile, nil
+}
+
+// The subset of TOML used by profiles: a top-level profile = "name" and [profiles.<name>] tables of key = value lines.
+func parseTomlProfiles(text string) (ConfigFile, error) {
+	config := ConfigFile{Profiles: make(map[string]map[string]string, 0)}
+	var profile map[string]string
+	for lineNumber, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			table := strings.Trim(strings.TrimSpace(strings.Trim(line, "[]")), "\"")
+			name, found := strings.CutPrefix(table, "profiles.")
+			if !found || !strings.HasSuffix(line, "]") {
+				return config, fmt.Errorf("line %d: expected [profiles.<name>]: %s", lineNumber+1, line)
+			}
+			profile = make(map[string]string, 0)
+			config.Profiles[strings.Trim(name, "\"")] = profile
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			return config, fmt.Errorf("line %d: expected key = value: %s", lineNumber+1, line)
+		}
+		key = strings.Trim(strings.TrimSpace(key), "\"")
+		value = strings.TrimSpace(value)
+		if strings.HasPrefix(value, "\"") || strings.HasPrefix(value, "'") {
+			end := strings.LastIndex(value, value[:1])
+			if end <= 0 {
+				return config, fmt.Errorf("line %d: unterminated string: %s", lineNumber+1, line)
+			}
+			value = value[1:end]
+		} else if comment := strings.Index(value, "#"); comment >= 0 {
+			value = strings.TrimSpace(value[:comment])
+		}
+		if profile == nil {
+			if key == "profile" {
+				config.Profile = value
+			}
+			continue
+		}
+		profile[key] = value
+	}
+	return config, nil
+}
+
+// Translate netcdf <command> [flags] <arguments> into the program arguments of command.Run; settings become environment variables.
+func (command cliCommand) Parse(args []string) ([]string, error) {
+	config, err := LoadConfigFile(peekFlag(args, "config"))
+	if err != nil {
+		return nil, err
+	}
+	profile, err := config.SelectProfile(peekFlag(args, "profile"))
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range profile {
+		if key == strings.ToUpper(key) && !strings.Contains(key, commandKeySep) {
+			os.Setenv(key, value)
+		}
+	}
+	fs := command.flagSet(profile)
+	arguments, err := parseInterspersed(fs, args)
+	if err != nil {
+		return nil, err
+	}
+	if len(arguments) < command.MinArgs {
+		return nil, errors.New("expected: " + command.usage())
+	}
+	values := make(map[string]string, 0)
+	fs.VisitAll(func(f *flag.Flag) { values[f.Name] = f.Value.String() })
+	given := make(map[string]bool, 0) // on the command line or by the profile; the commands have the same defaults.
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	parameters := make([]string, 0)
+	for _, cf := range command.Flags {
+		value := values[cf.Name]
+		switch parameter := cf.parameter(); {
+		case parameter == consumedParameter:
+		case !given[cf.Name] && value == cf.Default:
+		case strings.HasPrefix(parameter, environmentPrefix):
+			if len(value) > 0 {
+				os.Setenv(strings.TrimPrefix(parameter, environmentPrefix), value)
+			}
+		case cf.Bool:
+			if value == "true" {
+				parameters = append(parameters, parameter+"=true")
+			}
+		case len(value) > 0:
+			parameters = append(parameters, parameter+"="+value)
+		}
+	}
+	for key, value := range iotdbEnvironmentDefaults {
+		if len(os.Getenv(key)) == 0 {
+			os.Setenv(key, value)
+		}
+	}
+	programArgs, trailing := append([]string{os.Args[0], command.Name}, arguments...), len(arguments)-command.MinArgs
+	if command.ProgramArgs != nil {
+		programArgs, trailing, err = command.ProgramArgs(arguments, values)
+		if err != nil {
+			return nil, err
+		}
+	}
+	extra := len(programArgs) - trailing // key=value arguments of the original form follow and win
+	return append(append(programArgs[:extra:extra], parameters...), programArgs[extra:]...), nil
+}
+
+// netcdf <command> ...: true if the command belongs to the tree; it has then been run.
+func RunCliCommand(args []string) bool {
+	if len(args) < 2 {
+		return false
+	}
+	if strings.ToLower(args[1]) == "help" && len(args) > 2 {
+		command, ok := findCliCommand(args[2])
+		if ok {
+			command.PrintHelp()
+			os.Exit(0)
+		}
+		return false
+	}
+	command, ok := findCliCommand(args[1])
+	if !ok {
+		return false
+	}
+	checkErr("netcdf.env", loadEnvFile(envFileName))
+	programArgs, err := command.Parse(args[2:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	checkErr("netcdf "+command.Name, err)
+	os.Args = programArgs // configureIotdbAccess reads os.Args
+	checkErr("configureIotdbPrecision", configureIotdbPrecision(programArgs))
+	command.Run(programArgs)
+	return true
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestParseIngestSources(t *testing.T) {
+	ingest, ok := findCliCommand("ingest")
+	if !ok {
+		t.Fatal("no ingest command")
+	}
+	flags := []string{"--time-column", "timestamp", "--database", "root.combed", "--commands", "createdb,createts,insert", "--dry-run"}
+	parameters := []string{"database=root.combed", "dryrun=true"}
+	commands := []string{"timestamp", "createdb", "createts", "insert"}
+	tests := []struct {
+		name    string
+		sources []string
+	}{
+		{"one source", []string{"f1.csv"}},
+		{"two sources", []string{"f1.csv", "f2.csv"}},
+		{"five sources", []string{"f1.csv", "f2.csv", "f3.csv", "f4.csv", "f5.csv"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			args := append(append(append([]string{}, flags...), test.sources...), "timezone=UTC")
+			programArgs, err := ingest.Parse(args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := append([]string{os.Args[0]}, test.sources...)
+			expected = append(append(append(expected, commands...), parameters...), "timezone=UTC")
+			if !reflect.DeepEqual(programArgs, expected) {
+				t.Errorf("Parse(%v)\n got  %v\n want %v", args, programArgs, expected)
+			}
+		})
+	}
+}
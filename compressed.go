@@ -0,0 +1,430 @@
+package main // compressed.go opens data, summary and NetCDF files that are compressed or inside an archive, decompressing them while they are read.
+// Formats are recognized by their magic bytes, which must agree with the extension: .gz, .bz2, .zst, .zip and .tar (also .tgz, .tar.gz, .tar.bz2, .tar.zst).
+// A compressed file without such an extension is still recognized, so a gzipped file named data.csv reads as CSV.
+// An archive member is addressed as a path below the archive: /data/opsd_time_series.zip/time_series_60min_singleindex.csv, /data/raw.tar.bz2/AMP/Water_DWW.csv
+// Members may be compressed too (raw.zip/Jan_clean.nc.gz), a glob below an archive lists its members, and a zip of one file also opens by itself.
+// A path that does not exist is also looked up with each compression extension, so Jan_clean.nc stands for Jan_clean.nc.gz.
+// Datasets, devices and summary files take the name of the uncompressed file: summary_Jan_clean.csv is the summary of Jan_clean.nc.gz.
+// The summary of an archive member is looked up next to the archive first, then inside it; quality reports go next to the archive.
+// NetCDF files are read by the C library, so a compressed one is decompressed to a temporary file first (localSource).
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	formatGzip  = "gzip"
+	formatBzip2 = "bzip2"
+	formatZstd  = "zstd"
+	formatZip   = "zip"
+	formatTar   = "tar"
+	magicPeek   = 512 // a tar header
+)
+
+// Outermost format of each extension; .tgz is a gzip stream holding a tar archive.
+var compressionFormats = map[string]string{".gz": formatGzip, ".gzip": formatGzip, ".tgz": formatGzip, ".bz2": formatBzip2, ".tbz2": formatBzip2, ".zst": formatZstd, ".zstd": formatZstd}
+var compressionExtensions = []string{".gz", ".bz2", ".zst", ".gzip", ".zstd"} // of a single compressed file
+var archiveExtensions = []string{".zip", ".tar", ".tgz", ".tbz2", ".tar.gz", ".tar.bz2", ".tar.zst"}
+
+// The format of the first bytes of a file, or "" for an uncompressed file.
+func detectFormat(head []byte) string {
+	switch {
+	case len(head) >= 2 && head[0] == 0x1f && head[1] == 0x8b:
+		return formatGzip
+	case len(head) >= 3 && string(head[:3]) == "BZh":
+		return formatBzip2
+	case len(head) >= 4 && head[0] == 0x28 && head[1] == 0xb5 && head[2] == 0x2f && head[3] == 0xfd:
+		return formatZstd
+	case len(head) >= 4 && string(head[:4]) == "PK\x03\x04":
+		return formatZip
+	case len(head) >= 262 && string(head[257:262]) == "ustar":
+		return formatTar
+	}
+	return ""
+}
+
+// Strip the compression extensions: /data/Jan_clean.nc.gz is /data/Jan_clean.nc.
+func sourceName(filePath string) string {
+	for contains(compressionExtensions, strings.ToLower(filepath.Ext(filePath))) {
+		filePath = strings.TrimSuffix(filePath, filepath.Ext(filePath))
+	}
+	return filePath
+}
+
+// Lower case extension of the uncompressed file: .csv for a.csv.gz.
+func sourceExt(filePath string) string {
+	return strings.ToLower(filepath.Ext(sourceName(filePath)))
+}
+
+// Name of the uncompressed file without folder and extension: the DatasetName of a data file.
+func sourceBase(filePath string) string {
+	name := filepath.Base(sourceName(filePath))
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+func isArchiveName(filePath string) bool {
+	for _, extension := range archiveExtensions {
+		if strings.HasSuffix(strings.ToLower(filePath), extension) {
+			return true
+		}
+	}
+	return false
+}
+
+// Split a path below an archive file into the archive and the member; member is "" for a path that exists.
+func splitArchivePath(filePath string) (string, string) {
+	if _, err := os.Stat(filePath); err == nil {
+		return filePath, ""
+	}
+	cleaned := filepath.ToSlash(filepath.Clean(filePath))
+	for ndx := 1; ndx < len(cleaned); ndx++ {
+		if cleaned[ndx] != '/' || !isArchiveName(cleaned[:ndx]) {
+			continue
+		}
+		if info, err := os.Stat(filepath.FromSlash(cleaned[:ndx])); err == nil && info.Mode().IsRegular() {
+			return filepath.FromSlash(cleaned[:ndx]), cleaned[ndx+1:]
+		}
+	}
+	return filePath, ""
+}
+
+// The file on disk and the archive member of filePath, trying each compression extension when it does not exist.
+func resolveSource(filePath string) (string, string, error) {
+	if archive, member := splitArchivePath(filePath); len(member) > 0 {
+		return archive, member, nil
+	}
+	if _, err := os.Stat(filePath); err == nil {
+		return filePath, "", nil
+	}
+	for _, extension := range compressionExtensions {
+		if _, err := os.Stat(filePath + extension); err == nil {
+			return filePath + extension, "", nil
+		}
+	}
+	return "", "", &fs.PathError{Op: "open", Path: filePath, Err: fs.ErrNotExist}
+}
+
+// True when filePath is a file, a folder, a compressed variant or an archive member path; members are not looked up.
+func sourceExists(filePath string) bool {
+	_, _, err := resolveSource(filePath)
+	return err == nil
+}
+
+// Folder on disk of filePath, where reports about it are written: the folder of the archive for a member.
+func sourceFolder(filePath string) string {
+	archive, member, err := resolveSource(filePath)
+	if err == nil && len(member) > 0 {
+		return filepath.Dir(archive)
+	}
+	return filepath.Dir(filePath)
+}
+
+// Closes the decompressors and the file of a source.
+type sourceReader struct {
+	io.Reader
+	closers []io.Closer
+	layers  int // decompressors
+}
+
+func (reader *sourceReader) Close() error {
+	var err error
+	for ndx := len(reader.closers) - 1; ndx >= 0; ndx-- {
+		if closeErr := reader.closers[ndx].Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// Add a decompressor of format to the stream of reader.
+func (reader *sourceReader) decompress(format string) error {
+	reader.layers++
+	switch format {
+	case formatGzip:
+		gzipReader, err := gzip.NewReader(reader.Reader)
+		if err != nil {
+			return err
+		}
+		reader.Reader, reader.closers = gzipReader, append(reader.closers, gzipReader)
+	case formatBzip2:
+		reader.Reader = bzip2.NewReader(reader.Reader)
+	case formatZstd:
+		decoder, err := zstd.NewReader(reader.Reader)
+		if err != nil {
+			return err
+		}
+		reader.Reader, reader.closers = decoder, append(reader.closers, decoder.IOReadCloser())
+	}
+	return nil
+}
+
+// Peek at the magic bytes of the stream; the format the extension of name expects must be found.
+func (reader *sourceReader) detect(name string) (string, error) {
+	buffered := bufio.NewReaderSize(reader.Reader, magicPeek)
+	reader.Reader = buffered
+	head, _ := buffered.Peek(magicPeek)
+	format := detectFormat(head)
+	extension := strings.ToLower(filepath.Ext(name))
+	if expected, ok := compressionFormats[extension]; ok && format != expected {
+		return format, errors.New(name + " is not a " + expected + " file")
+	}
+	if (extension == ".zip" && format != formatZip) || (extension == ".tar" && format != formatTar) {
+		return format, errors.New(name + " is not a " + strings.TrimPrefix(extension, ".") + " file")
+	}
+	return format, nil
+}
+
+// Decompress the stream of name layer by layer: .csv.gz, .tar.bz2, .nc.zst. Returns the format left: tar, zip or "".
+func (reader *sourceReader) open(name string) (string, error) {
+	for {
+		format, err := reader.detect(name)
+		if err != nil || (format != formatGzip && format != formatBzip2 && format != formatZstd) {
+			return format, err
+		}
+		if err = reader.decompress(format); err != nil {
+			return format, errors.New(name + ": " + err.Error())
+		}
+		switch extension := strings.ToLower(filepath.Ext(name)); extension {
+		case ".tgz", ".tbz2":
+			name = strings.TrimSuffix(name, filepath.Ext(name)) + ".tar"
+		default:
+			if _, ok := compressionFormats[extension]; ok {
+				name = strings.TrimSuffix(name, filepath.Ext(name))
+			}
+		}
+	}
+}
+
+// Open a data, summary or NetCDF file for reading, decompressing it and finding the archive member of the path.
+func OpenSource(filePath string) (io.ReadCloser, error) {
+	fileName, member, err := resolveSource(filePath)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	reader := &sourceReader{Reader: file, closers: []io.Closer{file}}
+	format, err := reader.open(filepath.Base(fileName))
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+	switch format {
+	case formatZip:
+		return reader.openZipMember(file, fileName, member)
+	case formatTar:
+		return reader.openTarMember(fileName, member)
+	}
+	if len(member) > 0 {
+		reader.Close()
+		return nil, errors.New(fileName + " is not an archive: " + member)
+	}
+	return reader, nil
+}
+
+// Name of an archive member as a path below the archive.
+func memberName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(name)), "/")
+}
+
+// The member of a zip archive; a zip of one file is opened without a member. Zip needs random access, so the archive must not itself be compressed.
+func (reader *sourceReader) openZipMember(file *os.File, fileName, member string) (io.ReadCloser, error) {
+	if reader.layers > 0 {
+		reader.Close()
+		return nil, errors.New(fileName + ": a compressed zip archive cannot be read")
+	}
+	info, err := file.Stat()
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+	archive, err := zip.NewReader(file, info.Size())
+	if err != nil {
+		reader.Close()
+		return nil, errors.New(fileName + ": " + err.Error())
+	}
+	var found *zip.File
+	files := make([]*zip.File, 0, len(archive.File))
+	for _, zipFile := range archive.File {
+		if !zipFile.FileInfo().IsDir() {
+			files = append(files, zipFile)
+		}
+		if len(member) > 0 && memberName(zipFile.Name) == memberName(member) {
+			found = zipFile
+		}
+	}
+	if len(member) == 0 && len(files) == 1 {
+		found = files[0]
+	}
+	if found == nil {
+		reader.Close()
+		if len(member) == 0 {
+			return nil, errors.New(fileName + " has " + strconv.Itoa(len(files)) + " files; name one as " + fileName + "/<member>")
+		}
+		return nil, &fs.PathError{Op: "open", Path: fileName + "/" + member, Err: fs.ErrNotExist}
+	}
+	memberReader, err := found.Open()
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+	reader.Reader, reader.closers = memberReader, append(reader.closers, memberReader)
+	if format, err := reader.open(found.Name); err != nil || len(format) > 0 {
+		reader.Close()
+		if err == nil {
+			err = errors.New(fileName + "/" + found.Name + ": nested archives are not read")
+		}
+		return nil, err
+	}
+	return reader, nil
+}
+
+// The member of a tar archive, read as a stream up to the member.
+func (reader *sourceReader) openTarMember(fileName, member string) (io.ReadCloser, error) {
+	if len(member) == 0 {
+		reader.Close()
+		return nil, errors.New(fileName + " is a tar archive; name a member as " + fileName + "/<member>")
+	}
+	archive := tar.NewReader(reader.Reader)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			reader.Close()
+			return nil, &fs.PathError{Op: "open", Path: fileName + "/" + member, Err: fs.ErrNotExist}
+		}
+		if err != nil {
+			reader.Close()
+			return nil, errors.New(fileName + ": " + err.Error())
+		}
+		if header.Typeflag == tar.TypeReg && memberName(header.Name) == memberName(member) {
+			reader.Reader = archive
+			if format, err := reader.open(header.Name); err != nil || len(format) > 0 {
+				reader.Close()
+				if err == nil {
+					err = errors.New(fileName + "/" + header.Name + ": nested archives are not read")
+				}
+				return nil, err
+			}
+			return reader, nil
+		}
+	}
+}
+
+// The regular files of an archive, as paths below it.
+func archiveMembers(fileName string) ([]string, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	reader := &sourceReader{Reader: file, closers: []io.Closer{file}}
+	defer reader.Close()
+	format, err := reader.open(filepath.Base(fileName))
+	if err != nil {
+		return nil, err
+	}
+	members := make([]string, 0)
+	switch {
+	case format == formatZip && reader.layers == 0:
+		info, err := file.Stat()
+		if err != nil {
+			return nil, err
+		}
+		archive, err := zip.NewReader(file, info.Size())
+		if err != nil {
+			return nil, err
+		}
+		for _, zipFile := range archive.File {
+			if !zipFile.FileInfo().IsDir() {
+				members = append(members, filepath.Join(fileName, filepath.FromSlash(memberName(zipFile.Name))))
+			}
+		}
+	case format == formatTar:
+		archive := tar.NewReader(reader.Reader)
+		for header, err := archive.Next(); err != io.EOF; header, err = archive.Next() {
+			if err != nil {
+				return nil, err
+			}
+			if header.Typeflag == tar.TypeReg {
+				members = append(members, filepath.Join(fileName, filepath.FromSlash(memberName(header.Name))))
+			}
+		}
+	default:
+		return nil, errors.New(fileName + " is not an archive")
+	}
+	return members, nil
+}
+
+// filepath.Glob that also matches the members below an archive: /data/opsd.zip/*.csv
+func globSources(pattern string) ([]string, error) {
+	matches, err := filepath.Glob(pattern)
+	if err != nil || len(matches) > 0 {
+		return matches, err
+	}
+	archive, memberPattern := splitArchivePath(pattern)
+	if len(memberPattern) == 0 {
+		return matches, nil
+	}
+	members, err := archiveMembers(archive)
+	if err != nil {
+		return nil, err
+	}
+	for _, member := range members {
+		if matched, _ := filepath.Match(filepath.Join(archive, filepath.FromSlash(memberPattern)), member); matched {
+			matches = append(matches, member)
+		}
+	}
+	return matches, nil
+}
+
+// A file path the NetCDF library can open: the file itself, or a temporary copy of its decompressed content. Call cleanup when done.
+func localSource(filePath string) (string, func(), error) {
+	fileName, member, err := resolveSource(filePath)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(member) == 0 {
+		if file, err := os.Open(fileName); err == nil {
+			head := make([]byte, magicPeek)
+			n, _ := io.ReadFull(file, head)
+			file.Close()
+			if len(detectFormat(head[:n])) == 0 {
+				return fileName, func() {}, nil
+			}
+		}
+	}
+	reader, err := OpenSource(filePath)
+	if err != nil {
+		return "", nil, err
+	}
+	defer reader.Close()
+	temp, err := os.CreateTemp("", "netcdf-*"+sourceExt(filePath))
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.Remove(temp.Name()) }
+	_, err = io.Copy(temp, reader)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, errors.New(filePath + ": " + err.Error())
+	}
+	return temp.Name(), cleanup, nil
+}
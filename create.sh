@@ -1,131 +1,4 @@
-./netcdf /home/david/Documents/digital-twins/smart.home.weather/HomeC.csv time createdb createts insert
-read -p "?"
-
-./netcdf /home/david/Documents/digital-twins/opsd.household/1min_singleindex.csv utc_timestamp createdb createts insert
-read -p "?"
-./netcdf /home/david/Documents/digital-twins/opsd.household/60min_singleindex.csv utc_timestamp createts insert
-./netcdf /home/david/Documents/digital-twins/opsd.household/15min_singleindex.csv utc_timestamp createts insert
-
-./netcdf /home/david/Documents/digital-twins/ton.iot/processed/IoT_Modbus.csv utc_timestamp createdb createts insert
-read -p "?"
-./netcdf /home/david/Documents/digital-twins/ton.iot/processed/IoT_Weather.csv utc_timestamp createts insert
-./netcdf /home/david/Documents/digital-twins/ton.iot/processed/IoT_Motion_Light.csv utc_timestamp createts insert
-./netcdf /home/david/Documents/digital-twins/ton.iot/processed/IoT_Thermostat.csv utc_timestamp createts insert
-
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/lecture_AHU_Current2.csv utctimestamp createdb createts insert
-read -p "?"
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/Floor_Total_Current4.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/AHU_Power1.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/Floor_Total_Current1.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/lecture_Floor_Total_Energy2.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/lecture_AHU_Current1.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/AHU_Current3.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/Floor_Total_Power2.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/Building_Total_Mains_Energy.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/Floor_Total_Current3.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/lecture_Building_Total_Mains_Energy.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/lecture_Floor_Total_Power2.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/Building_Total_Mains_Power.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/AHU_Power0.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/Floor_Total_Energy1.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/lecture_AHU_Energy2.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/lecture_Floor_Total_Energy1.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/Power_Sockets_Energy.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/Floor_Total_Energy2.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/AHU_Current0.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/Lifts_Current.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/Lifts_Energy.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/lecture_Floor_Total_Energy0.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/AHU_Energy0.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/Light_Current.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/lecture_AHU_Power2.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/lecture_Floor_Total_Power0.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/Floor_Total_Power3.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/lecture_Building_Total_Mains_Power.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/Floor_Total_Current2.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/AHU_Power2.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/AHU_Current1.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/Floor_Total_Power4.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/Floor_Total_Power1.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/AHU_Current2.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/AHU_Energy2.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/lecture_AHU_Energy1.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/Floor_Total_Energy4.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/Light_Power.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/UPS_Sockets_Energy.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/Lifts_Power.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/Power_Sockets_Current.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/Floor_Total_Energy3.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/Power_Sockets_Power.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/AHU_Energy1.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/lecture_Building_Total_Mains_Current.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/Building_Total_Mains_Current.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/lecture_Floor_Total_Power1.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/Light_Energy.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/lecture_AHU_Current0.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/Floor_Total_Power5.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/AHU_Energy3.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/UPS_Sockets_Power.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/lecture_Floor_Total_Current0.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/lecture_AHU_Energy0.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/Floor_Total_Current5.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/AHU_Power3.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/Floor_Total_Energy5.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/lecture_AHU_Power1.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/lecture_AHU_Power0.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/UPS_Sockets_Current.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/lecture_Floor_Total_Current1.csv utctimestamp createts insert
-./netcdf /home/david/Documents/digital-twins/combed.iiitd/datasets/lecture_Floor_Total_Current2.csv utctimestamp createts insert
-
-./netcdf /home/david/Documents/digital-twins/ecobee/clean_data/Jan_clean.nc netCDF-4 time1 createdb createts insert
-read -p "?"
-./netcdf /home/david/Documents/digital-twins/ecobee/clean_data/Feb_clean.nc netCDF-4 time1 createdb createts insert
-./netcdf /home/david/Documents/digital-twins/ecobee/clean_data/Mar_clean.nc netCDF-4 time1 createts insert
-./netcdf /home/david/Documents/digital-twins/ecobee/clean_data/Apr_clean.nc netCDF-4 time1 createts insert
-./netcdf /home/david/Documents/digital-twins/ecobee/clean_data/May_clean.nc netCDF-4 time1 createts insert
-./netcdf /home/david/Documents/digital-twins/ecobee/clean_data/Jun_clean.nc netCDF-4 time1 createts insert
-./netcdf /home/david/Documents/digital-twins/ecobee/clean_data/Jul_clean.nc netCDF-4 time1 createts insert
-./netcdf /home/david/Documents/digital-twins/ecobee/clean_data/Aug_clean.nc netCDF-4 time1 createts insert
-./netcdf /home/david/Documents/digital-twins/ecobee/clean_data/Sep_clean.nc netCDF-4 time1 createts insert
-./netcdf /home/david/Documents/digital-twins/ecobee/clean_data/Oct_clean.nc netCDF-4 time1 createts insert
-./netcdf /home/david/Documents/digital-twins/ecobee/clean_data/Nov_clean.nc netCDF-4 time1 createts insert
-./netcdf /home/david/Documents/digital-twins/ecobee/clean_data/Dec_clean.nc netCDF-4 time1 createts insert
-
-./netcdf /home/david/Documents/digital-twins/AMP/Electricity_B1E.csv unix_ts createdb createts insert
-read -p "?"
-./netcdf /home/david/Documents/digital-twins/AMP/Electricity_B2E.csv unix_ts createts insert
-./netcdf /home/david/Documents/digital-twins/AMP/Electricity_BME.csv unix_ts createts insert
-./netcdf /home/david/Documents/digital-twins/AMP/Electricity_CDE.csv unix_ts createts insert
-./netcdf /home/david/Documents/digital-twins/AMP/Electricity_CWE.csv unix_ts createts insert
-./netcdf /home/david/Documents/digital-twins/AMP/Electricity_DNE.csv unix_ts createts insert
-./netcdf /home/david/Documents/digital-twins/AMP/Electricity_DWE.csv unix_ts createts insert
-./netcdf /home/david/Documents/digital-twins/AMP/Electricity_EBE.csv unix_ts createts insert
-./netcdf /home/david/Documents/digital-twins/AMP/Electricity_EQE.csv unix_ts createts insert
-./netcdf /home/david/Documents/digital-twins/AMP/Electricity_FGE.csv unix_ts createts insert
-./netcdf /home/david/Documents/digital-twins/AMP/Electricity_FRE.csv unix_ts createts insert
-./netcdf /home/david/Documents/digital-twins/AMP/Electricity_GRE.csv unix_ts createts insert
-./netcdf /home/david/Documents/digital-twins/AMP/Electricity_HPE.csv unix_ts createts insert
-./netcdf /home/david/Documents/digital-twins/AMP/Electricity_HTE.csv unix_ts createts insert
-./netcdf /home/david/Documents/digital-twins/AMP/Electricity_OFE.csv unix_ts createts insert
-./netcdf /home/david/Documents/digital-twins/AMP/Electricity_OUE.csv unix_ts createts insert
-./netcdf /home/david/Documents/digital-twins/AMP/Electricity_RSE.csv unix_ts createts insert
-./netcdf /home/david/Documents/digital-twins/AMP/Electricity_TVE.csv unix_ts createts insert
-./netcdf /home/david/Documents/digital-twins/AMP/Electricity_UTE.csv unix_ts createts insert
-./netcdf /home/david/Documents/digital-twins/AMP/Electricity_WHE.csv unix_ts createts insert
-./netcdf /home/david/Documents/digital-twins/AMP/Electricity_WOE.csv unix_ts createts insert
-./netcdf /home/david/Documents/digital-twins/AMP/Electricity_I.csv UNIX_TS createts insert
-./netcdf /home/david/Documents/digital-twins/AMP/Electricity_P.csv UNIX_TS createts insert
-./netcdf /home/david/Documents/digital-twins/AMP/Electricity_Q.csv UNIX_TS createts insert
-./netcdf /home/david/Documents/digital-twins/AMP/Electricity_S.csv UNIX_TS createts insert
-./netcdf /home/david/Documents/digital-twins/AMP/NaturalGas_FRG.csv unix_ts create insert
-./netcdf /home/david/Documents/digital-twins/AMP/NaturalGas_WHG.csv unix_ts create insert
-./netcdf /home/david/Documents/digital-twins/AMP/Water_WHW.csv unix_ts create insert
-./netcdf /home/david/Documents/digital-twins/AMP/Water_HTW.csv unix_ts create insert
-./netcdf /home/david/Documents/digital-twins/AMP/Water_DWW.csv unix_ts create insert
-
-echo Done!
-./netcdf /home/david/Documents/digital-twins/opsd.timeseries/opsd_time_series_15min.csv createdb utc_timestamp createts insert
-./netcdf /home/david/Documents/digital-twins/opsd.timeseries/opsd_time_series_30min.csv utc_timestamp createts insert
-#./netcdf /home/david/Documents/digital-twins/opsd.timeseries/opsd_time_series_60min.csv utc_timestamp createts insert
-echo 'opsd_time_series_60min.csv file has not been loaded!'
-
+# The ingest jobs are listed in create.yaml. netcdf batch creates each database once, runs 4 files at a time,
+# continues past failures and prints a table of outcomes; the output of each file is in batch_logs/<dataset>.log.
+# Add dryrun=true to print the plan.
+./netcdf batch create.yaml "$@"
@@ -0,0 +1,418 @@
+package main // datapackage.go reads Frictionless Data Packages (datapackage.json, https://specs.frictionlessdata.io/data-package/) that describe CSV data files.
+// The package of a data file is datapackage=<path> or the datapackage.json in its folder (next to the archive of a member); its resource is the one whose
+// path or name is the data file. The Table Schema of the resource supplies the type, unit and description of each column, the time column (primaryKey)
+// and the tokens of missing values (missingValues, or the missingValue of OPSD packages), which are inserted as nulls.
+// The schema may be inline, a file next to datapackage.json, or the name of an OPSD "schemas" entry ("schema": "60min").
+// With an xsv summary file, the package fills in its units and description columns; the types stay those xsv found in the data.
+// Without one, the summary rows are built from the package and the database is database=root.x.y or the package's iotdb-groupname.
+// This replaces the hand-edited units column of the OPSD summaries and the opsd_timeseries() tags of attributeTags.go.
+// Conversely, export writes a datapackage.json next to its CSV or Parquet files, with a Table Schema per device built from the ATTRIBUTES and TAGS of its
+// time series, so the files can be validated and loaded with Frictionless tools (frictionless validate datapackage.json).
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const dataPackageName = "datapackage.json"
+
+var dataPackageParameters = map[string]string{"datapackage": "", "database": ""}
+
+// Frictionless field types as the xsv stats types of summary files (rowsXsdMap).
+var frictionlessXsvTypes = map[string]string{
+	"string": "Unicode", "number": "Float", "integer": "Longint", "boolean": "boolean",
+	"datetime": "dateTime", "date": "dateTime", "time": "dateTime", "year": "Integer", "yearmonth": "Unicode", "duration": "Unicode",
+	"object": "Unicode", "array": "Unicode", "geopoint": "Unicode", "geojson": "Unicode", "any": "Unicode",
+}
+
+// One string or a list of strings, as in "primaryKey": "utc_timestamp" and "path": ["a.csv", "b.csv"].
+type jsonStrings []string
+
+func (values *jsonStrings) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err == nil {
+		*values = jsonStrings{value}
+		return nil
+	}
+	var list []string
+	err := json.Unmarshal(data, &list)
+	*values = list
+	return err
+}
+
+type TableField struct {
+	Name              string `json:"name"`
+	Title             string `json:"title,omitempty"`
+	Description       string `json:"description,omitempty"`
+	Type              string `json:"type,omitempty"`
+	Format            string `json:"format,omitempty"`
+	Unit              string `json:"unit,omitempty"` // OPSD
+	OpsdContentfilter bool   `json:"opsd-contentfilter,omitempty"`
+}
+
+type TableSchema struct {
+	Fields        []TableField `json:"fields"`
+	PrimaryKey    jsonStrings  `json:"primaryKey,omitempty"`
+	MissingValues []string     `json:"missingValues,omitempty"`
+	MissingValue  string       `json:"missingValue,omitempty"` // OPSD
+}
+
+type CsvDialect struct {
+	CsvddfVersion  float64 `json:"csvddfVersion,omitempty"`
+	Delimiter      string  `json:"delimiter,omitempty"`
+	LineTerminator string  `json:"lineTerminator,omitempty"`
+	Header         bool    `json:"header"`
+}
+
+type DataResource struct {
+	Name      string          `json:"name,omitempty"`
+	Path      jsonStrings     `json:"path"`
+	Title     string          `json:"title,omitempty"`
+	Mediatype string          `json:"mediatype,omitempty"`
+	Format    string          `json:"format,omitempty"`
+	Encoding  string          `json:"encoding,omitempty"`
+	Schema    json.RawMessage `json:"schema,omitempty"` // a Table Schema, its path or an OPSD schema name
+	Dialect   *CsvDialect     `json:"dialect,omitempty"`
+	Bytes     int64           `json:"bytes,omitempty"`
+}
+
+type PackageSource struct {
+	Title  string `json:"title,omitempty"`
+	Name   string `json:"name,omitempty"` // OPSD
+	Path   string `json:"path,omitempty"`
+	Web    string `json:"web,omitempty"` // OPSD
+	Source string `json:"source,omitempty"`
+}
+
+type PackageLicense struct {
+	Name    string `json:"name,omitempty"`
+	ID      string `json:"id,omitempty"` // OPSD
+	Version string `json:"version,omitempty"`
+	Path    string `json:"path,omitempty"`
+	URL     string `json:"url,omitempty"` // OPSD
+	Title   string `json:"title,omitempty"`
+}
+
+type PackageContributor struct {
+	Title string `json:"title,omitempty"`
+	Name  string `json:"name,omitempty"` // OPSD
+	Email string `json:"email,omitempty"`
+	Path  string `json:"path,omitempty"`
+	Web   string `json:"web,omitempty"` // OPSD
+	Role  string `json:"role,omitempty"`
+}
+
+// DataPackage is a datapackage.json, with the fields of the OPSD packages.
+type DataPackage struct {
+	Profile           string                 `json:"profile,omitempty"`
+	Name              string                 `json:"name"`
+	Title             string                 `json:"title,omitempty"`
+	Description       string                 `json:"description,omitempty"`
+	LongDescription   string                 `json:"long_description,omitempty"`
+	Documentation     string                 `json:"documentation,omitempty"`
+	Version           string                 `json:"version,omitempty"`
+	LastChanges       string                 `json:"last_changes,omitempty"`
+	Created           string                 `json:"created,omitempty"`
+	Keywords          []string               `json:"keywords,omitempty"`
+	Contributors      []PackageContributor   `json:"contributors,omitempty"`
+	Sources           []PackageSource        `json:"sources,omitempty"`
+	Licenses          []PackageLicense       `json:"licenses,omitempty"`
+	External          bool                   `json:"external,omitempty"`
+	GeographicalScope string                 `json:"geographical-scope,omitempty"`
+	IotdbGroupname    string                 `json:"iotdb-groupname,omitempty"` // the IoTDB database of the resources
+	Resources         []DataResource         `json:"resources"`
+	Schemas           map[string]TableSchema `json:"schemas,omitempty"` // OPSD: schemas shared by name
+	FilePath          string                 `json:"-"`
+}
+
+func ReadDataPackage(filePath string) (DataPackage, error) {
+	var pkg DataPackage
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return pkg, err
+	}
+	if err = json.Unmarshal(data, &pkg); err != nil {
+		return pkg, errors.New(filePath + ": " + err.Error())
+	}
+	pkg.FilePath = filePath
+	return pkg, nil
+}
+
+// The data package given by datapackage= or found next to the data file; false when there is none.
+func FindDataPackage(dataFilePath string, programArgs []string) (DataPackage, bool, error) {
+	packagePath := getProgramParameters(programArgs, dataPackageParameters)["datapackage"]
+	if len(packagePath) == 0 {
+		packagePath = filepath.Join(sourceFolder(dataFilePath), dataPackageName)
+		if _, err := os.Stat(packagePath); err != nil {
+			return DataPackage{}, false, nil
+		}
+	}
+	pkg, err := ReadDataPackage(packagePath)
+	return pkg, err == nil, err
+}
+
+// The resource of the data file: a path with the same file name (ignoring compression) or the same name.
+func (pkg DataPackage) Resource(dataFilePath string) (DataResource, bool) {
+	fileName := filepath.Base(sourceName(dataFilePath))
+	for _, resource := range pkg.Resources {
+		for _, resourcePath := range resource.Path {
+			if path.Base(sourceName(resourcePath)) == fileName {
+				return resource, true
+			}
+		}
+	}
+	for _, resource := range pkg.Resources {
+		if len(resource.Name) > 0 && resource.Name == sourceBase(dataFilePath) {
+			return resource, true
+		}
+	}
+	return DataResource{}, false
+}
+
+// The Table Schema of a resource: inline, by OPSD schema name, or in a file next to datapackage.json.
+func (pkg DataPackage) TableSchema(resource DataResource) (TableSchema, error) {
+	var schema TableSchema
+	if len(resource.Schema) == 0 {
+		return schema, errors.New("resource " + resource.Name + " has no schema")
+	}
+	var reference string
+	if err := json.Unmarshal(resource.Schema, &reference); err != nil {
+		err = json.Unmarshal(resource.Schema, &schema)
+		return schema, err
+	}
+	if schema, ok := pkg.Schemas[reference]; ok {
+		return schema, nil
+	}
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(pkg.FilePath), filepath.FromSlash(reference)))
+	if err != nil {
+		return schema, errors.New("schema " + reference + ": " + err.Error())
+	}
+	err = json.Unmarshal(data, &schema)
+	return schema, err
+}
+
+// Tokens read as null; the Table Schema default is the empty string.
+func (schema TableSchema) Missing() []string {
+	if len(schema.MissingValues) > 0 {
+		return schema.MissingValues
+	}
+	return []string{schema.MissingValue}
+}
+
+func (schema TableSchema) Field(name string) (TableField, bool) {
+	for _, field := range schema.Fields {
+		if field.Name == name {
+			return field, true
+		}
+	}
+	return TableField{}, false
+}
+
+// Summary rows of the schema in the layout of an xsv summary file; its last row names the database.
+func (schema TableSchema) Summary(database string) [][]string {
+	summary := [][]string{{"field", "type", "sum", "min", "max", unitsName, "description"}}
+	for _, field := range schema.Fields {
+		xsvType, ok := frictionlessXsvTypes[strings.ToLower(field.Type)]
+		if !ok {
+			xsvType = "Unicode"
+		}
+		summary = append(summary, []string{field.Name, xsvType, "", "", "", field.Unit, field.Description})
+	}
+	return append(summary, []string{endOfFields, database, "", "", "", "", ""})
+}
+
+// Fill in the units and description columns of an xsv summary from the schema, adding the columns when they are missing.
+func (schema TableSchema) Annotate(summary [][]string) [][]string {
+	columns := make(map[string]int, 0)
+	for ndx, name := range summary[0] {
+		columns[strings.ToLower(name)] = ndx
+	}
+	for _, name := range []string{unitsName, "description"} {
+		if _, ok := columns[name]; !ok {
+			columns[name] = len(summary[0])
+			for r := range summary {
+				summary[r] = append(summary[r], "")
+			}
+			summary[0][columns[name]] = name
+		}
+	}
+	for _, row := range summary[1:] {
+		if field, ok := schema.Field(row[0]); ok && columns[unitsName] < len(row) {
+			if len(field.Unit) > 0 {
+				row[columns[unitsName]] = field.Unit
+			}
+			if len(field.Description) > 0 {
+				row[columns["description"]] = field.Description
+			}
+		}
+	}
+	return summary
+}
+
+// The Table Schema of the data file, or false when no data package describes it.
+func dataFileSchema(dataFilePath string, programArgs []string) (DataPackage, TableSchema, bool, error) {
+	pkg, found, err := FindDataPackage(dataFilePath, programArgs)
+	if !found {
+		return pkg, TableSchema{}, false, err
+	}
+	resource, ok := pkg.Resource(dataFilePath)
+	if !ok {
+		return pkg, TableSchema{}, false, nil
+	}
+	schema, err := pkg.TableSchema(resource)
+	return pkg, schema, err == nil, err
+}
+
+// Summary rows and missing value tokens of a data file: its xsv summary file, annotated by a data package that describes the file, or the package alone.
+func ReadDataFileSummary(dataFilePath string, programArgs []string) ([][]string, []string, error) {
+	summary, summaryErr := readCsvRecords(GetSummaryFilename(dataFilePath), false)
+	pkg, schema, described, err := dataFileSchema(dataFilePath, programArgs)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !described {
+		if summaryErr != nil && isToniotFile(dataFilePath) {
+			summary, summaryErr = toniotSummary(dataFilePath, programArgs) // raw ToN_IoT files have no summary file
+		}
+		return summary, nil, summaryErr
+	}
+	if summaryErr == nil {
+		return schema.Annotate(summary), schema.Missing(), nil
+	}
+	database := getProgramParameters(programArgs, dataPackageParameters)["database"]
+	if len(database) == 0 {
+		database = pkg.IotdbGroupname
+	}
+	if len(database) == 0 {
+		return nil, nil, errors.New(dataFilePath + ": no summary file, and " + pkg.FilePath + " has no iotdb-groupname; give database=root.<group>.<name>")
+	}
+	return schema.Summary(database), schema.Missing(), nil
+}
+
+// ATTRIBUTES entry of the description of a measurement; "" without one.
+func descriptionAttribute(item *MeasurementItem) string {
+	if len(item.MeasurementDescription) == 0 {
+		return ""
+	}
+	return ", 'description'='" + strings.ReplaceAll(item.MeasurementDescription, "'", "''") + "'"
+}
+
+// The primaryKey of the data file's Table Schema, the default time column; "" without one.
+func dataPackageTimeColumn(dataFilePath string, programArgs []string) string {
+	_, schema, described, _ := dataFileSchema(dataFilePath, programArgs)
+	if !described || len(schema.PrimaryKey) == 0 {
+		return ""
+	}
+	return schema.PrimaryKey[0]
+}
+
+// Table Schema types of the XSD types of MeasurementItem.
+var xsdFrictionlessTypes = map[string]string{
+	"float": "number", "double": "number", "decimal": "number",
+	"integer": "integer", "int": "integer", "int32": "integer", "int64": "integer", "longint": "integer",
+	"boolean": "boolean", "datetime": "datetime", "string": "string", "unicode": "string",
+}
+
+// Media types of the export formats.
+var exportMediatypes = map[string]string{"csv": "text/csv", "parquet": "application/vnd.apache.parquet"}
+
+// Frictionless names are lower case letters, digits, '-', '_' and '.'.
+func dataPackageIdentifier(name string) string {
+	name = strings.ToLower(name)
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' || r == '_' || r == '.' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// The Table Schema of the files of a device export: the time column, then one field per column with the type, units and description of its time series.
+func (de *DeviceExport) TableSchema() TableSchema {
+	timeField := TableField{Name: exportTimeColumn, Type: "datetime", Description: "time of the row in UTC"}
+	if de.Format == "parquet" && iotdbPrecision == precisionNanoseconds {
+		timeField = TableField{Name: exportTimeColumn, Type: "integer", Description: "nanoseconds since 1970-01-01T00:00:00Z"}
+	}
+	schema := TableSchema{Fields: []TableField{timeField}, PrimaryKey: jsonStrings{exportTimeColumn}, MissingValues: []string{""}}
+	for _, column := range de.Columns {
+		fieldType, ok := xsdFrictionlessTypes[strings.ToLower(column.MeasurementType)]
+		if !ok {
+			fieldType = xsdFrictionlessTypes[iotdbXsdMap[column.IotdbType]]
+		}
+		field := TableField{Name: column.Header, Type: fieldType, Unit: column.MeasurementUnits, Description: column.MeasurementDescription}
+		if fieldType == "datetime" {
+			field.Format = "any" // as the data file had it
+		}
+		if column.Header != column.MeasurementName {
+			field.Title = column.MeasurementName
+		}
+		schema.Fields = append(schema.Fields, field)
+	}
+	return schema
+}
+
+// One resource per exported file, with paths relative to the output folder.
+func (de *DeviceExport) Resources() []DataResource {
+	schema, _ := json.Marshal(de.TableSchema())
+	resources := make([]DataResource, 0, len(de.Files))
+	for _, fileName := range de.Files {
+		resource := DataResource{
+			Name:      dataPackageIdentifier(strings.TrimSuffix(filepath.Base(fileName), filepath.Ext(fileName))),
+			Path:      jsonStrings{filepath.ToSlash(filepath.Base(fileName))},
+			Title:     de.Device,
+			Format:    de.Format,
+			Mediatype: exportMediatypes[de.Format],
+			Schema:    schema,
+		}
+		if de.Format == "csv" {
+			resource.Encoding = "utf-8"
+			resource.Dialect = &CsvDialect{CsvddfVersion: 1.2, Delimiter: ",", LineTerminator: "\n", Header: true}
+		}
+		if info, err := os.Stat(fileName); err == nil {
+			resource.Bytes = info.Size()
+		}
+		resources = append(resources, resource)
+	}
+	return resources
+}
+
+// The data package of an export. The description, keywords, contributors, licenses and sources of the original package (datapackage=) are kept;
+// license= names a license instead, and each device is a source.
+func ExportDataPackage(pattern string, exports []*DeviceExport, parameters map[string]string) (DataPackage, error) {
+	pkg := DataPackage{Profile: "tabular-data-package"}
+	if len(parameters["datapackage"]) > 0 {
+		original, err := ReadDataPackage(parameters["datapackage"])
+		if err != nil {
+			return pkg, err
+		}
+		pkg.Title, pkg.Description, pkg.Keywords, pkg.Contributors, pkg.Licenses, pkg.Sources = original.Title, original.Description, original.Keywords, original.Contributors, original.Licenses, original.Sources
+	}
+	pkg.Name = dataPackageIdentifier(strings.TrimPrefix(strings.NewReplacer(anyOneNode, "all", iotdbPathSep, "-").Replace(pattern), "root-"))
+	if len(pkg.Title) == 0 {
+		pkg.Title = "IoTDB export of " + pattern
+	}
+	pkg.Created = time.Now().UTC().Format(time.RFC3339)
+	if len(parameters["license"]) > 0 {
+		pkg.Licenses = []PackageLicense{{Name: parameters["license"]}}
+	}
+	for _, de := range exports {
+		pkg.Sources = append(pkg.Sources, PackageSource{Title: de.Device, Path: "iotdb://" + clientConfig.Host + ":" + clientConfig.Port + "/" + de.Device})
+		pkg.Resources = append(pkg.Resources, de.Resources()...)
+	}
+	return pkg, nil
+}
+
+// Write pkg to <folder>/datapackage.json.
+func (pkg DataPackage) Write(folder string) (string, error) {
+	data, err := json.MarshalIndent(pkg, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	filePath := filepath.Join(folder, dataPackageName)
+	return filePath, os.WriteFile(filePath, data, 0644)
+}
@@ -0,0 +1,425 @@
+package main // export.go copies a time range of IoTDB devices into CSV or Apache Parquet files for ML work.
+// netcdf export root.ecobee.household.<id> [start=time] [end=time] [measurements=a,b] [format=csv|parquet] [output=dir] [chunkmb=256] [names=original|alias]
+// The device may be a pattern (root.ecobee.household.*); each device is written to its own files, split every chunkmb megabytes:
+//   <output>/ecobee_household_<id>_0001.csv
+// CSV headers use the original column names (MeasurementName, stored in ATTRIBUTES('name') by createts) rather than the IoTDB aliases.
+// Series created before createts recorded 'name' get theirs from source=<the ingested data file> (its summary or datapackage.json, as
+// createts reads them) or from the fields of datapackage=<original datapackage.json>; without either their headers are the aliases.
+// Parquet columns are typed from the IoTDB data types and keep the alias when the original name is not an identifier; Time is TIMESTAMP_MILLIS
+// (TIMESTAMP_MICROS or INT64 nanoseconds at other IoTDB timestamp precisions, see precision.go).
+// <output>/datapackage.json describes the files as a Frictionless Data Package (datapackage.go); datapackage=<original datapackage.json> carries over
+// its description, licenses and sources, and license=<name> sets the license.
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apache/iotdb-client-go/client"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+const (
+	parquetExtension   = ".parquet"
+	exportTimeColumn   = "Time"
+	parquetParallelism = 4
+	bytesPerMegabyte   = 1024 * 1024
+)
+
+var exportParameters = map[string]string{"start": "", "end": "", "measurements": "", "format": "csv", "output": ".", "chunkmb": "256", "names": "original", "source": "", "datapackage": "", "license": ""}
+var exportFormats = []string{"csv", "parquet"}
+
+// IoTDB timestamp precision => parquet type of the time column; this parquet-go has no nanosecond timestamp, so ns stays a plain INT64.
+var parquetTimeTypes = map[string]string{
+	precisionMilliseconds: "type=TIMESTAMP_MILLIS",
+	precisionMicroseconds: "type=TIMESTAMP_MICROS",
+	precisionNanoseconds:  "type=INT64",
+}
+
+// IotdbType => parquet-go schema metadata.
+var parquetTypeMap = map[string]string{
+	"DOUBLE":  "type=DOUBLE",
+	"FLOAT":   "type=FLOAT",
+	"INT32":   "type=INT32",
+	"INT64":   "type=INT64",
+	"BOOLEAN": "type=BOOLEAN",
+	"TEXT":    "type=UTF8",
+}
+
+// ExportColumn is one exported time series.
+type ExportColumn struct {
+	MeasurementItem
+	IotdbType string `json:"iotdbtype"`
+	Header    string `json:"header"` // column name in the export file
+}
+
+// exportWriter writes rows of one file; values are nil for nulls.
+type exportWriter interface {
+	WriteRow(timestamp int64, values []*string) error
+	Size() int64 // bytes written so far; an uncompressed estimate for Parquet.
+	Close() error
+}
+
+// countingWriter tracks the size of a CSV file.
+type countingWriter struct {
+	w     io.Writer
+	count int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.count += int64(n)
+	return n, err
+}
+
+type csvExportWriter struct {
+	file    *os.File
+	counter *countingWriter
+	csv     *csv.Writer
+}
+
+func newCsvExportWriter(fileName string, columns []ExportColumn) (exportWriter, error) {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return nil, err
+	}
+	counter := &countingWriter{w: file}
+	cw := &csvExportWriter{file: file, counter: counter, csv: csv.NewWriter(counter)}
+	header := []string{exportTimeColumn}
+	for _, column := range columns {
+		header = append(header, column.Header)
+	}
+	return cw, cw.csv.Write(header)
+}
+
+func (cw *csvExportWriter) WriteRow(timestamp int64, values []*string) error {
+	record := make([]string, 0, len(values)+1)
+	record = append(record, formatIotdbTimestamp(timestamp))
+	for _, value := range values {
+		if value == nil {
+			record = append(record, "")
+		} else {
+			record = append(record, *value)
+		}
+	}
+	return cw.csv.Write(record)
+}
+
+func (cw *csvExportWriter) Size() int64 {
+	cw.csv.Flush()
+	return cw.counter.count
+}
+
+func (cw *csvExportWriter) Close() error {
+	cw.csv.Flush()
+	if err := cw.csv.Error(); err != nil {
+		cw.file.Close()
+		return err
+	}
+	return cw.file.Close()
+}
+
+type parquetExportWriter struct {
+	file   *os.File
+	writer *writer.CSVWriter
+	size   int64
+}
+
+func newParquetExportWriter(fileName string, columns []ExportColumn, chunkBytes int64) (exportWriter, error) {
+	metadata := []string{"name=" + exportTimeColumn + ", " + parquetTimeTypes[iotdbPrecision] + ", repetitiontype=REQUIRED"}
+	for _, column := range columns {
+		parquetType, ok := parquetTypeMap[column.IotdbType]
+		if !ok {
+			return nil, errors.New("no parquet type for " + column.IotdbType + " column " + column.Header)
+		}
+		name := column.Header
+		if !isIdentifier(name) { // parquet-go mangles other characters, so fall back to the IoTDB alias.
+			name = column.MeasurementAlias
+		}
+		metadata = append(metadata, "name="+name+", "+parquetType+", repetitiontype=OPTIONAL")
+	}
+	file, err := os.Create(fileName)
+	if err != nil {
+		return nil, err
+	}
+	pw, err := writer.NewCSVWriterFromWriter(metadata, file, parquetParallelism)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+	if chunkBytes > 0 && chunkBytes < pw.RowGroupSize {
+		pw.RowGroupSize = chunkBytes
+	}
+	return &parquetExportWriter{file: file, writer: pw}, nil
+}
+
+// [A-Za-z_][A-Za-z0-9_]*
+func isIdentifier(name string) bool {
+	for ndx, r := range name {
+		if !(r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (ndx > 0 && r >= '0' && r <= '9')) {
+			return false
+		}
+	}
+	return len(name) > 0
+}
+
+func (pw *parquetExportWriter) WriteRow(timestamp int64, values []*string) error {
+	timeText := strconv.FormatInt(timestamp, 10)
+	row := make([]*string, 0, len(values)+1)
+	row = append(row, &timeText)
+	pw.size += 8
+	for _, value := range values {
+		row = append(row, value)
+		if value != nil {
+			pw.size += int64(len(*value))
+		}
+	}
+	return pw.writer.WriteString(row)
+}
+
+func (pw *parquetExportWriter) Size() int64 {
+	return pw.size
+}
+
+func (pw *parquetExportWriter) Close() error {
+	if err := pw.writer.WriteStop(); err != nil {
+		pw.file.Close()
+		return err
+	}
+	return pw.file.Close()
+}
+
+// DeviceExport writes the rows of one device to numbered chunk files.
+type DeviceExport struct {
+	Device     string         `json:"device"`
+	Columns    []ExportColumn `json:"columns"`
+	Format     string         `json:"format"`
+	OutputPath string         `json:"outputpath"`
+	ChunkBytes int64          `json:"chunkbytes"` // 0 writes a single file
+	Files      []string       `json:"files"`
+	Rows       int            `json:"rows"`
+	current    exportWriter
+}
+
+// root.ecobee.household.<id> + 1 => <output>/ecobee_household_<id>_0001.csv
+func (de *DeviceExport) chunkFileName(chunk int) string {
+	extension := csvExtension
+	if de.Format == "parquet" {
+		extension = parquetExtension
+	}
+	baseName := strings.ReplaceAll(strings.TrimPrefix(de.Device, "root."), iotdbPathSep, "_")
+	return filepath.Join(de.OutputPath, fmt.Sprintf("%s_%04d%s", baseName, chunk, extension))
+}
+
+func (de *DeviceExport) nextChunk() error {
+	if de.current != nil {
+		if err := de.current.Close(); err != nil {
+			return err
+		}
+	}
+	fileName := de.chunkFileName(len(de.Files) + 1)
+	var err error
+	if de.Format == "parquet" {
+		de.current, err = newParquetExportWriter(fileName, de.Columns, de.ChunkBytes)
+	} else {
+		de.current, err = newCsvExportWriter(fileName, de.Columns)
+	}
+	if err != nil {
+		de.current = nil
+		return err
+	}
+	de.Files = append(de.Files, fileName)
+	return nil
+}
+
+// timestamp is an IoTDB timestamp (precision.go).
+func (de *DeviceExport) writeRow(timestamp int64, values []*string) error {
+	if de.current == nil || (de.ChunkBytes > 0 && de.current.Size() >= de.ChunkBytes) {
+		if err := de.nextChunk(); err != nil {
+			return err
+		}
+	}
+	de.Rows++
+	return de.current.WriteRow(timestamp, values)
+}
+
+// Copy the rows of [startTime, endTime) unix milliseconds; SessionDataSet pages are fetched as the files are written.
+func (de *DeviceExport) Run(session *client.Session, startTime, endTime int64) error {
+	aliases := make([]string, 0, len(de.Columns))
+	columnIndex := make(map[string]int, len(de.Columns))
+	for ndx, column := range de.Columns {
+		aliases = append(aliases, column.MeasurementAlias)
+		columnIndex[column.MeasurementAlias] = ndx
+	}
+	sql := "SELECT " + strings.Join(aliases, ",") + " FROM " + de.Device + iotdbTimeRange(startTime, endTime)
+	timeout := tailQueryTimeout
+	sds, err := session.ExecuteQueryStatement(sql, &timeout)
+	if err != nil {
+		return errors.New(sql + ": " + err.Error())
+	}
+	defer sds.Close()
+	for next, err := sds.Next(); next || err != nil; next, err = sds.Next() {
+		if err != nil {
+			return err
+		}
+		values := make([]*string, len(de.Columns))
+		for ndx := 0; ndx < sds.GetColumnCount(); ndx++ {
+			columnName := sds.GetColumnName(ndx)
+			_, alias := splitIotdbPath(columnName)
+			position, ok := columnIndex[alias]
+			if !ok || sds.GetValue(columnName) == nil {
+				continue
+			}
+			text := sds.GetText(columnName)
+			values[position] = &text
+		}
+		if err := de.writeRow(sds.GetTimestamp(), values); err != nil {
+			return err
+		}
+	}
+	if de.current == nil { // no rows: still write the header.
+		if err := de.nextChunk(); err != nil {
+			return err
+		}
+	}
+	return de.current.Close()
+}
+
+// The original column name by alias for the series without ATTRIBUTES('name'): the fields of the summary of source=<data file>, aliased
+// as createts aliases them, or the fields of datapackage=<original datapackage.json>. Empty without either.
+func originalNames(programArgs []string, parameters map[string]string) (map[string]string, error) {
+	names := make(map[string]string, 0)
+	if len(parameters["source"]) > 0 {
+		summary, _, err := ReadDataFileSummary(parameters["source"], programArgs)
+		if err != nil {
+			return nil, err
+		}
+		_, items := summaryMeasurements(summary)
+		for _, item := range items {
+			if item.MeasurementAlias != LastColumnName {
+				names[item.MeasurementAlias] = item.MeasurementName // the field as it is in the data file, as createts records it
+			}
+		}
+		return names, nil
+	}
+	if len(parameters["datapackage"]) == 0 {
+		return names, nil
+	}
+	pkg, err := ReadDataPackage(parameters["datapackage"])
+	if err != nil {
+		return nil, err
+	}
+	for _, resource := range pkg.Resources {
+		schema, err := pkg.TableSchema(resource)
+		if err != nil {
+			return nil, err
+		}
+		for _, field := range schema.Fields {
+			if alias, _ := StandardName(field.Name); len(names[alias]) == 0 {
+				names[alias] = strings.TrimSpace(field.Name)
+			}
+		}
+	}
+	return names, nil
+}
+
+// Choose the exported columns of a device in time series order. measurements may name aliases or original names.
+// names (originalNames, or nil) gives the MeasurementName of series created without ATTRIBUTES('name').
+func exportColumns(profiles []IotdbTimeseriesProfile, measurements []string, useOriginalNames bool, names map[string]string) ([]ExportColumn, error) {
+	columns := make([]ExportColumn, 0, len(profiles))
+	found := make(map[string]bool, 0)
+	for ndx, profile := range profiles {
+		mi := profile.ToMeasurementItem(ndx)
+		if name, ok := names[mi.MeasurementAlias]; ok && len(parseKeyValuePairs(profile.Attributes)["name"]) == 0 {
+			mi.MeasurementName = name
+		}
+		if mi.MeasurementAlias == LastColumnName && len(measurements) == 0 {
+			continue // the dataset name is the same in every row.
+		}
+		if len(measurements) > 0 {
+			if !contains(measurements, mi.MeasurementAlias) && !contains(measurements, mi.MeasurementName) {
+				continue
+			}
+			found[mi.MeasurementAlias] = true
+			found[mi.MeasurementName] = true
+		}
+		column := ExportColumn{MeasurementItem: mi, IotdbType: profile.DataType, Header: mi.MeasurementAlias}
+		if useOriginalNames {
+			column.Header = mi.MeasurementName
+		}
+		columns = append(columns, column)
+	}
+	for _, measurement := range measurements {
+		if !found[measurement] {
+			return nil, errors.New("measurement not found: " + measurement)
+		}
+	}
+	if len(columns) == 0 {
+		return nil, errors.New("no time series to export")
+	}
+	return columns, nil
+}
+
+// programArgs: export <root.device> [start=] [end=] [measurements=a,b] [format=csv|parquet] [output=dir] [chunkmb=256] [names=original|alias] [source=] [datapackage=] [license=]
+func ProcessExport(programArgs []string) {
+	if len(programArgs) < 3 || !strings.HasPrefix(programArgs[2], "root.") {
+		checkErr("export", errors.New("expected: export <root.device> [start=time] [end=time] [measurements=a,b] [format=csv|parquet] [output=dir] [chunkmb=256]"))
+	}
+	parameters := getProgramParameters(programArgs, exportParameters)
+	format := strings.ToLower(parameters["format"])
+	if !contains(exportFormats, format) {
+		checkErr("export", errors.New("format must be one of "+strings.Join(exportFormats, ", ")))
+	}
+	startTime, err := parseReplayTime(parameters["start"], 0)
+	checkErr("export(start)", err)
+	endTime, err := parseReplayTime(parameters["end"], time.Now().UTC().UnixMilli()+1)
+	checkErr("export(end)", err)
+	chunkMb, err := strconv.ParseInt(parameters["chunkmb"], 10, 64)
+	if err != nil || chunkMb < 0 {
+		checkErr("export", errors.New("chunkmb must be a number of megabytes; 0 does not split files: "+parameters["chunkmb"]))
+	}
+	err = os.MkdirAll(parameters["output"], 0755)
+	checkErr("export(output)", err)
+
+	iotdbConnection, ok := Init_IoTDB(true)
+	if !ok {
+		checkErr("Init_IoTDB: ", errors.New(iotdbConnection))
+	}
+	session := client.NewSession(clientConfig)
+	err = session.Open(false, 0)
+	checkErr("Open", err)
+	defer session.Close()
+
+	devices := []IotdbDevice{{Device: programArgs[2]}}
+	if strings.Contains(programArgs[2], anyOneNode) {
+		devices, err = ShowDevices(&session, programArgs[2])
+		checkErr("ShowDevices", err)
+	}
+	names, err := originalNames(programArgs, parameters)
+	checkErr("export(source)", err)
+	exports := make([]*DeviceExport, 0, len(devices))
+	for _, device := range devices {
+		profiles, err := GetTimeseriesProfiles(&session, device.Device+".*")
+		checkErr("GetTimeseriesProfiles", err)
+		columns, err := exportColumns(profiles, splitList(parameters["measurements"]), !strings.EqualFold(parameters["names"], "alias"), names)
+		checkErr("export("+device.Device+")", err)
+		de := DeviceExport{Device: device.Device, Columns: columns, Format: format, OutputPath: parameters["output"], ChunkBytes: chunkMb * bytesPerMegabyte}
+		err = de.Run(&session, startTime, endTime)
+		checkErr("export("+device.Device+")", err)
+		fmt.Printf("Exported %d rows of %s to %s\n", de.Rows, de.Device, strings.Join(de.Files, ", "))
+		exports = append(exports, &de)
+	}
+	pkg, err := ExportDataPackage(programArgs[2], exports, parameters)
+	checkErr("ExportDataPackage", err)
+	packagePath, err := pkg.Write(parameters["output"])
+	checkErr("datapackage", err)
+	fmt.Println("Data package written to " + packagePath)
+}
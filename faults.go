@@ -0,0 +1,435 @@
+package main // faults.go copies a device with injected faults and ground-truth labels, to test downstream anomaly detectors.
+// netcdf inject <root.device or pattern> [faults=stuck,spike,drift,dropout,skew,duplicate,unitflip] [measurements=a,b]
+//               [rate=0.01] [length=12] [magnitude=5] [skew=auto] [seed=1] [start=time] [end=time]
+//               [suffix=_faults] [output=iotdb|csv|parquet] [dir=.] [report=faults.csv]
+// A fault episode starts at each row with probability rate and lasts length rows (spikes and duplicates last one row):
+//   stuck     the measurement repeats its first value of the episode
+//   spike     the measurement jumps by +/- magnitude standard deviations
+//   drift     the measurement ramps away from its value, reaching magnitude standard deviations at the end of the episode
+//   unitflip  a temperature is reported in the other unit (°F <=> °C, by its units tag)
+//   dropout   the rows are lost; only their labels remain
+//   skew      the timestamps run skew (default half the sampling interval) late
+//   duplicate the next row repeats the timestamp of this row; IoTDB keeps the later values, files keep both rows
+// Like the ToN_IoT datasets each row has a label (0 normal, 1 fault) and a type (normal or the fault name).
+// The same seed injects the same faults.
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apache/iotdb-client-go/client"
+)
+
+const (
+	faultStuck      = "stuck"
+	faultSpike      = "spike"
+	faultDrift      = "drift"
+	faultDropout    = "dropout"
+	faultSkew       = "skew"
+	faultDuplicate  = "duplicate"
+	faultUnitFlip   = "unitflip"
+	labelColumnName = "label"
+	typeColumnName  = "type"
+	normalType      = "normal"
+)
+
+var injectParameters = map[string]string{"faults": "stuck,spike,drift,dropout,skew,duplicate,unitflip", "measurements": "", "rate": "0.01", "length": "12", "magnitude": "5",
+	"skew": "auto", "seed": "1", "start": "", "end": "", "suffix": "_faults", "output": "iotdb", "dir": ".", "report": ""}
+var faultKinds = []string{faultStuck, faultSpike, faultDrift, faultDropout, faultSkew, faultDuplicate, faultUnitFlip}
+var valueFaults = []string{faultStuck, faultSpike, faultDrift, faultUnitFlip}
+
+// FaultSpec configures the faults injected into one device.
+type FaultSpec struct {
+	Faults    []string
+	Targets   []int // indices of the numeric columns that value faults may change
+	Rate      float64
+	Length    int
+	Magnitude float64 // in standard deviations of the measurement
+	SkewMs    int64
+}
+
+// FaultRow is one row of the faulty copy; Type is normalType unless a fault touched it.
+type FaultRow struct {
+	Timestamp int64
+	Values    []interface{}
+	Type      string
+}
+
+// FaultEpisode is the ground truth of one injected fault.
+type FaultEpisode struct {
+	Device      string `json:"device"`
+	Fault       string `json:"fault"`
+	Measurement string `json:"measurement"` // empty for dropout, skew and duplicate
+	Start       int64  `json:"start"`
+	End         int64  `json:"end"`
+	Rows        int    `json:"rows"`
+}
+
+// "C", "°C", "degC", "celsius" => "c"; "F", "°F", "degF", "fahrenheit" => "f"; otherwise "".
+func temperatureUnit(units string) string {
+	units = strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(strings.TrimSpace(units)), "°"), "deg")
+	switch {
+	case units == "c" || strings.HasPrefix(units, "celsius"):
+		return "c"
+	case units == "f" || strings.HasPrefix(units, "fahrenheit"):
+		return "f"
+	}
+	return ""
+}
+
+// Convert a temperature to the other unit of its units tag.
+func flipTemperatureUnit(value float64, units string) float64 {
+	if temperatureUnit(units) == "c" {
+		return value*9/5 + 32
+	}
+	return (value - 32) * 5 / 9
+}
+
+// Inject faults into rows (in time order). stddevs and units are per column.
+func InjectFaults(device string, rows []FaultRow, columns []ExportColumn, stddevs []float64, spec FaultSpec, random *rand.Rand) ([]FaultRow, []FaultEpisode) {
+	faulty := make([]FaultRow, 0, len(rows))
+	episodes := make([]FaultEpisode, 0)
+	for ndx := 0; ndx < len(rows); {
+		if random.Float64() >= spec.Rate {
+			faulty = append(faulty, rows[ndx])
+			ndx++
+			continue
+		}
+		fault := spec.Faults[random.Intn(len(spec.Faults))]
+		targets := spec.Targets
+		if fault == faultUnitFlip {
+			targets = make([]int, 0)
+			for _, target := range spec.Targets {
+				if len(temperatureUnit(columns[target].MeasurementUnits)) > 0 {
+					targets = append(targets, target)
+				}
+			}
+		}
+		if contains(valueFaults, fault) && len(targets) == 0 {
+			faulty = append(faulty, rows[ndx])
+			ndx++
+			continue
+		}
+		length := spec.Length
+		if fault == faultSpike || fault == faultDuplicate {
+			length = 1
+		}
+		end := min(ndx+length, len(rows))
+		if fault == faultDuplicate && ndx+1 == len(rows) {
+			faulty = append(faulty, rows[ndx])
+			ndx++
+			continue
+		}
+		episode := FaultEpisode{Device: device, Fault: fault, Start: rows[ndx].Timestamp, End: rows[end-1].Timestamp, Rows: end - ndx}
+		target := -1
+		if contains(valueFaults, fault) {
+			target = targets[random.Intn(len(targets))]
+			episode.Measurement = columns[target].MeasurementAlias
+		}
+		sign := 1.0
+		if random.Intn(2) == 0 {
+			sign = -1
+		}
+		if fault == faultDuplicate { // the next row repeats the timestamp of this row
+			faulty = append(faulty, FaultRow{Timestamp: rows[ndx].Timestamp, Values: rows[ndx].Values, Type: fault})
+			faulty = append(faulty, FaultRow{Timestamp: rows[ndx].Timestamp, Values: rows[ndx+1].Values, Type: fault})
+			episode.End = rows[ndx+1].Timestamp
+			episode.Rows = 2
+			episodes = append(episodes, episode)
+			ndx += 2
+			continue
+		}
+		held, isHeld := 0.0, false
+		for k := ndx; k < end; k++ {
+			row := FaultRow{Timestamp: rows[k].Timestamp, Values: append([]interface{}{}, rows[k].Values...), Type: fault}
+			switch fault {
+			case faultDropout:
+				row.Values = make([]interface{}, len(row.Values))
+			case faultSkew:
+				row.Timestamp += spec.SkewMs
+			default:
+				value, ok := numericValue(row.Values[target])
+				if !ok {
+					break
+				}
+				switch fault {
+				case faultStuck:
+					if !isHeld {
+						held, isHeld = value, true
+					}
+					value = held
+				case faultSpike:
+					value += sign * spec.Magnitude * stddevs[target]
+				case faultDrift:
+					value += sign * spec.Magnitude * stddevs[target] * float64(k-ndx+1) / float64(end-ndx)
+				case faultUnitFlip:
+					value = flipTemperatureUnit(value, columns[target].MeasurementUnits)
+				}
+				row.Values[target] = columnValue(value, columns[target].IotdbType)
+			}
+			faulty = append(faulty, row)
+		}
+		episodes = append(episodes, episode)
+		ndx = end
+	}
+	return faulty, episodes
+}
+
+// Integer columns keep integer values.
+func columnValue(value float64, iotdbType string) interface{} {
+	switch iotdbType {
+	case "INT32", "INT64":
+		return int64(math.Round(value))
+	}
+	return value
+}
+
+// Create the aligned series of the faulty device: the original columns plus label and type.
+func createFaultSeries(session *client.Session, device string, columns []ExportColumn) error {
+	existing, err := GetTimeseriesProfiles(session, device+".*")
+	if err != nil {
+		return err
+	}
+	created := make(map[string]bool, 0)
+	for _, profile := range existing {
+		created[profile.Timeseries] = true
+	}
+	var sb strings.Builder
+	for _, column := range columns {
+		if created[device+iotdbPathSep+column.MeasurementAlias] {
+			continue
+		}
+		_, encoding, compressor := getClientStorage(column.MeasurementType)
+		attributes := " ATTRIBUTES('datatype'='" + column.MeasurementType + "', 'name'='" + strings.ReplaceAll(column.MeasurementName, "'", "''") + "') TAGS('units'='" + column.MeasurementUnits + "')"
+		sb.WriteString(column.MeasurementAlias + " " + column.IotdbType + " encoding=" + encoding + " compressor=" + compressor + attributes + ",")
+	}
+	if sb.Len() == 0 {
+		return nil
+	}
+	sql := "CREATE ALIGNED TIMESERIES " + device + "(" + strings.TrimSuffix(sb.String(), ",") + ");"
+	if _, err := session.ExecuteNonQueryStatement(sql); err != nil {
+		return errors.New(sql + ": " + err.Error())
+	}
+	return nil
+}
+
+// The values of a row followed by its label and type.
+func labeledValues(row FaultRow) []interface{} {
+	label := int32(0)
+	if row.Type != normalType {
+		label = 1
+	}
+	return append(append([]interface{}{}, row.Values...), label, row.Type)
+}
+
+func writeFaultDevice(session *client.Session, device string, columns []ExportColumn, rows []FaultRow) error {
+	if err := createFaultSeries(session, device, columns); err != nil {
+		return err
+	}
+	blockSize := getBlockSize(len(columns))
+	statements := make([]string, 0, blockSize)
+	for _, row := range rows {
+		names := make([]string, 0, len(columns))
+		values := make([]string, 0, len(columns))
+		for ndx, value := range labeledValues(row) {
+			if value != nil {
+				names = append(names, columns[ndx].MeasurementAlias)
+				values = append(values, formatFrameValue(value))
+			}
+		}
+		statements = append(statements, alignedInsertStatement(device, row.Timestamp, names, values))
+		if len(statements) == blockSize {
+			if _, err := session.ExecuteBatchStatement(statements); err != nil {
+				return err
+			}
+			statements = statements[:0]
+		}
+	}
+	if len(statements) > 0 {
+		if _, err := session.ExecuteBatchStatement(statements); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func exportFaultDevice(device, format, outputPath string, columns []ExportColumn, rows []FaultRow) ([]string, error) {
+	de := DeviceExport{Device: device, Columns: columns, Format: format, OutputPath: outputPath}
+	for _, row := range rows {
+		values := make([]*string, len(columns))
+		for ndx, value := range labeledValues(row) {
+			if value != nil {
+				text, ok := value.(string)
+				if !ok {
+					text = formatFrameValue(value)
+				}
+				values[ndx] = &text
+			}
+		}
+		if err := de.writeRow(msToIotdb(row.Timestamp), values); err != nil {
+			return de.Files, err
+		}
+	}
+	if de.current == nil {
+		if err := de.nextChunk(); err != nil {
+			return de.Files, err
+		}
+	}
+	return de.Files, de.current.Close()
+}
+
+func writeFaultReport(fileName string, episodes []FaultEpisode) error {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	csvWriter := csv.NewWriter(file)
+	_ = csvWriter.Write([]string{"device", "fault", "measurement", "start", "end", "rows"})
+	for _, episode := range episodes {
+		_ = csvWriter.Write([]string{episode.Device, episode.Fault, episode.Measurement, formatMilliseconds(episode.Start), formatMilliseconds(episode.End), strconv.Itoa(episode.Rows)})
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// programArgs: inject <root.device or pattern> [faults=] [measurements=] [rate=0.01] [length=12] [magnitude=5] [skew=auto] [seed=1] [start=] [end=] [suffix=_faults] [output=iotdb|csv|parquet] [dir=.] [report=]
+func ProcessInject(programArgs []string) {
+	if len(programArgs) < 3 || !strings.HasPrefix(programArgs[2], "root.") {
+		checkErr("inject", errors.New("expected: inject <root.device> [faults=stuck,spike,drift,dropout,skew,duplicate,unitflip] [rate=0.01] [seed=1] [output=iotdb|csv|parquet]"))
+	}
+	parameters := getProgramParameters(programArgs, injectParameters)
+	spec := FaultSpec{Faults: splitList(strings.ToLower(parameters["faults"]))}
+	for _, fault := range spec.Faults {
+		if !contains(faultKinds, fault) {
+			checkErr("inject", errors.New("faults must be among "+strings.Join(faultKinds, ", ")+": "+fault))
+		}
+	}
+	if len(spec.Faults) == 0 {
+		checkErr("inject", errors.New("faults must name at least one of "+strings.Join(faultKinds, ", ")))
+	}
+	var err error
+	spec.Rate, err = strconv.ParseFloat(parameters["rate"], 64)
+	if err != nil || spec.Rate <= 0 || spec.Rate > 1 {
+		checkErr("inject", errors.New("rate must be a probability in (0, 1]: "+parameters["rate"]))
+	}
+	spec.Length, err = strconv.Atoi(parameters["length"])
+	if err != nil || spec.Length <= 0 {
+		checkErr("inject", errors.New("length must be a positive number of rows: "+parameters["length"]))
+	}
+	spec.Magnitude, err = strconv.ParseFloat(parameters["magnitude"], 64)
+	checkErr("inject(magnitude)", err)
+	seed, err := strconv.ParseInt(parameters["seed"], 10, 64)
+	checkErr("inject(seed)", err)
+	output := strings.ToLower(parameters["output"])
+	if !contains(resampleOutputs, output) {
+		checkErr("inject", errors.New("output must be one of "+strings.Join(resampleOutputs, ", ")))
+	}
+	measurements := splitList(parameters["measurements"])
+	startTime, err := parseReplayTime(parameters["start"], 0)
+	checkErr("inject(start)", err)
+	endTime, err := parseReplayTime(parameters["end"], time.Now().UTC().UnixMilli()+1)
+	checkErr("inject(end)", err)
+
+	iotdbConnection, ok := Init_IoTDB(true)
+	if !ok {
+		checkErr("Init_IoTDB: ", errors.New(iotdbConnection))
+	}
+	session := client.NewSession(clientConfig)
+	err = session.Open(false, 0)
+	checkErr("Open", err)
+	defer session.Close()
+
+	devices := []IotdbDevice{{Device: programArgs[2]}}
+	if strings.Contains(programArgs[2], anyOneNode) {
+		devices, err = ShowDevices(&session, programArgs[2])
+		checkErr("ShowDevices", err)
+	}
+	random := rand.New(rand.NewSource(seed))
+	episodes := make([]FaultEpisode, 0)
+	for _, device := range devices {
+		if strings.HasSuffix(device.Device, parameters["suffix"]) {
+			continue // already a faulty copy
+		}
+		profiles, err := GetTimeseriesProfiles(&session, device.Device+".*")
+		checkErr("GetTimeseriesProfiles", err)
+		exported, err := exportColumns(profiles, nil, false, nil)
+		checkErr("inject("+device.Device+")", err)
+		columns := make([]ResampleColumn, 0, len(exported))
+		for _, column := range exported {
+			if column.MeasurementAlias != labelColumnName && column.MeasurementAlias != typeColumnName {
+				columns = append(columns, ResampleColumn{ExportColumn: column})
+			}
+		}
+		if len(columns) == 0 {
+			continue
+		}
+		err = readDeviceSamples(&session, device.Device, columns, startTime, endTime)
+		checkErr("readDeviceSamples", err)
+
+		deviceSpec := spec
+		deviceSpec.Targets = make([]int, 0)
+		stddevs := make([]float64, len(columns))
+		outputColumns := make([]ExportColumn, 0, len(columns)+2)
+		for ndx, column := range columns {
+			outputColumns = append(outputColumns, column.ExportColumn)
+			values := make([]float64, 0, len(column.Samples))
+			for _, sample := range column.Samples {
+				if v, ok := numericValue(sample.Value); ok {
+					values = append(values, v)
+				}
+			}
+			if len(values) == 0 || (len(measurements) > 0 && !contains(measurements, column.MeasurementAlias) && !contains(measurements, column.MeasurementName)) {
+				continue
+			}
+			_, variance := meanVariance(values)
+			stddevs[ndx] = math.Sqrt(variance)
+			deviceSpec.Targets = append(deviceSpec.Targets, ndx)
+		}
+		outputColumns = append(outputColumns,
+			ExportColumn{MeasurementItem: MeasurementItem{MeasurementName: labelColumnName, MeasurementAlias: labelColumnName, MeasurementType: "integer", MeasurementUnits: "unitless"}, IotdbType: "INT32", Header: labelColumnName},
+			ExportColumn{MeasurementItem: MeasurementItem{MeasurementName: typeColumnName, MeasurementAlias: typeColumnName, MeasurementType: "string", MeasurementUnits: "unitless"}, IotdbType: "TEXT", Header: typeColumnName})
+
+		timestamps, values := resampledRows(columns)
+		rows := make([]FaultRow, 0, len(timestamps))
+		for _, timestamp := range timestamps {
+			rows = append(rows, FaultRow{Timestamp: timestamp, Values: values[timestamp], Type: normalType})
+		}
+		if strings.EqualFold(parameters["skew"], "auto") {
+			times := make([]Sample, 0, len(rows))
+			for _, row := range rows {
+				times = append(times, Sample{Timestamp: row.Timestamp})
+			}
+			deviceSpec.SkewMs = InferInterval(times) / 2
+		} else {
+			deviceSpec.SkewMs, err = ParseInterval(parameters["skew"])
+			checkErr("inject(skew)", err)
+		}
+		faulty, deviceEpisodes := InjectFaults(device.Device, rows, outputColumns, stddevs, deviceSpec, random)
+		episodes = append(episodes, deviceEpisodes...)
+
+		faultyDevice := device.Device + parameters["suffix"]
+		if output == "iotdb" {
+			err = writeFaultDevice(&session, faultyDevice, outputColumns, faulty)
+			checkErr("writeFaultDevice", err)
+			fmt.Printf("Injected %d faults into %s\n", len(deviceEpisodes), faultyDevice)
+			fmt.Println("IOTDB TEST QUERY: SELECT * FROM " + faultyDevice + " WHERE " + labelColumnName + " = 1 LIMIT 2;")
+		} else {
+			files, err := exportFaultDevice(faultyDevice, output, parameters["dir"], outputColumns, faulty)
+			checkErr("exportFaultDevice", err)
+			fmt.Printf("Injected %d faults into %s\n", len(deviceEpisodes), strings.Join(files, ", "))
+		}
+	}
+	if len(parameters["report"]) > 0 {
+		err = writeFaultReport(parameters["report"], episodes)
+		checkErr("writeFaultReport", err)
+		fmt.Println("Fault episodes written to " + parameters["report"])
+	}
+}
@@ -41,24 +41,9 @@ func GetCurrentDateTime(dateOnly bool) string {
 	return t.Format(DateTimeFormat)
 }
 
-// someTime can be either a long or a readable dateTime string.
+// someTime can be either a long or a readable dateTime string (ISO 8601 variants; wall-clock times are UTC). See timestamp.go.
 func GetStartTimeFromLongint(someTime string) (time.Time, error) {
-	isLong, err := strconv.ParseInt(someTime, 10, 64)
-	if err == nil {
-		return time.Unix(isLong, 0), nil  // 2016-01-03 02:42:50 +0100 CET
-	}
-
-	t1, err := time.Parse(DateTimeFormat, someTime)
-	if err == nil {
-		return t1, nil
-	} 
-
-	t2, err := time.Parse(TimeFormat1, someTime)
-	if err == nil {
-		return t2, nil
-	}
-
-	return time.Now(), err
+	return DefaultTimestampParser.Parse(someTime)
 }
 
 // Return yyyy-MM-dd
@@ -87,7 +72,7 @@ func ParseDate(dateValue string) time.Time {
 	if err == nil {
 		return d
 	} else {
-		return time.Now()
+		return time.Time{} // check IsZero(); never the current time
 	}
 }
 
@@ -0,0 +1,238 @@
+package filesystem
+
+// timestamp.go parses the time columns of sensor datasets. It never substitutes the current time for a value it cannot read.
+// Values are tried as epoch numbers, then as the dataset's own layouts, then as ISO 8601 variants (fractional seconds are always accepted).
+// The unit of an epoch integer is read from its magnitude unless the dataset declares it: seconds below 1e11, milliseconds below 1e14,
+// microseconds below 1e17, else nanoseconds (so epochs before March 1973 need an explicit unit). Epoch seconds may have a fraction.
+// Unless the unit is declared, digits that read as a compact date (20170101, or a dataset layout of digits only) are that date, not an epoch.
+// A value without an offset is wall-clock time in the parser's IANA zone (UTC by default). During a DST fall-back the same
+// wall-clock time happens twice: Ambiguous chooses the earlier (summer time) or later instant, or rejects it. Wall-clock times
+// skipped by a spring-forward transition do not exist and are rejected.
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	AmbiguousEarlier = "earlier"
+	AmbiguousLater   = "later"
+	AmbiguousError   = "error"
+)
+
+// Units of epoch values.
+const (
+	EpochAuto         = "auto"
+	EpochSeconds      = "s"
+	EpochMilliseconds = "ms"
+	EpochMicroseconds = "us"
+	EpochNanoseconds  = "ns"
+)
+
+var epochUnitNanos = map[string]int64{EpochSeconds: 1e9, EpochMilliseconds: 1e6, EpochMicroseconds: 1e3, EpochNanoseconds: 1}
+
+// ISO 8601 layouts with an offset or Z; Z07:00 also reads a literal Z.
+var ISO8601ZonedLayouts = []string{
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02T15:04:05Z0700", // OPSD cet_cest_timestamp: 2015-01-01T00:00:00+0100
+	"2006-01-02T15:04:05Z07",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05Z0700",
+	"2006-01-02T15:04Z07:00",
+	"20060102T150405Z0700",
+}
+
+// ISO 8601 (and common) layouts of wall-clock times.
+var ISO8601LocalLayouts = []string{
+	"2006-01-02T15:04:05",
+	TimeFormat1,
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"20060102T150405",
+	"20060102T1504",
+	"2006/01/02 15:04:05",
+	DateFormat,
+}
+
+// ISO 8601 basic dates: digits only, like epoch seconds.
+var ISO8601CompactLayouts = []string{"20060102"}
+
+// TimestampParser reads the time values of one dataset.
+type TimestampParser struct {
+	Layouts   []string       // dataset layouts tried before the ISO 8601 variants
+	Location  *time.Location // zone of values without an offset
+	Ambiguous string         // AmbiguousEarlier, AmbiguousLater or AmbiguousError
+	Epoch     string         // unit of epoch values; "" or EpochAuto reads it from the magnitude
+}
+
+// DefaultTimestampParser reads ISO 8601 and epoch values; wall-clock times are UTC.
+var DefaultTimestampParser = &TimestampParser{Location: time.UTC, Ambiguous: AmbiguousError}
+
+// layouts: dataset layouts (Go reference time) or nil; zone: an IANA name such as Europe/Berlin, or "" for UTC; ambiguous: "" for AmbiguousError;
+// epoch: the unit of epoch values, or "" for EpochAuto.
+func NewTimestampParser(layouts []string, zone, ambiguous, epoch string) (*TimestampParser, error) {
+	location, err := time.LoadLocation(zone)
+	if err != nil {
+		return nil, errors.New("unknown time zone " + zone + ": " + err.Error())
+	}
+	if len(ambiguous) == 0 {
+		ambiguous = AmbiguousError
+	}
+	switch ambiguous {
+	case AmbiguousEarlier, AmbiguousLater, AmbiguousError:
+	default:
+		return nil, errors.New("ambiguous must be one of earlier, later, error: " + ambiguous)
+	}
+	if len(epoch) == 0 {
+		epoch = EpochAuto
+	}
+	if _, ok := epochUnitNanos[epoch]; !ok && epoch != EpochAuto {
+		return nil, errors.New("epoch must be one of auto, s, ms, us, ns: " + epoch)
+	}
+	return &TimestampParser{Layouts: layouts, Location: location, Ambiguous: ambiguous, Epoch: epoch}, nil
+}
+
+// EpochUnit guesses the unit of an epoch integer from its magnitude.
+func EpochUnit(epoch int64) string {
+	if epoch < 0 {
+		epoch = -epoch
+	}
+	switch {
+	case epoch < 1e11:
+		return EpochSeconds
+	case epoch < 1e14:
+		return EpochMilliseconds
+	case epoch < 1e17:
+		return EpochMicroseconds
+	}
+	return EpochNanoseconds
+}
+
+// EpochTime converts an epoch integer in unit (EpochSeconds...EpochNanoseconds) to UTC.
+func EpochTime(epoch int64, unit string) time.Time {
+	perSecond := 1e9 / epochUnitNanos[unit]
+	return time.Unix(epoch/perSecond, epoch%perSecond*epochUnitNanos[unit]).UTC()
+}
+
+// The unit of the epoch integer value: declared by the dataset or guessed from its magnitude.
+func (tp *TimestampParser) EpochUnitOf(epoch int64) string {
+	if _, ok := epochUnitNanos[tp.Epoch]; ok {
+		return tp.Epoch
+	}
+	return EpochUnit(epoch)
+}
+
+// Epoch integers, and epoch seconds with a fraction (read digit by digit so no nanosecond is lost to float64).
+func (tp *TimestampParser) parseEpoch(value string) (time.Time, bool) {
+	if epoch, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return EpochTime(epoch, tp.EpochUnitOf(epoch)), true
+	}
+	seconds, fraction, found := strings.Cut(value, ".")
+	if !found || len(fraction) == 0 || len(fraction) > 9 || tp.EpochUnitOf(0) != EpochSeconds {
+		return time.Time{}, false
+	}
+	epoch, err := strconv.ParseInt(seconds, 10, 64)
+	if err != nil || EpochUnit(epoch) != EpochSeconds {
+		return time.Time{}, false
+	}
+	nanos, err := strconv.ParseUint(fraction+strings.Repeat("0", 9-len(fraction)), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if strings.HasPrefix(seconds, "-") {
+		return time.Unix(epoch, -int64(nanos)).UTC(), true
+	}
+	return time.Unix(epoch, int64(nanos)).UTC(), true
+}
+
+// The digit-only layout of the dataset or of ISO8601CompactLayouts that reads value when the epoch unit is guessed; "" if none does.
+func (tp *TimestampParser) CompactLayoutOf(value string) string {
+	if _, declared := epochUnitNanos[tp.Epoch]; declared || !isDigits(value) {
+		return ""
+	}
+	for _, layout := range append(append([]string{}, tp.Layouts...), ISO8601CompactLayouts...) {
+		if len(layout) != len(value) || !isDigits(layout) {
+			continue
+		}
+		if _, err := time.Parse(layout, value); err == nil {
+			return layout
+		}
+	}
+	return ""
+}
+
+func isDigits(value string) bool {
+	for _, r := range value {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return len(value) > 0
+}
+
+// A layout that contains an offset (Z07, -07, MST) or a literal Z reads absolute times.
+func isZonedLayout(layout string) bool {
+	return strings.Contains(layout, "Z") || strings.Contains(layout, "-07") || strings.Contains(layout, "MST")
+}
+
+// Parse value; an error names the value and never comes with a usable time.
+func (tp *TimestampParser) Parse(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if len(value) == 0 {
+		return time.Time{}, errors.New("empty time")
+	}
+	if layout := tp.CompactLayoutOf(value); len(layout) > 0 {
+		wallClock, _ := time.ParseInLocation(layout, value, time.UTC)
+		return tp.resolveWallClock(value, wallClock)
+	}
+	if t, ok := tp.parseEpoch(value); ok {
+		return t, nil
+	}
+	layouts := append(append(append([]string{}, tp.Layouts...), ISO8601ZonedLayouts...), ISO8601LocalLayouts...)
+	for _, layout := range layouts {
+		if isZonedLayout(layout) {
+			if t, err := time.Parse(layout, value); err == nil {
+				return t, nil
+			}
+			continue
+		}
+		wallClock, err := time.ParseInLocation(layout, value, time.UTC)
+		if err == nil {
+			return tp.resolveWallClock(value, wallClock)
+		}
+	}
+	return time.Time{}, errors.New("unrecognized time: <" + value + ">")
+}
+
+// The instant(s) whose wall clock in tp.Location reads like wallClock (parsed as UTC).
+func (tp *TimestampParser) resolveWallClock(value string, wallClock time.Time) (time.Time, error) {
+	location := tp.Location
+	if location == nil || location == time.UTC {
+		return wallClock, nil
+	}
+	candidates := make([]time.Time, 0, 2)
+	for _, probe := range []time.Duration{-24 * time.Hour, 24 * time.Hour} { // the offsets before and after any transition
+		_, offset := wallClock.Add(probe).In(location).Zone()
+		instant := wallClock.Add(-time.Duration(offset) * time.Second)
+		if instant.In(location).Format(time.DateTime+".999999999") == wallClock.Format(time.DateTime+".999999999") && (len(candidates) == 0 || !candidates[0].Equal(instant)) {
+			candidates = append(candidates, instant)
+		}
+	}
+	switch len(candidates) {
+	case 0:
+		return time.Time{}, errors.New("time does not exist in " + location.String() + " (daylight saving gap): <" + value + ">")
+	case 1:
+		return candidates[0], nil
+	}
+	if candidates[1].Before(candidates[0]) {
+		candidates[0], candidates[1] = candidates[1], candidates[0]
+	}
+	switch tp.Ambiguous {
+	case AmbiguousEarlier:
+		return candidates[0], nil
+	case AmbiguousLater:
+		return candidates[1], nil
+	}
+	return time.Time{}, errors.New("ambiguous time in " + location.String() + " (daylight saving overlap): <" + value + ">")
+}
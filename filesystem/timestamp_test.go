@@ -0,0 +1,57 @@
+package filesystem
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimestampParserParse(t *testing.T) {
+	berlin := func(ambiguous, epoch string) *TimestampParser {
+		parser, err := NewTimestampParser(nil, "Europe/Berlin", ambiguous, epoch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parser
+	}
+	utc := func(value string) time.Time {
+		instant, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return instant
+	}
+	tests := []struct {
+		name     string
+		parser   *TimestampParser
+		value    string
+		expected time.Time // zero when an error is expected
+	}{
+		{"compact date", berlin("", ""), "20170101", utc("2016-12-31T23:00:00Z")},
+		{"compact date and time", berlin("", ""), "20170101T0000", utc("2016-12-31T23:00:00Z")},
+		{"compact date in UTC", DefaultTimestampParser, "20170101", utc("2017-01-01T00:00:00Z")},
+		{"8 digits that are no date", DefaultTimestampParser, "20171301", time.Unix(20171301, 0).UTC()},
+		{"declared epoch seconds", berlin("", EpochSeconds), "20170101", time.Unix(20170101, 0).UTC()},
+		{"epoch seconds", berlin("", ""), "1483228800", utc("2017-01-01T00:00:00Z")},
+		{"epoch milliseconds", berlin("", ""), "1483228800123", utc("2017-01-01T00:00:00.123Z")},
+		{"offset wins over zone", berlin("", ""), "2017-01-01T00:00:00Z", utc("2017-01-01T00:00:00Z")},
+		{"fall-back hour, earlier", berlin(AmbiguousEarlier, ""), "2017-10-29 02:30:00", utc("2017-10-29T00:30:00Z")},
+		{"fall-back hour, later", berlin(AmbiguousLater, ""), "2017-10-29 02:30:00", utc("2017-10-29T01:30:00Z")},
+		{"fall-back hour, error", berlin(AmbiguousError, ""), "2017-10-29 02:30:00", time.Time{}},
+		{"hour after fall-back", berlin(AmbiguousError, ""), "2017-10-29 03:30:00", utc("2017-10-29T02:30:00Z")},
+		{"spring-forward hour", berlin(AmbiguousEarlier, ""), "2017-03-26 02:30:00", time.Time{}},
+		{"hour after spring-forward", berlin(AmbiguousError, ""), "2017-03-26 03:30:00", utc("2017-03-26T01:30:00Z")},
+		{"empty", DefaultTimestampParser, " ", time.Time{}},
+		{"unrecognized", DefaultTimestampParser, "yesterday", time.Time{}},
+	}
+	for _, test := range tests {
+		got, err := test.parser.Parse(test.value)
+		switch {
+		case test.expected.IsZero() && err == nil:
+			t.Errorf("%s: Parse(%q) = %v, want an error", test.name, test.value, got)
+		case !test.expected.IsZero() && err != nil:
+			t.Errorf("%s: Parse(%q): %v", test.name, test.value, err)
+		case !got.Equal(test.expected):
+			t.Errorf("%s: Parse(%q) = %v, want %v", test.name, test.value, got.UTC(), test.expected)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package main // gaps.go finds missing sampling intervals in ingested series and optionally fills them with flagged points.
+// netcdf gaps <root.device or pattern> [start=time] [end=time] [interval=auto|15min] [tolerance=1.5]
+//             [fill=none|null|ffill|linear|seasonal] [season=1d] [report=gaps.csv]
+// The nominal interval of each series is the median spacing of its timestamps unless interval= is given.
+// A gap is a spacing longer than tolerance x interval; its missing points lie on the interval grid after the last sample before it.
+// Filled points get a companion BOOLEAN series <measurement>_filled = true so provenance isn't lost; fill=null only writes the flags.
+// seasonal takes the value one season earlier (e.g. the same time yesterday) and falls back to linear when there is none.
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apache/iotdb-client-go/client"
+)
+
+const (
+	fillNone     = "none"
+	fillNull     = "null"
+	fillForward  = "ffill"
+	fillLinear   = "linear"
+	fillSeasonal = "seasonal"
+	filledSuffix = "_filled"
+)
+
+var gapParameters = map[string]string{"start": "", "end": "", "interval": "auto", "tolerance": "1.5", "fill": fillNone, "season": "1d", "report": ""}
+var fillStrategies = []string{fillNone, fillNull, fillForward, fillLinear, fillSeasonal}
+
+// GapSpan is one run of missing points of a series.
+type GapSpan struct {
+	Device      string `json:"device"`
+	Measurement string `json:"measurement"`
+	After       int64  `json:"after"`  // last sample before the gap, unix milliseconds
+	Before      int64  `json:"before"` // first sample after the gap
+	Missing     int    `json:"missing"`
+}
+
+// SeriesGaps summarizes one series.
+type SeriesGaps struct {
+	Device      string    `json:"device"`
+	Measurement string    `json:"measurement"`
+	IntervalMs  int64     `json:"intervalms"`
+	Samples     int       `json:"samples"`
+	Missing     int       `json:"missing"`
+	Spans       []GapSpan `json:"spans"`
+}
+
+// Median spacing of the timestamps; 0 with fewer than 2 samples.
+func InferInterval(samples []Sample) int64 {
+	deltas := make([]int64, 0, len(samples))
+	for ndx := 1; ndx < len(samples); ndx++ {
+		if delta := samples[ndx].Timestamp - samples[ndx-1].Timestamp; delta > 0 {
+			deltas = append(deltas, delta)
+		}
+	}
+	if len(deltas) == 0 {
+		return 0
+	}
+	sort.Slice(deltas, func(i, j int) bool { return deltas[i] < deltas[j] })
+	return deltas[len(deltas)/2]
+}
+
+// Report the spans where consecutive samples are more than tolerance x intervalMs apart.
+func FindGaps(device, measurement string, samples []Sample, intervalMs int64, tolerance float64) SeriesGaps {
+	gaps := SeriesGaps{Device: device, Measurement: measurement, IntervalMs: intervalMs, Samples: len(samples), Spans: make([]GapSpan, 0)}
+	if intervalMs <= 0 {
+		return gaps
+	}
+	for ndx := 1; ndx < len(samples); ndx++ {
+		delta := samples[ndx].Timestamp - samples[ndx-1].Timestamp
+		if float64(delta) <= tolerance*float64(intervalMs) {
+			continue
+		}
+		missing := int((delta - intervalMs/2) / intervalMs) // grid points strictly between the two samples
+		gaps.Spans = append(gaps.Spans, GapSpan{Device: device, Measurement: measurement, After: samples[ndx-1].Timestamp, Before: samples[ndx].Timestamp, Missing: missing})
+		gaps.Missing += missing
+	}
+	return gaps
+}
+
+// Return the latest sample at or before timestamp within samples (sorted by time); false if none.
+func sampleAt(samples []Sample, timestamp int64) (Sample, bool) {
+	ndx := sort.Search(len(samples), func(i int) bool { return samples[i].Timestamp > timestamp })
+	if ndx == 0 {
+		return Sample{}, false
+	}
+	return samples[ndx-1], true
+}
+
+// Estimate the missing points of gaps. A nil Value (fill=null) only flags the point.
+func FillGaps(samples []Sample, gaps SeriesGaps, strategy string, seasonMs int64) []Sample {
+	filled := make([]Sample, 0, gaps.Missing)
+	for _, span := range gaps.Spans {
+		before, _ := sampleAt(samples, span.After)
+		after, _ := sampleAt(samples, span.Before)
+		for k := 1; k <= span.Missing; k++ {
+			timestamp := span.After + int64(k)*gaps.IntervalMs
+			point := Sample{Timestamp: timestamp}
+			switch strategy {
+			case fillForward:
+				point.Value = before.Value
+			case fillLinear, fillSeasonal:
+				if strategy == fillSeasonal && seasonMs > 0 {
+					if earlier, ok := sampleAt(samples, timestamp-seasonMs); ok && timestamp-seasonMs-earlier.Timestamp < gaps.IntervalMs {
+						point.Value = earlier.Value
+						break
+					}
+				}
+				v0, ok0 := numericValue(before.Value)
+				v1, ok1 := numericValue(after.Value)
+				if !ok0 || !ok1 {
+					point.Value = before.Value // states are held
+					break
+				}
+				fraction := float64(timestamp-span.After) / float64(span.Before-span.After)
+				point.Value = v0 + fraction*(v1-v0)
+			}
+			filled = append(filled, point)
+		}
+	}
+	return filled
+}
+
+// Create the <measurement>_filled flags that do not exist yet.
+func createFilledSeries(session *client.Session, device string, columns []ResampleColumn, strategy string) error {
+	existing, err := GetTimeseriesProfiles(session, device+".*")
+	if err != nil {
+		return err
+	}
+	created := make(map[string]bool, 0)
+	for _, profile := range existing {
+		created[profile.Timeseries] = true
+	}
+	_, encoding, compressor := getClientStorage("boolean")
+	var sb strings.Builder
+	for _, column := range columns {
+		flagName := column.MeasurementAlias + filledSuffix
+		if created[device+iotdbPathSep+flagName] {
+			continue
+		}
+		attributes := " ATTRIBUTES('datatype'='boolean', 'name'='" + strings.ReplaceAll(column.MeasurementName, "'", "''") + " filled', 'fill'='" + strategy + "') TAGS('units'='unitless')"
+		sb.WriteString(flagName + " BOOLEAN encoding=" + encoding + " compressor=" + compressor + attributes + ",")
+	}
+	if sb.Len() == 0 {
+		return nil
+	}
+	sql := "CREATE ALIGNED TIMESERIES " + device + "(" + strings.TrimSuffix(sb.String(), ",") + ");"
+	if _, err := session.ExecuteNonQueryStatement(sql); err != nil {
+		return errors.New(sql + ": " + err.Error())
+	}
+	return nil
+}
+
+// Insert the filled points and their flags; fills maps a column index to its points.
+func insertFilledPoints(session *client.Session, device string, columns []ResampleColumn, fills map[int][]Sample) error {
+	rows := make(map[int64]map[string]string, 0)
+	for ndx, points := range fills {
+		for _, point := range points {
+			row, ok := rows[point.Timestamp]
+			if !ok {
+				row = make(map[string]string, 0)
+				rows[point.Timestamp] = row
+			}
+			row[columns[ndx].MeasurementAlias+filledSuffix] = "true"
+			if point.Value == nil {
+				continue
+			}
+			if v, ok := point.Value.(float64); ok && (columns[ndx].IotdbType == "INT32" || columns[ndx].IotdbType == "INT64") {
+				point.Value = int64(math.Round(v)) // interpolated counts stay integers
+			}
+			row[columns[ndx].MeasurementAlias] = formatFrameValue(point.Value)
+		}
+	}
+	statements := make([]string, 0)
+	for timestamp, row := range rows {
+		names := make([]string, 0, len(row))
+		values := make([]string, 0, len(row))
+		for name, value := range row {
+			names = append(names, name)
+			values = append(values, value)
+		}
+		statements = append(statements, alignedInsertStatement(device, timestamp, names, values))
+	}
+	blockSize := getBlockSize(len(columns))
+	for start := 0; start < len(statements); start += blockSize {
+		end := start + blockSize
+		if end > len(statements) {
+			end = len(statements)
+		}
+		if _, err := session.ExecuteBatchStatement(statements[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeGapReport(fileName string, report []SeriesGaps) error {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	csvWriter := csv.NewWriter(file)
+	_ = csvWriter.Write([]string{"device", "measurement", "intervalms", "after", "before", "missing"})
+	for _, series := range report {
+		for _, span := range series.Spans {
+			_ = csvWriter.Write([]string{span.Device, span.Measurement, strconv.FormatInt(series.IntervalMs, 10), formatMilliseconds(span.After), formatMilliseconds(span.Before), strconv.Itoa(span.Missing)})
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+func formatMilliseconds(timestamp int64) string {
+	return time.UnixMilli(timestamp).UTC().Format(time.RFC3339Nano)
+}
+
+// programArgs: gaps <root.device or pattern> [start=] [end=] [interval=auto] [tolerance=1.5] [fill=none|null|ffill|linear|seasonal] [season=1d] [report=gaps.csv]
+func ProcessGaps(programArgs []string) {
+	if len(programArgs) < 3 || !strings.HasPrefix(programArgs[2], "root.") {
+		checkErr("gaps", errors.New("expected: gaps <root.device> [interval=auto|15min] [fill=none|null|ffill|linear|seasonal] [report=gaps.csv]"))
+	}
+	parameters := getProgramParameters(programArgs, gapParameters)
+	strategy := strings.ToLower(parameters["fill"])
+	if !contains(fillStrategies, strategy) {
+		checkErr("gaps", errors.New("fill must be one of "+strings.Join(fillStrategies, ", ")))
+	}
+	intervalMs := int64(0)
+	var err error
+	if !strings.EqualFold(parameters["interval"], "auto") {
+		intervalMs, err = ParseInterval(parameters["interval"])
+		checkErr("gaps(interval)", err)
+	}
+	seasonMs, err := ParseInterval(parameters["season"])
+	checkErr("gaps(season)", err)
+	tolerance, err := strconv.ParseFloat(parameters["tolerance"], 64)
+	if err != nil || tolerance < 1 {
+		checkErr("gaps", errors.New("tolerance must be a number >= 1: "+parameters["tolerance"]))
+	}
+	startTime, err := parseReplayTime(parameters["start"], 0)
+	checkErr("gaps(start)", err)
+	endTime, err := parseReplayTime(parameters["end"], time.Now().UTC().UnixMilli()+1)
+	checkErr("gaps(end)", err)
+
+	iotdbConnection, ok := Init_IoTDB(true)
+	if !ok {
+		checkErr("Init_IoTDB: ", errors.New(iotdbConnection))
+	}
+	session := client.NewSession(clientConfig)
+	err = session.Open(false, 0)
+	checkErr("Open", err)
+	defer session.Close()
+
+	devices := []IotdbDevice{{Device: programArgs[2]}}
+	if strings.Contains(programArgs[2], anyOneNode) {
+		devices, err = ShowDevices(&session, programArgs[2])
+		checkErr("ShowDevices", err)
+	}
+	report := make([]SeriesGaps, 0)
+	fmt.Printf("%-48s %-32s %12s %10s %8s %10s\n", "device", "measurement", "interval", "samples", "gaps", "missing")
+	for _, device := range devices {
+		profiles, err := GetTimeseriesProfiles(&session, device.Device+".*")
+		checkErr("GetTimeseriesProfiles", err)
+		exported, err := exportColumns(profiles, nil, false, nil)
+		checkErr("gaps("+device.Device+")", err)
+		columns := make([]ResampleColumn, 0, len(exported))
+		for _, column := range exported {
+			if !isCompanionSeries(column.MeasurementAlias) {
+				columns = append(columns, ResampleColumn{ExportColumn: column})
+			}
+		}
+		err = readDeviceSamples(&session, device.Device, columns, startTime, endTime)
+		checkErr("readDeviceSamples", err)
+
+		fills := make(map[int][]Sample, 0)
+		for ndx, column := range columns {
+			seriesInterval := intervalMs
+			if seriesInterval == 0 {
+				seriesInterval = InferInterval(column.Samples)
+			}
+			gaps := FindGaps(device.Device, column.MeasurementAlias, column.Samples, seriesInterval, tolerance)
+			report = append(report, gaps)
+			fmt.Printf("%-48s %-32s %12s %10d %8d %10d\n", device.Device, column.MeasurementAlias, time.Duration(seriesInterval)*time.Millisecond, gaps.Samples, len(gaps.Spans), gaps.Missing)
+			if strategy != fillNone && gaps.Missing > 0 {
+				fills[ndx] = FillGaps(column.Samples, gaps, strategy, seasonMs)
+			}
+		}
+		if len(fills) > 0 {
+			err = createFilledSeries(&session, device.Device, columns, strategy)
+			checkErr("createFilledSeries", err)
+			err = insertFilledPoints(&session, device.Device, columns, fills)
+			checkErr("insertFilledPoints", err)
+			fmt.Println("IOTDB TEST QUERY: SELECT * FROM " + device.Device + " WHERE " + columns[0].MeasurementAlias + filledSuffix + " = true LIMIT 2;")
+		}
+	}
+	if len(parameters["report"]) > 0 {
+		err = writeGapReport(parameters["report"], report)
+		checkErr("writeGapReport", err)
+		fmt.Println("Missing spans written to " + parameters["report"])
+	}
+}
@@ -1,10 +1,27 @@
 module netcdf
 
-go 1.21
+go 1.22
 
 require (
 	github.com/apache/iotdb-client-go v1.1.7
+	github.com/eclipse/paho.mqtt.golang v1.4.3
 	github.com/fhs/go-netcdf v1.2.1
+	github.com/gorilla/websocket v1.5.0
+	github.com/klauspost/compress v1.18.0
+	github.com/xitongsys/parquet-go v1.5.5-0.20201110004701-b09c49d6d457
+	google.golang.org/grpc v1.56.3
+	google.golang.org/protobuf v1.31.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
-require github.com/apache/thrift v0.18.1 // indirect
+require (
+	github.com/apache/thrift v0.18.1 // indirect
+	github.com/golang/protobuf v1.5.3 // indirect
+	github.com/golang/snappy v0.0.1 // indirect
+	github.com/xitongsys/parquet-go-source v0.0.0-20200817004010-026bad9b25d0 // indirect
+	golang.org/x/net v0.17.0 // indirect
+	golang.org/x/sync v0.4.0 // indirect
+	golang.org/x/sys v0.13.0 // indirect
+	golang.org/x/text v0.13.0 // indirect
+	google.golang.org/genproto v0.0.0-20230410155749-daa745c078e1 // indirect
+)
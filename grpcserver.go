@@ -0,0 +1,255 @@
+package main // grpcserver.go serves the SensorStream gRPC API (sensorpb/sensorstream.proto) for Go, Java and Python subscribers.
+// Listens on GRPCPORT (netcdf.env) alongside the WebSocket server and shares its hub, so replayed data is streamed too.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/apache/iotdb-client-go/client"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"netcdf/sensorpb"
+)
+
+const (
+	defaultGrpcPort    = "9899"
+	grpcSessionPoolMax = 8
+)
+
+func GetGrpcPort() string {
+	port := os.Getenv("GRPCPORT")
+	if len(port) == 0 {
+		return defaultGrpcPort
+	}
+	return port
+}
+
+// SensorStreamService implements sensorpb.SensorStreamServer and the REST endpoints with IoTDB sessions taken from a pool.
+type SensorStreamService struct {
+	sensorpb.UnimplementedSensorStreamServer
+	Hub  *SensorStreamHub
+	pool client.SessionPool
+}
+
+func NewSensorStreamService(hub *SensorStreamHub) *SensorStreamService {
+	return &SensorStreamService{Hub: hub, pool: NewIotdbSessionPool(grpcSessionPoolMax)}
+}
+
+// Convert a value returned by SessionDataSet.GetValue() or parseDataItem().
+func toProtoValue(value interface{}) *sensorpb.Value {
+	switch v := value.(type) {
+	case float64:
+		return &sensorpb.Value{Kind: &sensorpb.Value_DoubleValue{DoubleValue: v}}
+	case float32:
+		return &sensorpb.Value{Kind: &sensorpb.Value_DoubleValue{DoubleValue: float64(v)}}
+	case int64:
+		return &sensorpb.Value{Kind: &sensorpb.Value_IntValue{IntValue: v}}
+	case int32:
+		return &sensorpb.Value{Kind: &sensorpb.Value_IntValue{IntValue: int64(v)}}
+	case bool:
+		return &sensorpb.Value{Kind: &sensorpb.Value_BoolValue{BoolValue: v}}
+	case string:
+		return &sensorpb.Value{Kind: &sensorpb.Value_StringValue{StringValue: v}}
+	default:
+		return &sensorpb.Value{Kind: &sensorpb.Value_StringValue{StringValue: fmt.Sprint(v)}}
+	}
+}
+
+func toProtoFrame(frame SensorFrame) *sensorpb.Frame {
+	pf := sensorpb.Frame{Device: frame.Device, Timestamp: frame.Timestamp, Measurements: make(map[string]*sensorpb.Value, len(frame.Measurements))}
+	for name, value := range frame.Measurements {
+		pf.Measurements[name] = toProtoValue(value)
+	}
+	return &pf
+}
+
+// Run a query with a pooled session and return its rows as frames.
+func (service *SensorStreamService) queryFrames(sql string) ([]SensorFrame, error) {
+	session, err := service.pool.GetSession()
+	if err != nil {
+		return nil, err
+	}
+	defer service.pool.PutBack(session)
+	timeout := tailQueryTimeout
+	sds, err := session.ExecuteQueryStatement(sql, &timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer sds.Close()
+	frames := make([]SensorFrame, 0)
+	_, err = sessionDataSetFrames(sds, nil, func(frame SensorFrame) { frames = append(frames, frame) })
+	return frames, err
+}
+
+// SHOW DEVICES <prefix>.** WITH DATABASE
+func (service *SensorStreamService) listDevices(prefix string) ([]IotdbDevice, error) {
+	prefix = strings.TrimSuffix(strings.TrimSpace(prefix), iotdbPathSep)
+	if len(prefix) == 0 {
+		prefix = "root"
+	}
+	session, err := service.pool.GetSession()
+	if err != nil {
+		return nil, err
+	}
+	defer service.pool.PutBack(session)
+	return ShowDevices(&session, prefix+".**")
+}
+
+// SHOW TIMESERIES <device>.*
+func (service *SensorStreamService) describeDevice(device string) ([]IotdbTimeseriesProfile, error) {
+	if !strings.HasPrefix(device, "root.") {
+		return nil, errors.New("device must start with root.")
+	}
+	session, err := service.pool.GetSession()
+	if err != nil {
+		return nil, err
+	}
+	defer service.pool.PutBack(session)
+	return GetTimeseriesProfiles(&session, device+".*")
+}
+
+// Add the units of the series of pattern to the hub, so units filters apply before the IotdbTail has polled the pattern.
+func (service *SensorStreamService) loadUnits(pattern string) error {
+	session, err := service.pool.GetSession()
+	if err != nil {
+		return err
+	}
+	defer service.pool.PutBack(session)
+	profiles, err := GetTimeseriesProfiles(&session, pattern)
+	if err != nil {
+		return err
+	}
+	service.Hub.AddUnits(profileUnits(profiles))
+	return nil
+}
+
+func (service *SensorStreamService) ListDatasets(ctx context.Context, request *sensorpb.ListDatasetsRequest) (*sensorpb.ListDatasetsResponse, error) {
+	devices, err := service.listDevices(request.Prefix)
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	response := sensorpb.ListDatasetsResponse{}
+	for _, device := range devices {
+		response.Datasets = append(response.Datasets, &sensorpb.Dataset{Device: device.Device, Database: device.Database, Aligned: device.Aligned})
+	}
+	return &response, nil
+}
+
+// The MeasurementItem list of one device.
+func (service *SensorStreamService) DescribeDataset(ctx context.Context, request *sensorpb.DescribeDatasetRequest) (*sensorpb.DatasetDescription, error) {
+	profiles, err := service.describeDevice(request.Device)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if len(profiles) == 0 {
+		return nil, status.Error(codes.NotFound, "no time series under "+request.Device)
+	}
+	description := sensorpb.DatasetDescription{Device: request.Device, Database: profiles[0].Database}
+	for ndx, profile := range profiles {
+		mi := profile.ToMeasurementItem(ndx)
+		description.Measurements = append(description.Measurements, &sensorpb.MeasurementItem{
+			MeasurementName:  mi.MeasurementName,
+			MeasurementAlias: mi.MeasurementAlias,
+			MeasurementType:  mi.MeasurementType,
+			MeasurementUnits: mi.MeasurementUnits,
+			ColumnOrder:      int32(mi.ColumnOrder),
+			IotdbDataType:    profile.DataType,
+		})
+	}
+	return &description, nil
+}
+
+func (service *SensorStreamService) Query(ctx context.Context, request *sensorpb.QueryRequest) (*sensorpb.QueryResponse, error) {
+	sql, err := BuildRangeQuery(request.Path, request.StartTime, request.EndTime, request.Aggregation, request.IntervalMs)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	frames, err := service.queryFrames(sql)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	response := sensorpb.QueryResponse{Frames: make([]*sensorpb.Frame, 0, len(frames))}
+	for _, frame := range frames {
+		response.Frames = append(response.Frames, toProtoFrame(frame))
+	}
+	return &response, nil
+}
+
+// Send the stored rows since FromTime, then live frames until the client cancels.
+func (service *SensorStreamService) Subscribe(request *sensorpb.SubscribeRequest, stream sensorpb.SensorStream_SubscribeServer) error {
+	if !strings.HasPrefix(request.PathPattern, "root.") {
+		return status.Error(codes.InvalidArgument, "path_pattern must start with root.")
+	}
+	options := SubscriptionOptions{
+		Measurements: request.Measurements,
+		Units:        request.Units,
+		Where:        request.Where,
+		BufferSize:   int(request.BufferSize),
+		Policy:       request.OverflowPolicy,
+	}
+	if request.Window != nil {
+		options.Window = &WindowSpec{Kind: request.Window.Kind, Seconds: request.Window.Seconds, Step: request.Window.StepSeconds, Aggregates: request.Window.Aggregates}
+	}
+	sub, err := NewSubscriber(request.PathPattern, options)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := service.loadUnits(request.PathPattern); err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	history := make([]SensorFrame, 0)
+	if request.FromTime > 0 {
+		sql, err := BuildRangeQuery(request.PathPattern, request.FromTime, time.Now().UTC().UnixMilli(), "", 0)
+		if err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		if history, err = service.queryFrames(sql); err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+	}
+	// Shaped by the same measurements, units, where and window as the live frames, which reach the window only after it.
+	history = service.Hub.ShapeHistory(sub, history)
+	service.Hub.Register(sub)
+	defer service.Hub.Unsubscribe(sub.Id)
+	for _, frame := range history {
+		if err := stream.Send(toProtoFrame(frame)); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case frame, ok := <-sub.Frames:
+			if !ok {
+				if sub.Disconnected {
+					return status.Error(codes.ResourceExhausted, "subscriber buffer is full")
+				}
+				return nil
+			}
+			if err := stream.Send(toProtoFrame(frame)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Blocks serving gRPC clients on GRPCPORT.
+func ServeGrpc(service *SensorStreamService) error {
+	listener, err := net.Listen("tcp", ":"+GetGrpcPort())
+	if err != nil {
+		return err
+	}
+	server := grpc.NewServer()
+	sensorpb.RegisterSensorStreamServer(server, service)
+	fmt.Println("Serving gRPC SensorStream at 0.0.0.0:" + GetGrpcPort())
+	return server.Serve(listener)
+}
@@ -0,0 +1,316 @@
+package main // homes.go joins the Ecobee home metadata table onto the household devices as TAGS and ATTRIBUTES, and selects devices by them.
+// netcdf homes join <meta_data.csv> [database=root.ecobee.household] [id=Identifier] [tags=Country,ProvinceState,City] [dryrun=true]
+//   Each house row (city, province, floor area, floors, vintage, HVAC types, occupants, ...) is upserted on every time series of the device
+//   <database>.<Identifier>: the tags= columns as TAGS, which SHOW TIMESERIES ... WHERE TAGS(provincestate)='ON' can filter, the others as ATTRIBUTES.
+//   Keys are the column names in lower case with underscores: "Floor Area [ft2]" => floor_area_ft2.
+// netcdf homes select [database=root.ecobee.household] <key op value> ...
+//   e.g. netcdf homes select number_floors=2 provincestate=ON "hvac~heat pump" : the 2-storey homes in Ontario with heat pumps.
+//   op is one of = != >= <= > < (numbers compare as numbers, text ignoring case) or ~ (contains, ignoring case); a device matches all conditions.
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/apache/iotdb-client-go/client"
+)
+
+var homesParameters = map[string]string{"database": "root.ecobee.household", "id": "Identifier", "tags": "Country,ProvinceState,City", "dryrun": "false"}
+
+// Keys of the time series themselves; metadata columns with these names are prefixed by home_.
+var seriesKeys = []string{"datatype", "name", unitsName, "description", "min", "max", "maxrate", "annotation", "categories"}
+
+// One row of the metadata table per house, by Identifier.
+type HomeMetadata struct {
+	Keys  []string                     // metadataKey of each column except the identifier, in column order
+	Homes map[string]map[string]string // Identifier => key => value
+}
+
+// "Floor Area [ft2]" => floor_area_ft2
+func metadataKey(column string) string {
+	var sb strings.Builder
+	separate := false
+	for _, r := range strings.ToLower(strings.TrimSpace(column)) {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') {
+			separate = true
+			continue
+		}
+		if separate && sb.Len() > 0 {
+			sb.WriteByte('_')
+		}
+		sb.WriteRune(r)
+		separate = false
+	}
+	if contains(seriesKeys, sb.String()) {
+		return "home_" + sb.String()
+	}
+	return sb.String()
+}
+
+func ReadHomeMetadata(filePath, idColumn string) (HomeMetadata, error) {
+	records, err := readCsvRecords(filePath, false)
+	if err != nil {
+		return HomeMetadata{}, err
+	}
+	if len(records) == 0 {
+		return HomeMetadata{}, errors.New(filePath + ": no header row")
+	}
+	idIndex := fieldIndex(records[0], idColumn)
+	if idIndex < 0 {
+		return HomeMetadata{}, errors.New(filePath + ": no " + idColumn + " column; give id=<column>")
+	}
+	metadata := HomeMetadata{Keys: make([]string, len(records[0])), Homes: make(map[string]map[string]string, len(records)-1)}
+	for ndx, column := range records[0] {
+		if ndx != idIndex {
+			metadata.Keys[ndx] = metadataKey(column)
+		}
+	}
+	for _, row := range records[1:] {
+		if idIndex >= len(row) || len(strings.TrimSpace(row[idIndex])) == 0 {
+			continue
+		}
+		home := make(map[string]string, len(row))
+		for ndx, value := range row {
+			if value = strings.TrimSpace(value); ndx != idIndex && len(value) > 0 {
+				home[metadata.Keys[ndx]] = value
+			}
+		}
+		metadata.Homes[strings.TrimSpace(row[idIndex])] = home
+	}
+	return metadata, nil
+}
+
+// 'k1'='v1', 'k2'='v2' of the keys that have values.
+func keyValuePairs(keys []string, values map[string]string) string {
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if value, ok := values[key]; ok {
+			pairs = append(pairs, "'"+key+"'='"+strings.ReplaceAll(value, "'", "''")+"'")
+		}
+	}
+	return strings.Join(pairs, ", ")
+}
+
+// ALTER timeseries <path> UPSERT TAGS('provincestate'='ON') ATTRIBUTES('number_floors'='2', ...);
+func homeUpsertStatement(timeseries string, home map[string]string, tagKeys, attributeKeys []string) string {
+	statement := "ALTER timeseries " + timeseries + " UPSERT"
+	if tags := keyValuePairs(tagKeys, home); len(tags) > 0 {
+		statement += " TAGS(" + tags + ")"
+	}
+	if attributes := keyValuePairs(attributeKeys, home); len(attributes) > 0 {
+		statement += " ATTRIBUTES(" + attributes + ")"
+	}
+	return statement + ";"
+}
+
+// The device of a time series path and its last node below database; false for series that are not directly below database.<device>.
+func homeOfSeries(timeseries, database string) (string, string, bool) {
+	device := timeseries[:max(strings.LastIndex(timeseries, iotdbPathSep), 0)]
+	home, found := strings.CutPrefix(device, database+iotdbPathSep)
+	return device, home, found && len(home) > 0 && !strings.Contains(home, iotdbPathSep)
+}
+
+// Upsert the metadata of each house on the time series of its device.
+func joinHomeMetadata(access *IoTDbAccess, session *client.Session, metadataFile string, parameters map[string]string) error {
+	metadata, err := ReadHomeMetadata(metadataFile, parameters["id"])
+	if err != nil {
+		return err
+	}
+	tagKeys := make([]string, 0)
+	for _, column := range splitList(parameters["tags"]) {
+		tagKeys = append(tagKeys, metadataKey(column))
+	}
+	attributeKeys := make([]string, 0, len(metadata.Keys))
+	for _, key := range metadata.Keys {
+		if len(key) > 0 && !contains(tagKeys, key) {
+			attributeKeys = append(attributeKeys, key)
+		}
+	}
+	database := parameters["database"]
+	profiles, err := GetTimeseriesProfiles(session, database+".**")
+	if err != nil {
+		return err
+	}
+	joined, unknown := make(map[string]bool, 0), make(map[string]bool, 0)
+	statements := make([]string, 0, maxColumns)
+	for _, profile := range profiles {
+		_, home, ok := homeOfSeries(profile.Timeseries, database)
+		if !ok {
+			continue
+		}
+		values, found := metadata.Homes[home]
+		if !found {
+			unknown[home] = true
+			continue
+		}
+		joined[home] = true
+		statements = append(statements, homeUpsertStatement(profile.Timeseries, values, tagKeys, attributeKeys))
+		if len(statements) == maxColumns {
+			if err := access.executeBatch(statements); err != nil {
+				return err
+			}
+			statements = statements[:0]
+		}
+	}
+	if len(statements) > 0 {
+		if err := access.executeBatch(statements); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Joined the metadata of %d homes onto %s; %d devices have no metadata row; %d metadata rows have no device.\n",
+		len(joined), database, len(unknown), len(metadata.Homes)-len(joined))
+	if len(tagKeys) > 0 {
+		fmt.Println("IOTDB TEST QUERY: SHOW TIMESERIES " + database + ".** WHERE TAGS(" + tagKeys[0] + ") = '<value>';")
+	}
+	return nil
+}
+
+// A condition on a home tag or attribute: "number_floors>=2", "provincestate=ON", "hvac~heat pump".
+type AttributePredicate struct {
+	Key      string
+	Operator string
+	Value    string
+}
+
+// The key ends at the leftmost operator, the two-character one when two start there: "x=a~b" is x = "a~b", "y>=1" is y >= 1.
+func ParseAttributePredicate(condition string) (AttributePredicate, error) {
+	first, operator := -1, ""
+	for _, candidate := range append([]string{"~"}, predicateOperators...) {
+		if index := strings.Index(condition, candidate); index >= 0 && (first < 0 || index < first || (index == first && len(candidate) > len(operator))) {
+			first, operator = index, candidate
+		}
+	}
+	if first <= 0 {
+		return AttributePredicate{}, errors.New("condition needs a key and one of ~ " + strings.Join(predicateOperators, " ") + ": " + condition)
+	}
+	return AttributePredicate{Key: metadataKey(condition[:first]), Operator: operator, Value: strings.TrimSpace(condition[first+len(operator):])}, nil
+}
+
+// Numbers compare as numbers (ValuePredicate), other values as text ignoring case. A device without the key does not match.
+func (ap AttributePredicate) Test(attributes map[string]string) bool {
+	value, ok := attributes[ap.Key]
+	if !ok {
+		return false
+	}
+	if ap.Operator == "~" {
+		return strings.Contains(strings.ToLower(value), strings.ToLower(ap.Value))
+	}
+	number, err1 := strconv.ParseFloat(value, 64)
+	wanted, err2 := strconv.ParseFloat(ap.Value, 64)
+	if err1 == nil && err2 == nil {
+		return (&ValuePredicate{Operator: ap.Operator, Value: wanted}).Test(number)
+	}
+	order := strings.Compare(strings.ToLower(value), strings.ToLower(ap.Value))
+	switch ap.Operator {
+	case ">=":
+		return order >= 0
+	case "<=":
+		return order <= 0
+	case "!=":
+		return order != 0
+	case ">":
+		return order > 0
+	case "<":
+		return order < 0
+	default: // == and =
+		return order == 0
+	}
+}
+
+// The TAGS and ATTRIBUTES of each device below database, merged over its time series; the keys of the series themselves are left out.
+func DeviceAttributes(session *client.Session, database string) (map[string]map[string]string, error) {
+	profiles, err := GetTimeseriesProfiles(session, database+".**")
+	if err != nil {
+		return nil, err
+	}
+	devices := make(map[string]map[string]string, 0)
+	for _, profile := range profiles {
+		device := profile.Timeseries[:max(strings.LastIndex(profile.Timeseries, iotdbPathSep), 0)]
+		if _, ok := devices[device]; !ok {
+			devices[device] = make(map[string]string, 0)
+		}
+		for _, pairs := range []string{profile.Attributes, profile.Tags} { // tags win
+			for key, value := range parseKeyValuePairs(pairs) {
+				if !contains(seriesKeys, key) {
+					devices[device][key] = value
+				}
+			}
+		}
+	}
+	return devices, nil
+}
+
+// The sorted devices below database that match every predicate, with their attributes.
+func SelectDevices(session *client.Session, database string, predicates []AttributePredicate) ([]string, map[string]map[string]string, error) {
+	devices, err := DeviceAttributes(session, database)
+	if err != nil {
+		return nil, nil, err
+	}
+	selected := make([]string, 0)
+	for device, attributes := range devices {
+		matches := true
+		for _, predicate := range predicates {
+			matches = matches && predicate.Test(attributes)
+		}
+		if matches {
+			selected = append(selected, device)
+		}
+	}
+	sort.Strings(selected)
+	return selected, devices, nil
+}
+
+func ProcessHomes(programArgs []string) {
+	if len(programArgs) < 3 || (programArgs[2] != "join" && programArgs[2] != "select") || (programArgs[2] == "join" && len(programArgs) < 4) {
+		checkErr("ProcessHomes", errors.New("expected: homes join <meta_data.csv> [database=] [id=] [tags=] | homes select [database=] <key op value> ..."))
+	}
+	parameters := getProgramParameters(programArgs, homesParameters)
+	iotdbConnection, ok := Init_IoTDB(true)
+	if !ok {
+		checkErr("ProcessHomes(Init_IoTDB): ", errors.New(iotdbConnection))
+	}
+	session := client.NewSession(clientConfig)
+	checkErr("ProcessHomes(session.Open)", session.Open(false, 0))
+	defer session.Close()
+
+	if programArgs[2] == "join" {
+		access := IoTDbAccess{session: session, DryRun: isDryRun(programArgs)}
+		checkErr("joinHomeMetadata", joinHomeMetadata(&access, &session, programArgs[3], parameters))
+		return
+	}
+	predicates := make([]AttributePredicate, 0)
+	for _, arg := range programArgs[3:] {
+		if key, _, found := strings.Cut(arg, "="); found {
+			if _, isParameter := homesParameters[strings.ToLower(key)]; isParameter {
+				continue
+			}
+		}
+		predicate, err := ParseAttributePredicate(arg)
+		checkErr("ParseAttributePredicate", err)
+		predicates = append(predicates, predicate)
+	}
+	selected, devices, err := SelectDevices(&session, parameters["database"], predicates)
+	checkErr("SelectDevices", err)
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	header := "DEVICE"
+	for _, predicate := range predicates {
+		header += "\t" + strings.ToUpper(predicate.Key)
+	}
+	fmt.Fprintln(writer, header)
+	for _, device := range selected {
+		line := device
+		for _, predicate := range predicates {
+			line += "\t" + devices[device][predicate.Key]
+		}
+		fmt.Fprintln(writer, line)
+	}
+	writer.Flush()
+	fmt.Printf("%d of %d devices selected.\n", len(selected), len(devices))
+	if len(selected) > 0 {
+		fmt.Println("IOTDB TEST QUERY: SELECT COUNT(*) FROM " + selected[0] + ";")
+	}
+}
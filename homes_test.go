@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestParseAttributePredicate(t *testing.T) {
+	tests := []struct {
+		condition string
+		expected  AttributePredicate
+	}{
+		{"city=a~b", AttributePredicate{Key: "city", Operator: "=", Value: "a~b"}},
+		{"age>=1", AttributePredicate{Key: "age", Operator: ">=", Value: "1"}},
+		{"x<=2", AttributePredicate{Key: "x", Operator: "<=", Value: "2"}},
+		{"name~=foo", AttributePredicate{Key: "home_name", Operator: "~", Value: "=foo"}},
+		{"hvac~heat pump", AttributePredicate{Key: "hvac", Operator: "~", Value: "heat pump"}},
+		{"style!=detached", AttributePredicate{Key: "style", Operator: "!=", Value: "detached"}},
+		{"a<b>c", AttributePredicate{Key: "a", Operator: "<", Value: "b>c"}},
+		{"Number Floors==2", AttributePredicate{Key: "number_floors", Operator: "==", Value: "2"}},
+	}
+	for _, test := range tests {
+		got, err := ParseAttributePredicate(test.condition)
+		if err != nil {
+			t.Errorf("ParseAttributePredicate(%q): %v", test.condition, err)
+			continue
+		}
+		if got != test.expected {
+			t.Errorf("ParseAttributePredicate(%q) = %+v, want %+v", test.condition, got, test.expected)
+		}
+	}
+	for _, condition := range []string{"noop", "=2", "~x"} {
+		if _, err := ParseAttributePredicate(condition); err == nil {
+			t.Errorf("ParseAttributePredicate(%q) accepted a condition without a key or operator", condition)
+		}
+	}
+}
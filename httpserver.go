@@ -0,0 +1,285 @@
+package main // httpserver.go serves REST queries and Server-Sent Events for browser dashboards on HTTPPORT (netcdf.env).
+//   GET /datasets[?prefix=root.ecobee]                  JSON list of IoTDB devices
+//   GET /datasets/{device}/measurements                 JSON MeasurementItem list, e.g. /datasets/root.ecobee.household.<id>/measurements
+//   GET /query?path=&start=&end=&agg=&interval=&format= JSON frames (default) or CSV; start/end are unix seconds or datetimes
+//   GET /stream?pattern=root.ecobee.household.*.*       text/event-stream of SensorFrame JSON
+//       [&measurements=a,b][&units=F][&where=T_ctrl>=75][&window=tumbling|sliding&seconds=3600&step=300&aggregates=mean,max]
+//       [&buffer=1024][&policy=drop-oldest|disconnect]
+// The SSE stream shares the hub of the WebSocket and gRPC servers.
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"filesystem" // work module
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultHttpPort      = "8080"
+	datasetsEndpoint     = "/datasets"
+	measurementsEndpoint = "/measurements"
+	queryEndpoint        = "/query"
+	streamEndpoint       = "/stream"
+	sseKeepAlive         = 15 * time.Second
+)
+
+func GetHttpPort() string {
+	port := os.Getenv("HTTPPORT")
+	if len(port) == 0 {
+		return defaultHttpPort
+	}
+	return port
+}
+
+func writeJson(w http.ResponseWriter, statusCode int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		fmt.Println("writeJson: " + err.Error())
+	}
+}
+
+func writeJsonError(w http.ResponseWriter, statusCode int, err error) {
+	writeJson(w, statusCode, map[string]string{"error": err.Error()})
+}
+
+// Accept unix seconds or a datetime; an empty value returns defaultValue (unix milliseconds).
+func getQueryTime(r *http.Request, name string, defaultValue int64) (int64, error) {
+	value := r.URL.Query().Get(name)
+	if len(value) == 0 {
+		return defaultValue, nil
+	}
+	t, err := filesystem.GetStartTimeFromLongint(value)
+	if err != nil {
+		return defaultValue, fmt.Errorf("bad %s time %s: %v", name, value, err)
+	}
+	return t.UTC().UnixMilli(), nil
+}
+
+// GET /datasets and GET /datasets/{device}/measurements
+func (service *SensorStreamService) handleDatasets(w http.ResponseWriter, r *http.Request) {
+	device := strings.Trim(strings.TrimPrefix(r.URL.Path, datasetsEndpoint), "/")
+	if len(device) == 0 {
+		devices, err := service.listDevices(r.URL.Query().Get("prefix"))
+		if err != nil {
+			writeJsonError(w, http.StatusServiceUnavailable, err)
+			return
+		}
+		writeJson(w, http.StatusOK, devices)
+		return
+	}
+	if !strings.HasSuffix(device, measurementsEndpoint) {
+		http.NotFound(w, r)
+		return
+	}
+	device = strings.TrimSuffix(device, measurementsEndpoint)
+	profiles, err := service.describeDevice(device)
+	if err != nil {
+		writeJsonError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(profiles) == 0 {
+		writeJsonError(w, http.StatusNotFound, fmt.Errorf("no time series under %s", device))
+		return
+	}
+	items := make([]MeasurementItem, 0, len(profiles))
+	for ndx, profile := range profiles {
+		items = append(items, profile.ToMeasurementItem(ndx))
+	}
+	writeJson(w, http.StatusOK, items)
+}
+
+// One header row of Time plus every device.measurement path; one row per timestamp.
+func writeFramesCsv(w http.ResponseWriter, frames []SensorFrame) error {
+	columnMap := make(map[string]bool, 0)
+	rows := make(map[int64]map[string]string, 0)
+	for _, frame := range frames {
+		row, ok := rows[frame.Timestamp]
+		if !ok {
+			row = make(map[string]string, 0)
+			rows[frame.Timestamp] = row
+		}
+		for name, value := range frame.Measurements {
+			columnMap[frame.MeasurementPath(name)] = true
+			row[frame.MeasurementPath(name)] = fmt.Sprint(value)
+		}
+	}
+	columns := make([]string, 0, len(columnMap))
+	for column := range columnMap {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	timestamps := make([]int64, 0, len(rows))
+	for timestamp := range rows {
+		timestamps = append(timestamps, timestamp)
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+
+	w.Header().Set("Content-Type", "text/csv")
+	csvWriter := csv.NewWriter(w)
+	_ = csvWriter.Write(append([]string{"Time"}, columns...))
+	for _, timestamp := range timestamps {
+		record := make([]string, 0, len(columns)+1)
+		record = append(record, time.UnixMilli(timestamp).UTC().Format(filesystem.TimeFormatNano))
+		for _, column := range columns {
+			record = append(record, rows[timestamp][column])
+		}
+		_ = csvWriter.Write(record)
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// GET /query?path=root.ecobee.household.<id>.*&start=2017-01-01T00:00:00Z&end=2017-01-02T00:00:00Z&agg=avg&interval=3600000&format=csv
+func (service *SensorStreamService) handleQuery(w http.ResponseWriter, r *http.Request) {
+	startTime, err := getQueryTime(r, "start", 0)
+	if err != nil {
+		writeJsonError(w, http.StatusBadRequest, err)
+		return
+	}
+	endTime, err := getQueryTime(r, "end", 0)
+	if err != nil {
+		writeJsonError(w, http.StatusBadRequest, err)
+		return
+	}
+	intervalMs := int64(0)
+	if interval := r.URL.Query().Get("interval"); len(interval) > 0 {
+		intervalMs, err = strconv.ParseInt(interval, 10, 64)
+		if err != nil {
+			writeJsonError(w, http.StatusBadRequest, fmt.Errorf("interval must be milliseconds: %s", interval))
+			return
+		}
+	}
+	sql, err := BuildRangeQuery(r.URL.Query().Get("path"), startTime, endTime, r.URL.Query().Get("agg"), intervalMs)
+	if err != nil {
+		writeJsonError(w, http.StatusBadRequest, err)
+		return
+	}
+	frames, err := service.queryFrames(sql)
+	if err != nil {
+		writeJsonError(w, http.StatusInternalServerError, err)
+		return
+	}
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	if format == "csv" || (len(format) == 0 && strings.Contains(r.Header.Get("Accept"), "text/csv")) {
+		if err := writeFramesCsv(w, frames); err != nil {
+			fmt.Println("handleQuery(csv): " + err.Error())
+		}
+		return
+	}
+	writeJson(w, http.StatusOK, frames)
+}
+
+func splitList(value string) []string {
+	list := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// SubscriptionOptions from the /stream query parameters.
+func subscriptionOptionsFromQuery(r *http.Request) (SubscriptionOptions, error) {
+	query := r.URL.Query()
+	options := SubscriptionOptions{
+		Measurements: splitList(query.Get("measurements")),
+		Units:        splitList(query.Get("units")),
+		Where:        query.Get("where"),
+		Policy:       query.Get("policy"),
+	}
+	var err error
+	if buffer := query.Get("buffer"); len(buffer) > 0 {
+		if options.BufferSize, err = strconv.Atoi(buffer); err != nil {
+			return options, fmt.Errorf("buffer must be a number of frames: %s", buffer)
+		}
+	}
+	if len(query.Get("window")) == 0 {
+		return options, nil
+	}
+	options.Window = &WindowSpec{Kind: query.Get("window"), Aggregates: splitList(query.Get("aggregates"))}
+	if options.Window.Seconds, err = strconv.ParseInt(query.Get("seconds"), 10, 64); err != nil {
+		return options, fmt.Errorf("window needs seconds: %s", query.Get("seconds"))
+	}
+	if step := query.Get("step"); len(step) > 0 {
+		if options.Window.Step, err = strconv.ParseInt(step, 10, 64); err != nil {
+			return options, fmt.Errorf("step must be seconds: %s", step)
+		}
+	}
+	return options, nil
+}
+
+// GET /stream?pattern=root.ecobee.household.*.*  Server-Sent Events: one `data:` line of SensorFrame JSON per frame.
+func (service *SensorStreamService) handleStream(w http.ResponseWriter, r *http.Request) {
+	pattern := r.URL.Query().Get("pattern")
+	if !strings.HasPrefix(pattern, "root.") {
+		writeJsonError(w, http.StatusBadRequest, fmt.Errorf("pattern must start with root."))
+		return
+	}
+	options, err := subscriptionOptionsFromQuery(r)
+	if err != nil {
+		writeJsonError(w, http.StatusBadRequest, err)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJsonError(w, http.StatusInternalServerError, fmt.Errorf("streaming unsupported"))
+		return
+	}
+	sub, err := service.Hub.Subscribe(pattern, options)
+	if err != nil {
+		writeJsonError(w, http.StatusBadRequest, err)
+		return
+	}
+	defer service.Hub.Unsubscribe(sub.Id)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	fmt.Fprintf(w, ": subscribed to %s\n\n", pattern)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case frame, ok := <-sub.Frames:
+			if !ok {
+				if sub.Disconnected {
+					fmt.Fprint(w, "event: disconnect\ndata: subscriber buffer is full\n\n")
+					flusher.Flush()
+				}
+				return
+			}
+			data, err := json.Marshal(frame)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: frame\ndata: %s\n\n", data)
+			flusher.Flush()
+		}
+	}
+}
+
+// Blocks serving REST and SSE clients on HTTPPORT.
+func ServeHttp(service *SensorStreamService) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc(datasetsEndpoint, service.handleDatasets)
+	mux.HandleFunc(datasetsEndpoint+"/", service.handleDatasets)
+	mux.HandleFunc(queryEndpoint, service.handleQuery)
+	mux.HandleFunc(streamEndpoint, service.handleStream)
+	fmt.Println("Serving REST and Server-Sent Events at http://0.0.0.0:" + GetHttpPort())
+	return http.ListenAndServe(":"+GetHttpPort(), mux)
+}
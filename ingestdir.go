@@ -0,0 +1,336 @@
+package main // ingestdir.go ingests a folder or glob of CSV files into one IoTDB database, one device per file, in a single process.
+// netcdf ingest --time-column timestamp --commands createdb,createts,insert [--database root.combed.iiitd] /data/combed
+// netcdf "/data/AMP/Electricity_*.csv" unix_ts createdb createts insert [database=root.amp.vancouver] [dryrun=true]
+// A folder stands for its *.csv files; the summary_ and quarantine_ files next to them are skipped. Several data files may also be listed.
+// Each file becomes the device <database>.<DatasetName>. The database is the identifier of the first summary file unless database= is given,
+// and createdb creates it once when it is missing. The schema (names, types and units of the columns) is computed once per distinct
+// header line, from the first file of that header that has a summary file or datapackage.json resource; files without a summary of their own
+// use it without min/max checks.
+// All files share one session; a failed file does not stop the others. One progress line is printed per file and a table of outcomes at the end,
+// which is also written to ingest_report.json in the folder of the first file.
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/apache/iotdb-client-go/client"
+)
+
+const ingestReportName = "ingest_report.json"
+
+var ingestParameters = map[string]string{"database": ""}
+
+// The columns shared by the files with one header line.
+type ingestSchema struct {
+	Number        int                         // 1, 2 ... in order of the first file
+	Header        []string                    // of the data files
+	Source        string                      // data file whose summary (or data package) the schema was computed from
+	Identifier    string                      // database of that summary
+	Summary       [][]string                  // for the files without a summary of their own
+	Measurements  map[string]*MeasurementItem // XsvSummaryTypeMap
+	MissingValues []string                    // of a data package
+}
+
+// IngestResult is the outcome of one file.
+type IngestResult struct {
+	Source   string        `json:"source"`
+	Device   string        `json:"device"`
+	Schema   int           `json:"schema"`
+	Status   string        `json:"status"`
+	Message  string        `json:"message,omitempty"`
+	Rows     int           `json:"rows"`
+	Accepted int           `json:"accepted"`
+	Rejected int           `json:"rejected"`
+	Duration time.Duration `json:"duration"`
+}
+
+// IngestReport is the consolidated report of one folder or glob.
+type IngestReport struct {
+	Database string          `json:"database"`
+	Commands []string        `json:"commands"`
+	Schemas  int             `json:"schemas"`
+	Files    []*IngestResult `json:"files"`
+}
+
+// A folder, a glob or a data file of an ingest.
+func isIngestSource(arg string) bool {
+	if strings.ContainsAny(arg, "*?[") || sourceExt(arg) == csvExtension {
+		return true
+	}
+	info, err := os.Stat(arg)
+	return err == nil && info.IsDir()
+}
+
+// True when programArgs start with a folder, a glob or several data files rather than the one data file of ProcessCsvSensorData.
+func isIngestSet(programArgs []string) bool {
+	if len(programArgs) < 2 || !isIngestSource(programArgs[1]) {
+		return false
+	}
+	if len(programArgs) > 2 && sourceExt(programArgs[2]) == csvExtension {
+		return true
+	}
+	if !sourceExists(programArgs[1]) {
+		return true // a glob
+	}
+	info, err := os.Stat(programArgs[1])
+	return err == nil && info.IsDir()
+}
+
+// Split programArgs into the CSV files of the leading sources (sorted per source, listed once) and the remaining arguments.
+func expandIngestSources(programArgs []string) ([]string, []string, error) {
+	files := make([]string, 0)
+	seen := make(map[string]bool, 0)
+	ndx := 1
+	for ; ndx < len(programArgs) && isIngestSource(programArgs[ndx]); ndx++ {
+		pattern := programArgs[ndx]
+		if info, err := os.Stat(pattern); err == nil && info.IsDir() {
+			pattern = filepath.Join(pattern, "*")
+		}
+		matches, err := globSources(pattern)
+		if err != nil {
+			return nil, nil, errors.New(programArgs[ndx] + ": " + err.Error())
+		}
+		found := 0
+		for _, match := range matches {
+			if isBatchIgnored(match) || sourceExt(match) != csvExtension || seen[match] {
+				continue
+			}
+			seen[match] = true
+			files = append(files, match)
+			found++
+		}
+		if found == 0 {
+			return nil, nil, errors.New("no data files match " + programArgs[ndx])
+		}
+	}
+	return files, programArgs[ndx:], nil
+}
+
+// Read a whole CSV file, or only its header line.
+func readCsvRecords(filePath string, headerOnly bool) ([][]string, error) {
+	file, err := OpenSource(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	csvReader := csv.NewReader(file)
+	if headerOnly {
+		header, err := csvReader.Read()
+		return [][]string{header}, err
+	}
+	return csvReader.ReadAll()
+}
+
+// A copy of summary without its min and max values, so files that borrow it are not range checked against another file.
+func summaryWithoutRanges(summary [][]string) [][]string {
+	columns := make([]int, 0)
+	for ndx, name := range summary[0] {
+		if name := strings.ToLower(name); name == "min" || name == "max" {
+			columns = append(columns, ndx)
+		}
+	}
+	stripped := make([][]string, len(summary))
+	for r, row := range summary {
+		stripped[r] = append([]string{}, row...)
+		for _, column := range columns {
+			if r > 0 && column < len(stripped[r]) {
+				stripped[r][column] = ""
+			}
+		}
+	}
+	return stripped
+}
+
+// Group the files by header line and compute the schema of each group once; the schema of each file is nil when its header is unreadable.
+func ingestSchemas(files []string, results []*IngestResult, programArgs []string) ([]*ingestSchema, []*ingestSchema) {
+	schemas := make([]*ingestSchema, 0)
+	byHeader := make(map[string]*ingestSchema, 0)
+	headers := make([]string, len(files))
+	for ndx, source := range files {
+		records, err := readCsvRecords(source, true)
+		if err != nil {
+			results[ndx].Status, results[ndx].Message = batchFailed, "header: "+err.Error()
+			continue
+		}
+		headers[ndx] = strings.Join(records[0], ",")
+		if _, ok := byHeader[headers[ndx]]; !ok {
+			schema := &ingestSchema{Number: len(schemas) + 1, Header: records[0]}
+			schemas = append(schemas, schema)
+			byHeader[headers[ndx]] = schema
+		}
+		results[ndx].Schema = byHeader[headers[ndx]].Number
+	}
+	for ndx, source := range files {
+		schema, ok := byHeader[headers[ndx]]
+		if !ok || schema.Measurements != nil {
+			continue
+		}
+		summary, missingValues, err := ReadDataFileSummary(source, programArgs) // summary file and/or datapackage.json
+		if err != nil {
+			continue // the next file of the header may have a summary
+		}
+		iot := IoTDbCsvDataFile{Summary: summary}
+		iot.XsvSummaryTypeMap()
+		schema.Source, schema.Identifier, schema.Summary, schema.Measurements, schema.MissingValues = source, iot.Identifier, summaryWithoutRanges(summary), iot.Measurements, missingValues
+	}
+	fileSchemas := make([]*ingestSchema, len(files))
+	for ndx := range files {
+		fileSchemas[ndx] = byHeader[headers[ndx]]
+	}
+	return schemas, fileSchemas
+}
+
+// Ingest one file into database with the commands; the file shares the session of access.
+func (result *IngestResult) ingest(access IoTDbAccess, schema *ingestSchema, database, timeColumn string, programArgs []string) error {
+	datasetName := sourceBase(result.Source)
+	result.Device = IotDatasetPrefix(database, datasetName)
+	timeParser, err := NewDatasetTimeParser(programArgs)
+	if err != nil {
+		return err
+	}
+	summary, missingValues, summaryErr := ReadDataFileSummary(result.Source, programArgs)
+	measurements := make(map[string]*MeasurementItem, len(schema.Measurements))
+	for name, item := range schema.Measurements {
+		own := *item // the files of a schema share its items, but not which columns are empty
+		switch {
+		case own.MeasurementAlias == LastColumnName:
+		case summaryErr != nil: // a borrowed summary does not say whether this file's column is empty
+			own.Ignore = own.MeasurementName == "time"
+		case own.ColumnOrder+1 < len(summary) && strings.TrimSpace(summary[own.ColumnOrder+1][0]) == own.MeasurementName:
+			row := summary[own.ColumnOrder+1]
+			own.Ignore = row[0] == "time" || isEmptyDataColumn(row)
+		}
+		measurements[name] = &own
+	}
+	iot := IoTDbCsvDataFile{IoTDbAccess: access, Identifier: database, Description: datasetName, DataFilePath: result.Source, DatasetName: datasetName,
+		TimeMeasurementName: timeColumn, Measurements: measurements, Summary: schema.Summary, MissingValues: schema.MissingValues, TimeParser: timeParser}
+	iot.Quiet = true
+	if summaryErr == nil {
+		iot.Summary, iot.MissingValues = summary, missingValues // its own min and max
+	}
+	if contains(iot.TimeseriesCommands, "insert") {
+		if iot.Dataset, err = readCsvRecords(result.Source, false); err != nil {
+			return err
+		}
+		iot.NormalizeValues()
+	}
+	if err = iot.ApplyAdapter(programArgs); err != nil { // raw ToN_IoT files
+		return err
+	}
+	err = iot.ProcessTimeseries()
+	result.Rows, result.Accepted, result.Rejected = iot.Quality.Rows, iot.Quality.Accepted, iot.Quality.Rejected
+	return err
+}
+
+// Print the outcome of each file and the totals; returns the number of failed files.
+func (report IngestReport) Print() int {
+	counts := make(map[string]int, 0)
+	rows, accepted, rejected := 0, 0, 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SOURCE\tDEVICE\tSCHEMA\tSTATUS\tROWS\tACCEPTED\tREJECTED\tSECONDS\tMESSAGE")
+	for _, result := range report.Files {
+		counts[result.Status]++
+		rows, accepted, rejected = rows+result.Rows, accepted+result.Accepted, rejected+result.Rejected
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%d\t%d\t%d\t%.1f\t%s\n", filepath.Base(result.Source), result.Device, result.Schema, result.Status, result.Rows, result.Accepted, result.Rejected, result.Duration.Seconds(), result.Message)
+	}
+	w.Flush()
+	fmt.Printf("%d files into %s with %d schemas: %d ok, %d failed; %d rows, %d accepted, %d rejected\n",
+		len(report.Files), report.Database, report.Schemas, counts[batchOk], counts[batchFailed], rows, accepted, rejected)
+	return counts[batchFailed]
+}
+
+// programArgs: <folder|glob|dataFile.csv ...> <timeMeasurementName> <commands> [database=root.x.y] [dryrun=true] [timelayout= timezone= ambiguous= epoch=]
+func ProcessCsvFiles(programArgs []string) {
+	files, arguments, err := expandIngestSources(programArgs)
+	checkErr("ingest", err)
+	if len(arguments) < 2 {
+		checkErr("ingest", errors.New("expected: <folder|glob|dataFile.csv ...> <timeMeasurementName> <commands>"))
+	}
+	timeColumn := arguments[0]
+	commands := GetTimeseriesCommands(arguments[1:])
+	database := getProgramParameters(arguments, ingestParameters)["database"]
+
+	results := make([]*IngestResult, len(files))
+	for ndx, source := range files {
+		results[ndx] = &IngestResult{Source: source}
+	}
+	schemas, fileSchemas := ingestSchemas(files, results, arguments)
+	for _, schema := range schemas {
+		if schema.Measurements == nil {
+			fmt.Printf("Schema %d: no summary file or data package for the header %s\n", schema.Number, strings.Join(schema.Header, ","))
+			continue
+		}
+		fmt.Printf("Schema %d: %d columns from the summary of %s (%s)\n", schema.Number, len(schema.Measurements), schema.Source, schema.Identifier)
+		if len(database) == 0 {
+			database = schema.Identifier
+		}
+	}
+	if len(database) == 0 {
+		checkErr("ingest", errors.New("no summary file names the database; give database=root.<group>.<name>"))
+	}
+
+	access := IoTDbAccess{DryRun: isDryRun(arguments)}
+	if !access.DryRun {
+		iotdbConnection, ok := Init_IoTDB(true)
+		if !ok {
+			checkErr("Init_IoTDB: ", errors.New(iotdbConnection))
+		}
+		access.session = client.NewSession(clientConfig)
+		checkErr("Open", access.session.Open(false, 0))
+		defer access.session.Close()
+	}
+	access.TimeseriesCommands = make([]string, 0, len(commands))
+	for _, command := range commands {
+		if command != "createdb" {
+			access.TimeseriesCommands = append(access.TimeseriesCommands, command)
+			continue
+		}
+		if err, failed := access.createBatchDatabases([]string{database})[database]; failed {
+			checkErr("CREATE DATABASE "+database, err)
+		}
+	}
+
+	fmt.Printf("Ingesting %d files into %s: %s\n", len(files), database, strings.Join(access.TimeseriesCommands, ", "))
+	for ndx, result := range results {
+		start := time.Now()
+		if len(result.Status) == 0 {
+			schema := fileSchemas[ndx]
+			switch {
+			case schema.Measurements == nil:
+				result.Status, result.Message = batchFailed, "no summary file or data package for schema "+strconv.Itoa(schema.Number)
+			case schema.Identifier != database:
+				fmt.Println("Note: " + filepath.Base(result.Source) + " is described as " + schema.Identifier + "; ingesting into " + database)
+				fallthrough
+			default:
+				if err := result.ingest(access, schema, database, timeColumn, arguments); err != nil {
+					result.Status, result.Message = batchFailed, err.Error()
+				} else {
+					result.Status = batchOk
+				}
+			}
+		}
+		result.Duration = time.Since(start)
+		fmt.Printf("[%d/%d] %-40s %-6s %d accepted, %d rejected (%.1fs)\n", ndx+1, len(files), filepath.Base(result.Source), result.Status, result.Accepted, result.Rejected, result.Duration.Seconds())
+	}
+
+	report := IngestReport{Database: database, Commands: commands, Schemas: len(schemas), Files: results}
+	failed := report.Print()
+	if jsonReport, err := json.MarshalIndent(report, "", "  "); err == nil {
+		reportPath := filepath.Join(sourceFolder(files[0]), ingestReportName)
+		if err = os.WriteFile(reportPath, jsonReport, 0644); err == nil {
+			fmt.Println("Report written to " + reportPath)
+		}
+	}
+	fmt.Println("IOTDB TEST QUERY: SHOW DEVICES " + database + ".*;")
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
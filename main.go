@@ -11,7 +11,7 @@ package main // netcdf inserts data into IotDB.
    Use Named Graphs (Identifier+Title+DatastreamName) as Publish/Subscribe topics?
    /usr/bin/ncdump -k cdf.nc			==> get file type {classic, netCDF-4, others...}
    /usr/bin/ncdump -c Jan_clean.nc		==> gives header + indexed {id, time} data
-   https://docs.unidata.ucar.edu/nug/current/index.html		Golang supports compressed file formats {rardecode(rar), gz/gzip, zlib, lzw, bzip2}
+   https://docs.unidata.ucar.edu/nug/current/index.html		Golang supports compressed file formats {rardecode(rar), gz/gzip, zlib, lzw, bzip2}; compressed.go reads gz, bzip2, zstd, zip and tar inputs
    Curated data: Each data row is indexed by {a house ID, a time value}. Programmatically import the data into GraphDB.
    nc files: ./github.com/go-native-netcdf/netcdf/*.nc	./github.com/netcdf-c/*.nc	./Documents/digital-twins/Entity/*.nc
    h5 files: ./Documents/digital-twins/AMP/AMPds2.h5	./github.com/netcdf-c/nc_test4/*.h5	./github.com/nci-doe-data-sharing/flaskProject/mt-cnn/mt_cnn_model.h5 ./github.com/go-native-netcdf/netcdf/hdf5/testdata
@@ -19,17 +19,16 @@ package main // netcdf inserts data into IotDB.
 */
 
 import (
-	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"filesystem" // work module
 	"flag"
 	"fmt"
 	"log"
-	"math"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -77,7 +76,7 @@ func checkErr(title string, err error) {
 
 // var xsdDatatypeMap = map[string]string{"string": "string", "int": "integer", "integer": "integer", "longint": "long", "int64": "long", "float": "decimal", "double": "decimal", "boolean": "byte", "datetime": "dateTime"} // map cdf to xsd datatypes.
 var NetcdfFileFormats = []string{"classic", "netCDF", "netCDF-4", "HDF5"}
-var rowsXsdMap = map[string]string{"dateTime": "datetime", "Unicode": "string", "unicode": "string", "Float": "float", "float": "float", "Integer": "integer", "integer": "integer", "Longint": "int64", "longint": "int64", "Double": "double", "double": "double"}
+var rowsXsdMap = map[string]string{"dateTime": "datetime", "Unicode": "string", "unicode": "string", "Float": "float", "float": "float", "Integer": "integer", "integer": "integer", "Longint": "int64", "longint": "int64", "Double": "double", "double": "double", "boolean": "boolean"}
 
 func getBlockSize(nMeasurements int) int {
 	if nMeasurements < 256 {
@@ -89,9 +88,13 @@ func getBlockSize(nMeasurements int) int {
 	return 8192
 }
 
+// The summary of an archive member is next to the archive when it is there (compressed.go).
 func GetSummaryFilename(dataFilePath string) string {
-	fileName := filepath.Base(dataFilePath)
-	return filepath.Dir(dataFilePath) + "/summary_" + fileName[:len(fileName)-len(filepath.Ext(fileName))] + csvExtension
+	summaryName := "summary_" + sourceBase(dataFilePath) + csvExtension
+	if archive, member := splitArchivePath(dataFilePath); len(member) > 0 && sourceExists(filepath.Join(filepath.Dir(archive), summaryName)) {
+		return filepath.Join(filepath.Dir(archive), summaryName)
+	}
+	return filepath.Dir(dataFilePath) + "/" + summaryName
 }
 
 // Contains an entire month's worth of Entity data for every Variable where each row is indexed by {HouseIndex+LongtimeIndex}.
@@ -134,6 +137,98 @@ func (itp IotdbTimeseriesProfile) Format_Timeseries(list []IotdbTimeseriesProfil
 	return output
 }
 
+// Tags and Attributes are returned by SHOW TIMESERIES as JSON objects: {"units":"°F"}
+func parseKeyValuePairs(jsonObject string) map[string]string {
+	pairs := make(map[string]string, 0)
+	if len(jsonObject) > 0 && jsonObject != "null" {
+		_ = json.Unmarshal([]byte(jsonObject), &pairs)
+	}
+	return pairs
+}
+
+// Reverse of getClientStorage() for time series created without a 'datatype' attribute.
+var iotdbXsdMap = map[string]string{"DOUBLE": "double", "FLOAT": "float", "INT32": "integer", "INT64": "int64", "BOOLEAN": "boolean", "TEXT": "string"}
+
+// Rebuild the MeasurementItem that created this time series from its ATTRIBUTES('datatype', 'name', 'description') and TAGS('units').
+func (itp IotdbTimeseriesProfile) ToMeasurementItem(columnOrder int) MeasurementItem {
+	_, measurementAlias := splitIotdbPath(itp.Timeseries)
+	attributes := parseKeyValuePairs(itp.Attributes)
+	mi := MeasurementItem{
+		MeasurementName:  attributes["name"],
+		MeasurementAlias: measurementAlias,
+		MeasurementType:  attributes["datatype"],
+		MeasurementUnits: parseKeyValuePairs(itp.Tags)[unitsName],
+		ColumnOrder:      columnOrder,
+	}
+	mi.MeasurementDescription = attributes["description"]
+	if len(mi.MeasurementName) == 0 { // created before the 'name' attribute was recorded.
+		mi.MeasurementName = measurementAlias
+	}
+	if len(mi.MeasurementType) == 0 {
+		mi.MeasurementType = iotdbXsdMap[itp.DataType]
+	}
+	return mi
+}
+
+// Return one profile per time series matching pattern: SHOW TIMESERIES root.ecobee.household.**
+func GetTimeseriesProfiles(session *client.Session, pattern string) ([]IotdbTimeseriesProfile, error) {
+	timeout := tailQueryTimeout
+	sds, err := session.ExecuteQueryStatement("SHOW TIMESERIES "+pattern, &timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer sds.Close()
+	profiles := make([]IotdbTimeseriesProfile, 0)
+	for next, err := sds.Next(); next; next, err = sds.Next() {
+		if err != nil {
+			return profiles, err
+		}
+		profiles = append(profiles, IotdbTimeseriesProfile{
+			Timeseries:         sds.GetText("Timeseries"),
+			Alias:              sds.GetText("Alias"),
+			Database:           sds.GetText("Database"),
+			DataType:           sds.GetText("DataType"),
+			Encoding:           sds.GetText("Encoding"),
+			Compression:        sds.GetText("Compression"),
+			Tags:               sds.GetText("Tags"),
+			Attributes:         sds.GetText("Attributes"),
+			Deadband:           sds.GetText("Deadband"),
+			DeadbandParameters: sds.GetText("DeadbandParameters"),
+		})
+	}
+	return profiles, nil
+}
+
+// IotdbDevice is one row of SHOW DEVICES; each device holds the aligned time series of one dataset.
+type IotdbDevice struct {
+	Device   string `json:"device"`
+	Database string `json:"database"`
+	Aligned  bool   `json:"aligned"`
+}
+
+// Return the devices matching pattern sorted by path: SHOW DEVICES root.ecobee.household.* WITH DATABASE
+func ShowDevices(session *client.Session, pattern string) ([]IotdbDevice, error) {
+	timeout := tailQueryTimeout
+	sds, err := session.ExecuteQueryStatement("SHOW DEVICES "+pattern+" WITH DATABASE", &timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer sds.Close()
+	devices := make([]IotdbDevice, 0)
+	for next, err := sds.Next(); next; next, err = sds.Next() {
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, IotdbDevice{
+			Device:   sds.GetText("Device"),
+			Database: sds.GetText("Database"),
+			Aligned:  strings.EqualFold(sds.GetText("IsAligned"), "true"),
+		})
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Device < devices[j].Device })
+	return devices, nil
+}
+
 func GetTimeseriesCommands(programArgs []string) []string {
 	timeseriesCommands := make([]string, 0)
 	for ndx := range programArgs {
@@ -152,12 +247,13 @@ func IotDatasetPrefix(identifier, device string) string {
 
 // mapped to original column name
 type MeasurementItem struct {
-	MeasurementName  string `json:"measurementname"`  // Original name
-	MeasurementAlias string `json:"measurementalias"` // Names that fit IotDB format; see MeasurementName() => [0-9 a-z A-Z _ ]
-	MeasurementType  string `json:"measurementtype"`  // XSD data type
-	MeasurementUnits string `json:"measurementunits"`
-	ColumnOrder      int    `json:"columnorder"` // Column order from data file
-	Ignore           bool   `json:"ignore"`      // in case there is no data in the file
+	MeasurementName        string `json:"measurementname"`  // Original name
+	MeasurementAlias       string `json:"measurementalias"` // Names that fit IotDB format; see MeasurementName() => [0-9 a-z A-Z _ ]
+	MeasurementType        string `json:"measurementtype"`  // XSD data type
+	MeasurementUnits       string `json:"measurementunits"`
+	ColumnOrder            int    `json:"columnorder"`                      // Column order from data file
+	Ignore                 bool   `json:"ignore"`                           // in case there is no data in the file
+	MeasurementDescription string `json:"measurementdescription,omitempty"` // from a data package (datapackage.go)
 }
 
 func (mi MeasurementItem) ToString() string {
@@ -183,8 +279,55 @@ type IoTDbAccess struct {
 	session            client.Session
 	Sql                string   `json:"sql"`
 	ActiveSession      bool     `json:"activesession"`
+	DryRun             bool     `json:"dryrun"`             // print the statements instead of executing them
 	TimeseriesCommands []string `json:"timeseriescommands"` // given as command-line parameters
 	QueryResults       []string `json:"queryresults"`
+	Quiet              bool     `json:"-"` // progress is reported by the caller (ingestdir.go)
+}
+
+// Print the progress of ProcessTimeseries unless the caller reports it.
+func (access *IoTDbAccess) progress(a ...any) {
+	if !access.Quiet {
+		fmt.Println(a...)
+	}
+}
+
+func (access *IoTDbAccess) progressf(format string, a ...any) {
+	if !access.Quiet {
+		fmt.Printf(format, a...)
+	}
+}
+
+// Longest statement printed whole by a dry run; insert blocks are abbreviated.
+const dryRunPreview = 512
+
+// Execute sql, or print it during a dry run.
+func (access *IoTDbAccess) executeNonQuery(sql string) error {
+	if access.DryRun {
+		if len(sql) > dryRunPreview {
+			sql = sql[:dryRunPreview] + " ... (" + strconv.Itoa(len(sql)) + " characters)"
+		}
+		fmt.Println("DRY RUN: " + sql)
+		return nil
+	}
+	_, err := access.session.ExecuteNonQueryStatement(sql) // (r *common.TSStatus, err error)
+	return err
+}
+
+func (access *IoTDbAccess) executeBatch(statements []string) error {
+	if access.DryRun {
+		for _, sql := range statements {
+			access.executeNonQuery(sql)
+		}
+		return nil
+	}
+	_, err := access.session.ExecuteBatchStatement(statements)
+	return err
+}
+
+// dryrun=true, given by netcdf ingest --dry-run
+func isDryRun(programArgs []string) bool {
+	return getProgramParameters(programArgs, map[string]string{"dryrun": "false"})["dryrun"] == "true"
 }
 
 // Generic NetCDF container. Not stored in IotDB.
@@ -207,10 +350,11 @@ type NetCDF struct {
 	HouseIndices        []string                        `json:"houseindices"`    // these unique 2 indices are specific to Ecobee datasets.
 	LongtimeIndices     []string                        `json:"longtimeindices"` // Different months will have slightly different HouseIndices!
 	// these are not in the source file.
-	DataFilePath string     `json:"datafilepath"`
-	DatasetName  string     `json:"datasetname"`
-	Summary      [][]string `json:"summary"` // from summary file
-	Dataset      [][]string `json:"dataset"` // actual data
+	DataFilePath string                      `json:"datafilepath"`
+	DatasetName  string                      `json:"datasetname"`
+	Summary      [][]string                  `json:"summary"` // from summary file
+	Dataset      [][]string                  `json:"dataset"` // actual data
+	TimeParser   *filesystem.TimestampParser `json:"-"`       // from the timelayout, timezone, ambiguous and epoch parameters
 }
 
 func (cdf NetCDF) ToString(outputVariables bool) string {
@@ -276,16 +420,7 @@ func (cdf NetCDF) Format_Json() ([]string, error) {
 
 // Return list of dataset column names.
 func (cdf NetCDF) FormattedColumnNames() string {
-	var sb strings.Builder
-	for ndx := 0; ndx < len(cdf.Measurements); ndx++ {
-		for _, v := range cdf.Measurements {
-			if v.ColumnOrder == ndx && !v.Ignore {
-				sb.WriteString(v.MeasurementAlias + ",")
-			}
-		}
-	}
-	str := sb.String()[0:len(sb.String())-1] + " "
-	return str
+	return formattedColumnNames(cdf.Schema())
 }
 
 // accept either original or alias names.
@@ -305,42 +440,22 @@ func (cdf NetCDF) GetMeasurementVariableFromName(name string) (MeasurementVariab
 
 // Return all column values except header row. Return false if column name not found. Fill in default values.
 func (cdf NetCDF) GetSummaryStatValues(columnName string) ([]string, bool) {
-	_, columnIndex := find(summaryColumnNames, columnName)
-	if columnIndex < 0 {
-		return []string{}, false
-	}
-	stats := make([]string, len(cdf.Measurements))
-	for ndx := 0; ndx < len(cdf.Measurements)-1; ndx++ {
-		_, aliasName := StandardName(cdf.Summary[ndx+1][0])
-		item, _ := cdf.GetMeasurementVariableFromName(aliasName) // MeasurementVariable
-		stats[ndx] = strings.TrimSpace(cdf.Summary[ndx+1][columnIndex])
-		if strings.HasPrefix(strings.ToLower(item.MeasurementType), "int") || strings.HasPrefix(strings.ToLower(item.MeasurementType), "long") {
-			index := strings.Index(stats[ndx], ".")
-			if index >= 0 {
-				stats[ndx] = stats[ndx][0:index]
-			}
-		}
-		if len(stats[ndx]) == 0 {
-			stats[ndx] = strings.TrimSpace(cdf.Summary[ndx+1][1]) // this type is nearly always Unicode
-		}
-	}
-	return stats, true
+	return summaryStatValues(cdf.Summary, cdf.items(), columnName)
 }
 
 // Expects comma-separated files. Assigns Dataset or Summary.
 func (cdf *NetCDF) ReadCsvFile(filePath string, isDataset bool) error {
-	f, err := os.Open(filePath)
-	checkErr("Unable to read csv file: ", err)
-	defer f.Close()
-	fmt.Println("Reading " + filePath)
-	csvReader := csv.NewReader(f)
+	cdf.progress("Reading " + filePath)
+	records, err := readCsvRecords(filePath, false)
+	if err != nil {
+		return errors.New("Unable to parse file as CSV for " + filePath + ": " + err.Error())
+	}
 	if !isDataset {
-		cdf.Summary, err = csvReader.ReadAll()
+		cdf.Summary = records
 	} else {
-		cdf.Dataset, err = csvReader.ReadAll()
+		cdf.Dataset = records
 	}
-	checkErr("Unable to parse file as CSV for "+filePath, err)
-	return err
+	return nil
 }
 
 // Index each dimension
@@ -356,13 +471,7 @@ func (cdf *NetCDF) getDimensionMap() map[string]int {
 
 // Return string-formatted value from: columnName={summaryColumnNames}, fieldName={measurement names}
 func (cdf *NetCDF) GetSummaryValue(columnName, fieldName string) string {
-	columnNames, found1 := cdf.GetSummaryStatValues(summaryColumnNames[0])
-	columnValues, found2 := cdf.GetSummaryStatValues(columnName)
-	_, index := find(columnNames, fieldName) // exact match
-	if !found1 || !found2 || index < 0 {
-		return ""
-	}
-	return columnValues[index]
+	return summaryValue(cdf.Summary, cdf.items(), columnName, fieldName)
 }
 
 // search for either original or alias name
@@ -380,157 +489,111 @@ func (cdf *NetCDF) GetMeasurementItemFromName(name string) (*MeasurementVariable
 }
 
 func (cdf *NetCDF) GetColumnNumberFromName(columnName string) int {
-	for ndx := 0; ndx < len(cdf.Summary[0]); ndx++ { // iterate over summary header row
-		if strings.EqualFold(cdf.Summary[0][ndx], columnName) {
-			return ndx
-		}
-	}
-	return -1
+	return summaryColumnNumber(cdf.Summary, columnName)
 }
 
 // test Summary{sum, min, max} columns.
 func isEmptyDataColumn(summary []string) bool {
-	empty := summary[2] == "0" && summary[3] == "0" && summary[4] == "0"
+	empty := len(summary) > 4 && summary[2] == "0" && summary[3] == "0" && summary[4] == "0"
 	return empty
 }
 
 // Expects {Units, DatasetName} fields to have been appended to the summary file. Assign []Measurements. Expects Summary to be assigned. Use XSD data types.
-// len() only returns the length of the "external" array.
+// The items are those of an IoTDbCsvDataFile (sensorsource.go) with the dimension index of the *.var file.
 func (cdf *NetCDF) XsvSummaryTypeMap() {
 	cdf.Measurements = make(map[string]*MeasurementVariable, 0)
-	// get units column
-	unitsColumn := cdf.GetColumnNumberFromName(unitsName)
-	ndx1 := 0
 	dimMap := cdf.getDimensionMap()
-	for ndx := 0; ndx < maxColumns; ndx++ { // iterate over summary file rows.
-		dataColumnName, aliasName := StandardName(cdf.Summary[ndx+1][0]) // dataColumnName is normalized for IotDB naming conventions.
-		ignore := isEmptyDataColumn(cdf.Summary[ndx+1]) || dataColumnName == interpolated 
-		if ignore {
-			fmt.Println("Ignoring empty data column " + dataColumnName)
-		}
-		endOfMeasurements := cdf.Summary[ndx+1][0] == interpolated || strings.TrimSpace(cdf.Summary[ndx+1][0]) == endOfFields
-		if endOfMeasurements {
-			cdf.Identifier = cdf.Summary[ndx+1][1]
-			ndx1 = ndx
-			break
-		}
-
-		mi := MeasurementItem{
-			MeasurementName:  dataColumnName,
-			MeasurementAlias: aliasName,
-			MeasurementType:  rowsXsdMap[cdf.Summary[ndx+1][1]],
-			MeasurementUnits: cdf.Summary[ndx+1][unitsColumn],
-			ColumnOrder:      ndx,
-			Ignore:           ignore,
-		}
-		dimIndex, _ := dimMap[dataColumnName]
+	identifier, items := summaryMeasurements(cdf.Summary)
+	cdf.Identifier = identifier
+	for _, item := range items {
+		dimIndex, _ := dimMap[item.MeasurementAlias]
 		mv := MeasurementVariable{
-			MeasurementItem: mi,
+			MeasurementItem: *item,
 			DimensionIndex:  dimIndex,
 			FillValue:       "0. ", // or ""
 			Comment:         "",
 			Calendar:        "",
 		}
-		cdf.Measurements[dataColumnName] = &mv // add to map using original name
-	}
-	// add DatasetName timerseries in case data column names are the same for different sampling intervals.
-	mi := MeasurementItem{
-		MeasurementName:  LastColumnName,
-		MeasurementAlias: LastColumnName,
-		MeasurementType:  "string",
-		MeasurementUnits: "unitless",
-		ColumnOrder:      ndx1,
-		Ignore:           false,
-	}
-	dimIndex, _ := dimMap[LastColumnName]
-	mv := MeasurementVariable{
-		MeasurementItem: mi,
-		DimensionIndex:  dimIndex,
-		FillValue:       "0. ", // or ""
-		Comment:         "",
-		Calendar:        "",
+		cdf.Measurements[item.MeasurementAlias] = &mv // add to map using IotDB name
 	}
-	cdf.Measurements[LastColumnName] = &mv
 }
 
 // Change tiny values to 0, not null.
 func (cdf *NetCDF) NormalizeValues() {
-	const minimum = 10e-10
-	changed := 0
-	for ndx1 := 0; ndx1 < len(cdf.Dataset[0]); ndx1++ { // number of columns
-		for ndx2 := 1; ndx2 < len(cdf.Dataset); ndx2++ { // number of rows
-			fval, err := strconv.ParseFloat(cdf.Dataset[ndx2][ndx1], 64)
-			if err == nil && math.Abs(fval) < minimum {
-				cdf.Dataset[ndx2][ndx1] = "0"
-				changed++
-			}
-		}
-	}
-	if changed > 0 {
-		fmt.Print(changed)
-		fmt.Print(" absolute tiny values changed to 0 [< ")
-		fmt.Print(minimum)
-		fmt.Println("]")
+	normalizeValues(cdf.Dataset, nil)
+}
+
+// Every measurement, ignored ones included, in column order.
+func (cdf *NetCDF) items() []*MeasurementItem {
+	items := make([]*MeasurementItem, 0, len(cdf.Measurements))
+	for _, mv := range cdf.Measurements {
+		items = append(items, &mv.MeasurementItem)
 	}
+	return orderedMeasurements(items, true)
+}
+
+// SensorSource (sensorsource.go)
+func (cdf *NetCDF) Access() *IoTDbAccess { return &cdf.IoTDbAccess }
+func (cdf *NetCDF) Database() string     { return cdf.Identifier }
+func (cdf *NetCDF) Name() string         { return cdf.DatasetName }
+func (cdf *NetCDF) Devices() []string    { return cdf.HouseIndices }
+
+func (cdf *NetCDF) Schema() []*MeasurementItem {
+	return orderedMeasurements(cdf.items(), false)
+}
+
+func (cdf *NetCDF) Attributes(item *MeasurementItem) string {
+	return descriptionAttribute(item)
 }
 
 // If there are no values at all in the block, IoTDB does not write the measurement, so get mismatch between number of INSERT field names and VALUES (e.g., HeatingEquipmentStage3_RunTime)
-// Ids are consecutive: data rows = 8838720; 990 distinct id values; ==> 8928 rows per id-device
-func (cdf *NetCDF) CopyCsvTimeseriesDataIntoIotDB() error {
-	fileToRead := cdf.DataFilePath + "/csv/" + cdf.DatasetName + csvExtension
-	err := cdf.ReadCsvFile(fileToRead, true) // isDataset: yes
+// Ids are consecutive: data rows = 8838720; 990 distinct id values; ==> 8928 rows per id-device. Reads the csv/ conversion of the *.nc file.
+func (cdf *NetCDF) Batches(blockSize int, write func(device string, batch RowBatch) error) error {
+	err := cdf.ReadCsvFile(cdf.DataFilePath+"/csv/"+cdf.DatasetName+csvExtension, true) // isDataset: yes
+	if err != nil {
+		return err
+	}
 	cdf.NormalizeValues()
-	checkErr("cdf.ReadCsvFile ", err)
-	nBlocks := cdf.Dimensions["id"]     //=990	 len(cdf.Dataset)/(blockSize) + 1
-	blockSize := cdf.Dimensions["time"] // scope override
+	schema := cdf.Schema()
+	rowsPerDevice := cdf.Dimensions["time"]
 	timeIndex := 1
-	fmt.Printf("%s%d%s", "Writing ", nBlocks, " blocks: ")
-	for block := 0; block < nBlocks; block++ {
-		fmt.Print(block + 1)
-		fmt.Print(" ")
-		var sb strings.Builder
-		var insert strings.Builder
-		insert.WriteString("INSERT INTO " + IotDatasetPrefix(cdf.Identifier, cdf.HouseIndices[block]) + " (time," + cdf.FormattedColumnNames() + ") ALIGNED VALUES ")
-		startRow := blockSize*block + 1
-		endRow := startRow + blockSize
-		if block == nBlocks-1 {
+	for block := 0; block < len(cdf.HouseIndices) && rowsPerDevice > 0; block++ {
+		startRow := rowsPerDevice*block + 1
+		endRow := min(startRow+rowsPerDevice, len(cdf.Dataset))
+		if block == len(cdf.HouseIndices)-1 {
 			endRow = len(cdf.Dataset)
 		}
+		batch := RowBatch{}
 		for r := startRow; r < endRow; r++ {
-			sb.Reset()
-			startTime, err := filesystem.GetStartTimeFromLongint(cdf.Dataset[r][timeIndex])
+			startTime, err := cdf.TimeParser.Parse(cdf.Dataset[r][timeIndex])
 			if err != nil {
 				fmt.Println("Appears to be a bad time: " + cdf.Dataset[r][timeIndex])
-				break
+				continue
 			}
-			sb.WriteString("(" + strconv.FormatInt(startTime.UTC().Unix()*1000, 10) + ",")
-			for c := 0; c < len(cdf.Dataset[r]); c++ {
-				for _, item := range cdf.Measurements {
-					if item.ColumnOrder == c && !item.Ignore {
-						sb.WriteString(formatDataItem(cdf.Dataset[r][c], item.MeasurementItem.MeasurementType) + ",")
-					} 
-
+			batch.Timestamps = append(batch.Timestamps, iotdbTimestamp(startTime))
+			batch.Values = append(batch.Values, schemaValues(cdf.Dataset[r], schema, cdf.DatasetName))
+			if len(batch.Timestamps) == blockSize {
+				if err := write(cdf.HouseIndices[block], batch); err != nil {
+					return err
 				}
+				batch = RowBatch{}
 			}
-			sb.WriteString(formatDataItem(cdf.DatasetName, "string") + ")")
-			if r < endRow-1 {
-				sb.WriteString(",")
-			}
-			insert.WriteString(sb.String())
 		}
-		_, err := cdf.IoTDbAccess.session.ExecuteNonQueryStatement(insert.String() + ";") // (r *common.TSStatus, err error)
-		checkErr("ExecuteNonQueryStatement(insertStatement)", err)
+		if err := write(cdf.HouseIndices[block], batch); err != nil {
+			return err
+		}
 	}
-	fmt.Println()
-	return err
+	return nil
 }
 
-// Assume time series have been created; erase existing data; insert data. Assigns cdf.Dataset. Converted *.nc files to CSV files. See CopyCSVTimeseriesDataIntoIotDB().
+// Assume time series have been created; erase existing data; insert data. Assigns cdf.Dataset. Converted *.nc files to CSV files. See (*NetCDF).Batches().
 // mapNetcdfGolangTypes: "byte": "int8", "ubyte": "uint8", "char": "string", "short": "int16", "ushort": "uint16", "int": "int32", "uint": "uint32", "int64": "int64", "uint64": "uint64", "float": "float32", "double": "float64"
 func (cdf *NetCDF) CopyNcTimeseriesDataIntoIotDB() error {
 	iotPrefix := IotDatasetPrefix(cdf.Identifier, "{device-id}")
 	const createMsg string = " time series not found -- run this program with the `createts` parameter first " // also get this if no data in column
-	fileToRead := cdf.DataFilePath + "/" + cdf.DatasetName + ncExtension
+	fileToRead, cleanup, err := localSource(cdf.DataFilePath + "/" + cdf.DatasetName + ncExtension) // may be compressed
+	checkErr("Could not access "+cdf.DataFilePath+"/"+cdf.DatasetName+ncExtension, err)
+	defer cleanup()
 	nc, err := netcdf.OpenFile(fileToRead, netcdf.NOWRITE)
 	if err != nil {
 		checkErr("Could not access "+fileToRead, err)
@@ -590,81 +653,9 @@ func (cdf *NetCDF) CopyNcTimeseriesDataIntoIotDB() error {
 	return nil
 }
 
-// Not the same as the iot version.
+// Creates, drops, deletes and inserts every HouseIndices device like any other SensorSource.
 func (cdf *NetCDF) ProcessTimeseries() error {
-	if cdf.IoTDbAccess.ActiveSession {
-		cdf.IoTDbAccess.session = client.NewSession(clientConfig)
-		if err := cdf.IoTDbAccess.session.Open(false, 0); err != nil {
-			checkErr("ProcessTimeseries(cdf.IoTDbAccess.session.Open): ", err)
-		}
-		defer cdf.IoTDbAccess.session.Close()
-	}
-	fmt.Println("Processing time series for NC dataset " + cdf.DatasetName + " ...")
-
-	for _, command := range cdf.TimeseriesCommands {
-		switch command {
-		case "createdb":
-			sql := "CREATE DATABASE " + cdf.Identifier
-			_, err := cdf.IoTDbAccess.session.ExecuteNonQueryStatement(sql)
-			checkErr("ExecuteNonQueryStatement(createDBstatement)", err)
-			fmt.Println(sql)
-
-		case "dropts": // time series schema; uses single statement; REFACTOR: this drops all timeseries, but can be changed to drop individual timeseries.
-			for id := 0; id < len(cdf.HouseIndices); id++ {
-				sql := "DROP TIMESERIES " + IotDatasetPrefix(cdf.Identifier, cdf.HouseIndices[id]) + "*"
-				_, err := cdf.IoTDbAccess.session.ExecuteNonQueryStatement(sql)
-				checkErr("ExecuteNonQueryStatement(dropStatement)", err)
-			}
-			for k := range cdf.Measurements { 
-				delete(cdf.Measurements, k)
-			}
-
-		case "createts":
-			// Setting an alias, tag, and attribute for an aligned timeseries is supported as of Nov. 3, 2023 (v1.2.2).
-			// CREATE timeseries root.turbine.d1.s1(temprature) WITH datatype = FLOAT, encoding = RLE, compression = SNAPPY, 'max_point_number' = '5' TAGS('tag1' = 'v1', 'tag2'= 'v2') ATTRIBUTES('attr1' = 'v1', 'attr2' = 'v2')
-			// Note: For a group of aligned timeseries, Iotdb does not support different compressions.
-			// https://iotdb.apache.org/UserGuide/V1.0.x/Reference/SQL-Reference.html#schema-statement
-			var sb strings.Builder
-			var sql string
-			// Use each id as a 'device'; read from var file; are unique.
-			for id := 0; id < len(cdf.HouseIndices); id++ {
-				sb.Reset()
-				sb.WriteString("CREATE ALIGNED TIMESERIES " + IotDatasetPrefix(cdf.Identifier, cdf.HouseIndices[id]) + "(")
-				for ndx := 0; ndx < len(cdf.Measurements); ndx++ {
-					for _, v := range cdf.Measurements {
-						if v.ColumnOrder == ndx && !v.Ignore {
-							dataType, encoding, compressor := getClientStorage(v.MeasurementItem.MeasurementType)
-							attributes := " ATTRIBUTES('datatype'='" + v.MeasurementType  + "') TAGS('units'='" + v.MeasurementUnits + "')"
-							sb.WriteString(v.MeasurementAlias + " " + dataType + " encoding=" + encoding + " compressor=" + compressor + attributes + ",") 
-						}
-					}
-				}
-				sql = sb.String()[0:len(sb.String())-1] + ");" // replace trailing comma
-				_, err := cdf.IoTDbAccess.session.ExecuteNonQueryStatement(sql)
-				checkErr("ExecuteNonQueryStatement(createStatement)", err)
-			}
-			fmt.Println("IOTDB TEST QUERY: show timeseries " + cdf.Identifier + ".**;")
-
-		case "delete": // remove all data; retain schema; multiple commands.
-			for id := 0; id < len(cdf.HouseIndices); id++ {
-				deleteStatements := make([]string, 0)
-				for _, item := range cdf.Measurements {
-					deleteStatements = append(deleteStatements, "DELETE FROM "+IotDatasetPrefix(cdf.DatasetName, cdf.HouseIndices[id])+item.MeasurementName+";")
-				}
-				_, err := cdf.IoTDbAccess.session.ExecuteBatchStatement(deleteStatements) // (r *common.TSStatus, err error)
-				checkErr("ExecuteBatchStatement(deleteStatements)", err)
-			}
-
-		case "insert": // insert(append) data; retain schema; either single or multiple statements;
-			// Automatically inserts long time column as first column (which should be UTC). Save in blocks.
-			err := cdf.CopyCsvTimeseriesDataIntoIotDB()
-			checkErr("ExecuteNonQueryStatement(insertStatements)", err)
-			fmt.Println("IOTDB TEST QUERY: SELECT COUNT(*) FROM " + cdf.Identifier + ".*;")
-		}
-		fmt.Println("Timeseries <" + command + "> completed.")
-	} // for
-
-	return nil
+	return ProcessTimeseries(cdf)
 }
 
 // Return NetCDF struct by parsing Jan_clean.var file that is output from /usr/bin/ncdump -c Jan_clean.nc. Var files are specific to *.nc datasets.
@@ -675,6 +666,7 @@ func ParseVariableFile(varFile, filetype, dataSetIdentifier, description string,
 	ioTDbAccess := IoTDbAccess{ActiveSession: isActive}
 	xcdf := NetCDF{IoTDbAccess: ioTDbAccess, Description: description, DataFilePath: datasetPathName, DatasetName: dataSetIdentifier, NetcdfType: filetype}
 	xcdf.TimeseriesCommands = GetTimeseriesCommands(programArgs)
+	xcdf.DryRun = isDryRun(programArgs)
 	lineIndex := 0
 	tokens := strings.Split(lines[lineIndex], " ")
 	xcdf.Identifier = tokens[1] // override
@@ -974,7 +966,7 @@ func GetOutputPath(filePath, ext string) string {
 }
 
 func CreateIotSession(programArgs []string) bool {
-	createIotSession := !(len(programArgs) == 3 && programArgs[2] == timeSeriesCommands[0])
+	createIotSession := !(len(programArgs) == 3 && programArgs[2] == timeSeriesCommands[0]) && !isDryRun(programArgs)
 	if createIotSession {
 		iotdbConnection, ok := Init_IoTDB(createIotSession)
 		if !ok {
@@ -984,8 +976,26 @@ func CreateIotSession(programArgs []string) bool {
 	return createIotSession
 }
 
+// Optional time parameters of a data file: timelayout=<Go layout>[|<layout>...] timezone=<IANA zone of wall-clock times> ambiguous=earlier|later|error
+// epoch=auto|s|ms|us|ns (unit of epoch integers; auto reads it from the magnitude)
+// e.g. timelayout="02.01.2006 15:04" timezone=Europe/Berlin ambiguous=earlier
+var timeParameters = map[string]string{"timelayout": "", "timezone": "", "ambiguous": "", "epoch": ""}
+
+func NewDatasetTimeParser(programArgs []string) (*filesystem.TimestampParser, error) {
+	parameters := getProgramParameters(programArgs, timeParameters)
+	layouts := make([]string, 0)
+	if len(parameters["timelayout"]) > 0 {
+		layouts = strings.Split(parameters["timelayout"], "|")
+	}
+	return filesystem.NewTimestampParser(layouts, parameters["timezone"], parameters["ambiguous"], parameters["epoch"])
+}
+
 // Produce *.var file using: /usr/bin/ncdump -k cdf.nc  &&  /usr/bin/ncdump -c Jan_clean.nc
 func ProcessCsvSensorData(programArgs []string) {
+	if isIngestSet(programArgs) { // a folder, glob or several data files
+		ProcessCsvFiles(programArgs)
+		return
+	}
 	createIotSession := CreateIotSession(programArgs)
 	iotdbDataFile, err := Initialize_IoTDbCsvDataFile(createIotSession, programArgs)
 	checkErr("Initialize_IoTDbCsvDataFile: ", err)
@@ -994,13 +1004,11 @@ func ProcessCsvSensorData(programArgs []string) {
 }
 
 func isAccessibleSensorDataFile(dataFilePath string) error {
-	exists, err := filesystem.FileExists(dataFilePath)
-	if !exists {
-		return err
+	if !sourceExists(dataFilePath) { // also compressed and archived files
+		return errors.New("Sensor data file not found: " + dataFilePath)
 	}
-	checkErr("Sensor data file not readable: "+dataFilePath, err)
 	var goodFileTypes = map[string]string{".nc": "ok", ".csv": "ok", ".hd5": "ok"}
-	dataFileType := strings.ToLower(path.Ext(dataFilePath))
+	dataFileType := sourceExt(dataFilePath)
 	_, ok := goodFileTypes[dataFileType]
 	if !ok {
 		return errors.New("Cannot process source file type: " + dataFilePath)
@@ -1010,8 +1018,8 @@ func isAccessibleSensorDataFile(dataFilePath string) error {
 
 func Initialize_IoTDbNcDataFile(isActive bool, programArgs []string) (NetCDF, error) {
 	fileType := programArgs[2]                      // subtype of *.nc file.
-	outputPath := GetOutputPath(programArgs[1], "") // path has no extension
-	datasetName := path.Base(outputPath)            // Jan_clean
+	outputPath := filepath.Join(sourceFolder(programArgs[1]), sourceBase(programArgs[1])) // path has no extension; the *.var file is next to a compressed file or archive
+	datasetName := path.Base(outputPath)                                                // Jan_clean
 	isAccessibleSensorDataFile(programArgs[1])
 	xcdf, err := ParseVariableFile(outputPath+varExtension, fileType, datasetName, datasetName, programArgs, isActive)
 	checkErr("ParseVariableFile", err)
@@ -1019,6 +1027,8 @@ func Initialize_IoTDbNcDataFile(isActive bool, programArgs []string) (NetCDF, er
 	//fmt.Println(xcdf.ToString(true)) // true => output variables
 	checkErr("ReadCsvFile ", err)
 	xcdf.TimeMeasurementName = programArgs[3]
+	xcdf.TimeParser, err = NewDatasetTimeParser(programArgs)
+	checkErr("NewDatasetTimeParser", err)
 	xcdf.XsvSummaryTypeMap()
 	return xcdf, nil
 }
@@ -1144,6 +1154,17 @@ func Init_IoTDB(testIotdbAccess bool) (string, bool) {
 	return connectStr, true
 }
 
+// Sessions are not goroutine safe; servers take one from the pool per request. Assumes clientConfig is assigned.
+func NewIotdbSessionPool(maxSize int) client.SessionPool {
+	poolConfig := &client.PoolConfig{
+		Host:     clientConfig.Host,
+		Port:     clientConfig.Port,
+		UserName: clientConfig.UserName,
+		Password: clientConfig.Password,
+	}
+	return client.NewSessionPool(poolConfig, maxSize, 60000, 60000, false)
+}
+
 ///////////////////////////////////////////////////////////////////////////////////////////
 
 type IoTDbCsvDataFile struct {
@@ -1155,21 +1176,28 @@ type IoTDbCsvDataFile struct {
 	DatasetName         string                      `json:"datasetname"`
 	TimeMeasurementName string                      `json:"timemeasurementname"` // index into Measurements map;
 	Measurements        map[string]*MeasurementItem `json:"measurements"`
-	Summary             [][]string                  `json:"summary"` // from summary file
-	Dataset             [][]string                  `json:"dataset"` // actual data
+	Summary             [][]string                  `json:"summary"`                 // from summary file
+	Dataset             [][]string                  `json:"dataset"`                 // actual data
+	TimeParser          *filesystem.TimestampParser `json:"-"`                       // from the timelayout, timezone, ambiguous and epoch parameters
+	MissingValues       []string                    `json:"missingvalues,omitempty"` // tokens inserted as null (datapackage.go)
+	Quality             QualityReport               `json:"-"`                       // of the last insert
+	Adapted             map[string]string           `json:"-"`                       // ATTRIBUTES of the columns changed by a dataset adapter (toniot.go)
 }
 
 // expect only 1 instance of 'datasetName' in iotdbDataFile.DataFilePath.
 func Initialize_IoTDbCsvDataFile(isActive bool, programArgs []string) (IoTDbCsvDataFile, error) {
-	datasetName := filepath.Base(programArgs[1]) // includes extension
-	datasetName = strings.Replace(datasetName, csvExtension, "", 1)
+	datasetName := sourceBase(programArgs[1]) // without extension, also of Jan.csv.gz
 	isAccessibleSensorDataFile(programArgs[1])
 	ioTDbAccess := IoTDbAccess{ActiveSession: isActive}
 	timeMeasurementName := programArgs[2]
 	iotdbDataFile := IoTDbCsvDataFile{IoTDbAccess: ioTDbAccess, Description: datasetName, DataFilePath: programArgs[1], DatasetName: datasetName, TimeMeasurementName: timeMeasurementName}
 	iotdbDataFile.TimeseriesCommands = GetTimeseriesCommands(programArgs)
-	err := iotdbDataFile.ReadCsvFile(GetSummaryFilename(iotdbDataFile.DataFilePath), false) // isDataset: no, is summary  REFACTOR: read from GraphDB?
-	checkErr("ReadCsvFile ", err)
+	iotdbDataFile.DryRun = isDryRun(programArgs)
+	timeParser, err := NewDatasetTimeParser(programArgs)
+	checkErr("NewDatasetTimeParser", err)
+	iotdbDataFile.TimeParser = timeParser
+	iotdbDataFile.Summary, iotdbDataFile.MissingValues, err = ReadDataFileSummary(iotdbDataFile.DataFilePath, programArgs) // summary file and/or datapackage.json  REFACTOR: read from GraphDB?
+	checkErr("ReadDataFileSummary ", err)
 	iotdbDataFile.XsvSummaryTypeMap()
 	_, readDataFile := find(programArgs, "insert")
 	if readDataFile > 1 {
@@ -1177,28 +1205,15 @@ func Initialize_IoTDbCsvDataFile(isActive bool, programArgs []string) (IoTDbCsvD
 		checkErr("ReadCsvFile ", err)
 		iotdbDataFile.NormalizeValues()
 	}
+	err = iotdbDataFile.ApplyAdapter(programArgs) // raw ToN_IoT files
+	checkErr("ApplyAdapter ", err)
 	return iotdbDataFile, nil
 }
 
 // Change tiny values to 0, not null.
+// Missing value tokens of a data package become empty values, which are nulls.
 func (iot *IoTDbCsvDataFile) NormalizeValues() {
-	const minimum = 10e-10
-	changed := 0
-	for ndx1 := 0; ndx1 < len(iot.Dataset[0]); ndx1++ { // number of columns
-		for ndx2 := 1; ndx2 < len(iot.Dataset); ndx2++ { // number of rows
-			fval, err := strconv.ParseFloat(iot.Dataset[ndx2][ndx1], 64)
-			if err == nil && math.Abs(fval) < minimum {
-				iot.Dataset[ndx2][ndx1] = "0"
-				changed++
-			}
-		}
-	}
-	if changed > 0 {
-		fmt.Print(changed)
-		fmt.Print(" absolute tiny values changed to 0 [< ")
-		fmt.Print(minimum)
-		fmt.Println("]")
-	}
+	normalizeValues(iot.Dataset, iot.MissingValues)
 }
 
 // search for either original or alias name
@@ -1218,129 +1233,47 @@ func (iot *IoTDbCsvDataFile) GetMeasurementItemFromName(name string) (*Measureme
 // Return all column values except header row. Return false if column name not found. Fill in default values.
 // columnName={summaryColumnNames}
 func (iot *IoTDbCsvDataFile) GetSummaryStatValues(columnName string) ([]string, bool) {
-	_, columnIndex := find(summaryColumnNames, columnName)
-	if columnIndex < 0 {
-		return []string{}, false
-	}
-	stats := make([]string, len(iot.Measurements))
-
-	for ndx := 0; ndx < len(iot.Measurements); ndx++ {
-		for _, item := range iot.Measurements {
-			if item.ColumnOrder == ndx && !item.Ignore && ndx < len(iot.Measurements)-1 {
-				_, aliasName := StandardName(iot.Summary[ndx+1][0])
-				item, _ := iot.GetMeasurementItemFromName(aliasName)
-				stats[ndx] = strings.TrimSpace(iot.Summary[ndx+1][columnIndex])
-				if strings.HasPrefix(strings.ToLower(item.MeasurementType), "int") || strings.HasPrefix(strings.ToLower(item.MeasurementType), "long") {
-					index := strings.Index(stats[ndx], ".")
-					if index >= 0 {
-						stats[ndx] = stats[ndx][0:index]
-					}
-				}
-				if len(stats[ndx]) == 0 {
-					stats[ndx] = strings.TrimSpace(iot.Summary[ndx+1][1]) // this type is nearly always Unicode
-				}
-			}
-		}
-	}
-	return stats, true
+	return summaryStatValues(iot.Summary, iot.items(), columnName)
 }
 
 // Return string-formatted value from: columnName={summaryColumnNames}, fieldName={measurement names}
 func (iot *IoTDbCsvDataFile) GetSummaryValue(columnName, fieldName string) string {
-	columnNames, found1 := iot.GetSummaryStatValues(summaryColumnNames[0])
-	columnValues, found2 := iot.GetSummaryStatValues(columnName)
-	_, index := find(columnNames, fieldName) // exact match
-	if !found1 || !found2 || index < 0 {
-		return ""
-	}
-	return columnValues[index]
+	return summaryValue(iot.Summary, iot.items(), columnName, fieldName)
 }
 
 // iterate over Summary header row
 func (iot *IoTDbCsvDataFile) GetColumnNumberFromName(columnName string) int {
-	for ndx := 0; ndx < len(iot.Summary[0]); ndx++ {
-		if strings.ToLower(iot.Summary[0][ndx]) == columnName {
-			return ndx
-		}
-	}
-	return -1
+	return summaryColumnNumber(iot.Summary, columnName)
 }
 
 // Expects {Units, DatasetName} fields to have been appended to the summary file. Assign []Measurements. Expects Summary to be assigned. Use XSD data types.
-// len() only returns the length of the "external" array.
 func (iot *IoTDbCsvDataFile) XsvSummaryTypeMap() {
 	iot.Measurements = make(map[string]*MeasurementItem, 0)
-	// get units column
-	unitsColumn := iot.GetColumnNumberFromName(unitsName)
-	ndx1 := 0
-	for ndx := 0; ndx < maxColumns; ndx++ { // iterate over summary file rows.
-		endOfMeasurements := iot.Summary[ndx+1][0] == interpolated || strings.TrimSpace(iot.Summary[ndx+1][0]) == endOfFields
-		if endOfMeasurements {
-			iot.Identifier = iot.Summary[ndx+1][1]
-			ndx1 = ndx
-			break
-		}
-		dataColumnName, aliasName := StandardName(iot.Summary[ndx+1][0]) // dataColumnName is normalized for IotDB naming conventions.   
-		ignore := iot.Summary[ndx+1][0] == "time" || isEmptyDataColumn(iot.Summary[ndx+1]) || dataColumnName == interpolated
-		if ignore {
-			fmt.Println("Ignoring empty data column " + dataColumnName)			
-		}
-		theEnd := dataColumnName == interpolated || strings.TrimSpace(iot.Summary[ndx+1][0]) == endOfFields
-		if !theEnd {
-			mi := MeasurementItem{
-				MeasurementName:  aliasName, // the CSV field names are often unusable.
-				MeasurementAlias: dataColumnName,
-				MeasurementType:  rowsXsdMap[iot.Summary[ndx+1][1]],
-				MeasurementUnits: iot.Summary[ndx+1][unitsColumn],
-				ColumnOrder:      ndx,
-				Ignore:           ignore,
-			}
-			iot.Measurements[dataColumnName] = &mi // add to map using original name
-		}
-	}
-	// add DatasetName timerseries in case data column names are the same for different sampling intervals.
-	mi := MeasurementItem{
-		MeasurementName:  LastColumnName,
-		MeasurementAlias: LastColumnName,
-		MeasurementType:  "string",
-		MeasurementUnits: "unitless",
-		ColumnOrder:      ndx1,
-		Ignore:           false,
+	identifier, items := summaryMeasurements(iot.Summary)
+	iot.Identifier = identifier
+	for _, item := range items {
+		iot.Measurements[item.MeasurementAlias] = item // add to map using IotDB name
 	}
-	iot.Measurements[LastColumnName] = &mi
 }
 
 // Return list of ordered dataset column names as string. Does not include enclosing ()
 func (iot *IoTDbCsvDataFile) FormattedColumnNames() string {
-	var sb strings.Builder
-	for ndx := 0; ndx < len(iot.Measurements); ndx++ {
-		for _, item := range iot.Measurements {
-			if item.ColumnOrder == ndx && !item.Ignore {
-				sb.WriteString(item.MeasurementAlias + ",")
-			}
-		}
-	}
-	if len(sb.String()) < 1 {
-		return " "
-	}
-	str := sb.String()[0:len(sb.String())-1] + " " // replace trailing comma
-	return str
+	return formattedColumnNames(iot.Schema())
 }
 
 // Expects comma-separated files. Assigns Dataset or Summary.
 func (iot *IoTDbCsvDataFile) ReadCsvFile(filePath string, isDataset bool) error {
-	f, err := os.Open(filePath)
-	checkErr("Unable to read csv file: ", err)
-	defer f.Close()
-	fmt.Println("Reading " + filePath)
-	csvReader := csv.NewReader(f)
+	iot.progress("Reading " + filePath)
+	records, err := readCsvRecords(filePath, false)
+	if err != nil {
+		return errors.New("Unable to parse file as CSV for " + filePath + ": " + err.Error())
+	}
 	if !isDataset {
-		iot.Summary, err = csvReader.ReadAll()
+		iot.Summary = records
 	} else {
-		iot.Dataset, err = csvReader.ReadAll()
+		iot.Dataset = records
 	}
-	checkErr("Unable to parse file as CSV for "+filePath, err)
-	return err
+	return nil
 }
 
 func (iot *IoTDbCsvDataFile) GetRowNumberFromName(rowName string) int {
@@ -1352,136 +1285,127 @@ func (iot *IoTDbCsvDataFile) GetRowNumberFromName(rowName string) int {
 	return -1
 }
 
-// Command-line parameters: {drop create delete insert ...}. Always output dataset description.
-// create time series root.datasets.etsi.household_data_60min_singleindex.DE_KN_industrial1_grid_import with datatype=FLOAT, encoding=GORILLA, compressor=SNAPPY;
-// ProcessTimeseries is the only place where iot.IoTDbAccess.session is instantiated and clientConfig is used.
-func (iot *IoTDbCsvDataFile) ProcessTimeseries() error {
-	if iot.IoTDbAccess.ActiveSession {
-		iot.IoTDbAccess.session = client.NewSession(clientConfig)
-		if err := iot.IoTDbAccess.session.Open(false, 0); err != nil {
-			checkErr("ProcessTimeseries(iot.IoTDbAccess.session.Open): ", err)
-		}
-		defer iot.IoTDbAccess.session.Close()
-	}
-	fmt.Println("Processing time series for CSV dataset " + iot.DatasetName + " ...")
-
-	for _, command := range iot.TimeseriesCommands {
-		switch command {
-		case "createdb":
-			sql := "CREATE DATABASE " + iot.Identifier
-			_, err := iot.IoTDbAccess.session.ExecuteNonQueryStatement(sql)
-			checkErr("ExecuteNonQueryStatement(createDBstatement)", err)
-			fmt.Println(sql)
-
-		case "dropts": // time series schema; uses single statement; REFACTOR: this drops all timeseries, but can be changed to drop individual timeseries.
-			sql := "DROP TIMESERIES " + IotDatasetPrefix(iot.Identifier, iot.DatasetName) + ".*"
-			_, err := iot.IoTDbAccess.session.ExecuteNonQueryStatement(sql)
-			checkErr("ExecuteNonQueryStatement(dropStatement)", err)
-			for k := range iot.Measurements { 
-				delete(iot.Measurements, k)
-			}
+// Every measurement, ignored ones included, in column order.
+func (iot *IoTDbCsvDataFile) items() []*MeasurementItem {
+	items := make([]*MeasurementItem, 0, len(iot.Measurements))
+	for _, item := range iot.Measurements {
+		items = append(items, item)
+	}
+	return orderedMeasurements(items, true)
+}
 
-		case "createts":
-			// create aligned time series schema; single statement: CREATE ALIGNED TIMESERIES root.etsidata.device.household_data_1min_singleindex (utc_timestamp TEXT encoding=PLAIN compressor=SNAPPY,  etc);
-			// Note: For a group of aligned timeseries, Iotdb does not support different compressions.
-			// https://iotdb.apache.org/UserGuide/V1.0.x/Reference/SQL-Reference.html#schema-statement
-			var sb strings.Builder
-			sb.WriteString("CREATE ALIGNED TIMESERIES " + IotDatasetPrefix(iot.Identifier, iot.DatasetName) + "(")
-			for ndx := 0; ndx < len(iot.Measurements); ndx++ {
-				for _, item := range iot.Measurements {
-					if item.ColumnOrder == ndx && !item.Ignore {
-						dataType, encoding, compressor := getClientStorage(item.MeasurementType)
-						attributes := " ATTRIBUTES('datatype'='" + item.MeasurementType  + "') TAGS('units'='" + item.MeasurementUnits + "')"
-						sb.WriteString(item.MeasurementAlias + " " + dataType + " encoding=" + encoding + " compressor=" + compressor + attributes + ",") 
-					}
-				}
-			}
-			sql := sb.String()[0:len(sb.String())-1] + ");" // replace trailing comma
-			//fmt.Println(sql)
-			_, err := iot.IoTDbAccess.session.ExecuteNonQueryStatement(sql)
-			checkErr("ExecuteNonQueryStatement(createStatement)", err)
-			fmt.Println("IOTDB TEST QUERY: show timeseries " + IotDatasetPrefix(iot.Identifier, iot.DatasetName) + ".*;")
-
-		case "delete": // remove all data; retain schema; multiple commands.
-			deleteStatements := make([]string, 0)
-			for _, item := range iot.Measurements {
-				deleteStatements = append(deleteStatements, "DELETE FROM "+IotDatasetPrefix(iot.Identifier, iot.DatasetName)+"."+item.MeasurementName+";")
-			}
-			_, err := iot.IoTDbAccess.session.ExecuteBatchStatement(deleteStatements) // (r *common.TSStatus, err error)
-			checkErr("ExecuteBatchStatement(deleteStatements)", err)
-
-		case "insert": 
-			// Automatically inserts long time column as first column (which should be UTC). Save in blocks.
-			timeIndex := iot.GetRowNumberFromName(iot.TimeMeasurementName) - 1
-			blockSize := getBlockSize(len(iot.Measurements))
-			nBlocks := len(iot.Dataset)/blockSize + 1
-			fmt.Printf("%s%d%s", "Writing ", nBlocks, " blocks: ")
-			for block := 0; block < nBlocks; block++ {
-				fmt.Print(".") //fmt.Printf("%s%d-%d\n", "block: ", startRow, endRow-1)
-				var sb strings.Builder
-				var insert strings.Builder
-				insert.WriteString("INSERT INTO " + IotDatasetPrefix(iot.Identifier, iot.DatasetName) + " (time, " + iot.FormattedColumnNames() + ") ALIGNED VALUES ")
-				startRow := blockSize*block + 1
-				endRow := startRow + blockSize
-				if block == nBlocks-1 {
-					endRow = len(iot.Dataset)
-				}
-				for r := startRow; r < endRow; r++ {
-					sb.Reset()
-					startTime, err := filesystem.GetStartTimeFromLongint(iot.Dataset[r][timeIndex]) 
-					if err != nil {
-						fmt.Println("Bad start time: <" + iot.Dataset[r][timeIndex]+">")
-						break
-					}
-					sb.WriteString("(" + strconv.FormatInt(startTime.UTC().Unix()*1000, 10) + ",")
-					for c := 0; c < len(iot.Dataset[r]); c++ {
-						for _, item := range iot.Measurements {
-							if item.ColumnOrder == c && !item.Ignore {
-								sb.WriteString(formatDataItem(iot.Dataset[r][c], item.MeasurementType) + ",") 
-							}
-						}
-					}
-					sb.WriteString(formatDataItem(iot.DatasetName, "string") + ")")
-					if r < endRow-1 {
-						sb.WriteString(",")
-					}
-					insert.WriteString(sb.String())
-				}
-				_, err := iot.IoTDbAccess.session.ExecuteNonQueryStatement(insert.String() + ";") // (r *common.TSStatus, err error)
-				checkErr("ExecuteNonQueryStatement(insertStatement)", err)
-			}
-			fmt.Println("\nIOTDB TEST QUERY: SELECT COUNT(*) FROM " + IotDatasetPrefix(iot.Identifier, iot.DatasetName) + ";")
-			fmt.Println("IOTDB TEST QUERY: SELECT * FROM " + IotDatasetPrefix(iot.Identifier, iot.DatasetName) + " LIMIT 2;")
-		}
+// SensorSource (sensorsource.go): the dataset is the only device.
+func (iot *IoTDbCsvDataFile) Access() *IoTDbAccess { return &iot.IoTDbAccess }
+func (iot *IoTDbCsvDataFile) Database() string     { return iot.Identifier }
+func (iot *IoTDbCsvDataFile) Name() string         { return iot.DatasetName }
+func (iot *IoTDbCsvDataFile) Devices() []string    { return []string{iot.DatasetName} }
+
+func (iot *IoTDbCsvDataFile) Schema() []*MeasurementItem {
+	return orderedMeasurements(iot.items(), false)
+}
 
-		fmt.Println("Timeseries <" + command + "> completed.")
-	} // for
+// The summary range (for the outlier detectors), the data package description and the annotations and categories of an adapter.
+func (iot *IoTDbCsvDataFile) Attributes(item *MeasurementItem) string {
+	return iot.rangeAttributes(item) + descriptionAttribute(item) + iot.adapterAttributes(item)
+}
 
+// Validate the rows first: rejected rows are quarantined and the quality report is written. Only valid rows are inserted.
+func (iot *IoTDbCsvDataFile) Batches(blockSize int, write func(device string, batch RowBatch) error) error {
+	timeIndex := iot.GetRowNumberFromName(iot.TimeMeasurementName) - 1
+	validRows, report := iot.ValidateDataset(timeIndex)
+	iot.Quality = report
+	err := iot.WriteQuarantine(&report)
+	if err != nil {
+		return errors.New("WriteQuarantine: " + err.Error())
+	}
+	err = iot.WriteQualityReport(report)
+	if err != nil {
+		return errors.New("WriteQualityReport: " + err.Error())
+	}
+	schema := iot.Schema()
+	for startRow := 0; startRow < len(validRows); startRow += blockSize {
+		endRow := min(startRow+blockSize, len(validRows))
+		batch := RowBatch{Timestamps: make([]int64, 0, endRow-startRow), Values: make([][]string, 0, endRow-startRow)}
+		for _, validRow := range validRows[startRow:endRow] {
+			batch.Timestamps = append(batch.Timestamps, validRow.Timestamp)
+			batch.Values = append(batch.Values, schemaValues(iot.Dataset[validRow.Index], schema, iot.DatasetName))
+		}
+		if err := write(iot.DatasetName, batch); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// Creates, drops, deletes and inserts the dataset like any other SensorSource.
+func (iot *IoTDbCsvDataFile) ProcessTimeseries() error {
+	return ProcessTimeseries(iot)
+}
+
 // Data source file types determined by file extension: {.nc, .csv, .hd5}  Args[0] is program name.
 func main() {
 	//fmt.Println(ShowLastExternalBackup())
 	//ExecuteAlterStatements()
 	//os.Exit(0)
 	
+	if RunCliCommand(os.Args) { // netcdf ingest|query|export|serve|retag|describe [flags]; see cli.go
+		return
+	}
 	sourceDataType := "help"
 	if len(os.Args) > 1 {
-		sourceDataType = path.Ext(os.Args[1])
+		sourceDataType = sourceExt(os.Args[1]) // .csv for data.csv.gz and archive.zip/data.csv
+		if len(sourceDataType) == 0 {
+			sourceDataType = strings.ToLower(os.Args[1]) // a program command rather than a data file.
+		}
+		if isIngestSet(os.Args) {
+			sourceDataType = csvExtension // a folder or glob of data files
+		}
 	}
-	if len(os.Args) < 3 {
+	if len(os.Args) < 3 && strings.HasPrefix(sourceDataType, ".") {
 		sourceDataType = "help"
 	}
 
+	checkErr("configureIotdbPrecision", configureIotdbPrecision(os.Args))
 	switch sourceDataType {
 	case ".csv":
 		ProcessCsvSensorData(os.Args)
 	case ".nc": 
 		ProcessNcSensorData(os.Args)
+	case "serve", "replay":
+		ServeSensorStreams(os.Args)
+	case "mqtt":
+		ProcessMqttBridge(os.Args)
+	case "query":
+		ProcessQuery(os.Args)
+	case "export":
+		ProcessExport(os.Args)
+	case "resample":
+		ProcessResample(os.Args)
+	case "gaps":
+		ProcessGaps(os.Args)
+	case "synthesize":
+		ProcessSynthesize(os.Args)
+	case "inject":
+		ProcessInject(os.Args)
+	case "analyze":
+		ProcessAnalyze(os.Args)
+	case "retag":
+		ProcessRetag(os.Args)
+	case "describe":
+		ProcessDescribe(os.Args)
+	case "batch":
+		ProcessBatch(os.Args)
+	case "homes":
+		ProcessHomes(os.Args)
 	//case ".hd5":
 	default:
 		fmt.Println("The commands to the netcdf program copy time series data from source files into the IoT database.")
+		fmt.Println("netcdf <command> [flags] <arguments> with the commands:")
+		for _, command := range cliCommands {
+			fmt.Printf("  %-9s: %s : %s\n", command.Name, command.usage(), command.Summary)
+		}
+		fmt.Println("  netcdf help <command> prints the flags of a command; defaults come from netcdf.env and a profile of netcdf.yaml or netcdf.toml (--config, --profile).")
+		fmt.Println("The original form of the commands is still accepted:")
 		fmt.Println("Before running netcdf, run the 'xsv stats <dataFile.csv> --everything' program to place a csv summary* file in the same folder as the <dataFile.csv>.")
 		fmt.Println("netcdf parameters: full path to csv or nc sensor data file, followed by case-sensitive timeMeasurementName, followed by an (optional) CDF file type {HDF5, netCDF-4, classic}, ")
 		fmt.Println("followed by one or more commands: ")
@@ -1490,121 +1414,39 @@ func main() {
 		fmt.Println("  insert	: insert the data from a CSV file.")
 		fmt.Println("  dropts   : drop the entire set of time series measurements but keep the database. Run this command by itself.")
 		fmt.Println("  delete	: delete a specific time series measurement and its data.")
-		//fmt.Println("  query	: execute a specific query against a database.")
-		//fmt.Println("  example: produce a (random) time series instance.")
+		fmt.Println("  timelayout=<Go layout>[|<layout>] timezone=<IANA zone> ambiguous=earlier|later|error : read times other than epoch numbers and ISO 8601; wall-clock times are UTC unless timezone is given.")
+		fmt.Println("  epoch=auto|s|ms|us|ns : unit of epoch integers (auto guesses it from the magnitude); precision=ms|us|ns : IoTDB timestamp_precision, default IOTDB_TIMESTAMP_PRECISION or ms.")
+		fmt.Println("  Data and summary files may be compressed (.gz, .bz2, .zst) or archive members (opsd.zip/time_series.csv, raw.tar.bz2/AMP/Water_DWW.csv); see compressed.go.")
+		fmt.Println("  datapackage=<datapackage.json> : Frictionless types, units, descriptions and missing values of a CSV file; by default the datapackage.json next to it (see datapackage.go).")
+		fmt.Println("  adapter=auto|toniot|none : raw ToN_IoT IoT_*.csv files get a utc_timestamp from their date and time columns, booleans and categories (see toniot.go).")
+		fmt.Println("  A folder, a quoted glob or several CSV files in place of the data file go into one database (database=root.x.y, default the first summary's identifier), one device per file.")
+		fmt.Println("netcdf serve : stream sensor data to gRPC SensorStream clients at localhost:$GRPCPORT (sensorpb/sensorstream.proto) and to WebSocket subscribers at ws://localhost:$WSPORT/subscribe; send {\"action\":\"subscribe\",\"pattern\":\"root.ecobee.household.*.*\"}")
+		fmt.Println("           : REST at http://localhost:$HTTPPORT/datasets, /datasets/{device}/measurements, /query?path=&start=&end=&agg=&interval=&format=json|csv and Server-Sent Events at /stream?pattern=")
+		fmt.Println("           : subscriptions may add measurements, units, where (e.g. T_ctrl>=75), a tumbling|sliding window of mean/min/max, buffer and policy=drop-oldest|disconnect")
+		fmt.Println("           : rows inserted while a pattern is subscribed stream whatever their timestamps, so historical datasets stream as they are ingested;")
+		fmt.Println("           : points older than the last streamed point of their series (out-of-order inserts) are not streamed")
+		fmt.Println("netcdf replay <file.csv timeMeasurementName | file.nc cdfType timeMeasurementName | root.device.pattern> [speed=1|60|max] [from=time] [to=time] [clock=wall|simulated]")
+		fmt.Println("         : serve a dataset as if it were live; control with {\"action\":\"start|pause|resume|stop|seek|speed\"}")
+		fmt.Println("netcdf mqtt publish <replay source> [broker=tcp://127.0.0.1:1883] [qos=0|1] [speed=max] : publish each row to topics such as ecobee/household/<id>/<measurement>")
+		fmt.Println("netcdf mqtt subscribe [broker=tcp://127.0.0.1:1883] [qos=0|1] [topic=ecobee/#] [schema=<dataFile.csv>] [dryrun=true] : insert live topics into the aligned time series of IoTDB")
+		fmt.Println("         : topics must name a measurement of an existing device, or of a new <database>.<id> device of the schema= summary; others are rejected")
+		fmt.Println("netcdf query \"<IoTDB SQL>\" [format=table|csv|json] [fetchsize=1024] : execute queries such as the IOTDB TEST QUERY lines; separate statements with ';'")
+		fmt.Println("netcdf export <root.device or pattern> [start=time] [end=time] [measurements=a,b] [format=csv|parquet] [output=dir] [chunkmb=256] [names=original|alias] : write files of at most chunkmb per device")
+		fmt.Println("         : [datapackage=original datapackage.json] [license=ODC-BY-1.0] : the files are described by <output>/datapackage.json")
+		fmt.Println("         : [source=ingested data file] : original names of series created before createts recorded ATTRIBUTES('name'); datapackage= also gives them")
+		fmt.Println("netcdf resample <root.device or pattern> interval=60min [method=mean|sum|last|linear|step] [methods=Energy:sum,State:last] [start=time] [end=time] [output=iotdb|csv|parquet] [suffix=_60min] [dir=.]")
+		fmt.Println("         : put series on a regular grid; output=iotdb writes the device <device>_60min")
+		fmt.Println("netcdf gaps <root.device or pattern> [interval=auto|15min] [tolerance=1.5] [fill=none|null|ffill|linear|seasonal] [season=1d] [start=time] [end=time] [report=gaps.csv]")
+		fmt.Println("         : report missing spans per series; a fill writes the missing points and flags them in <measurement>_filled")
+		fmt.Println("netcdf synthesize <dataFile.csv> <timeMeasurementName> [devices=3] [seed=1] [rows=0] [start=time] [sample=10000] [output=iotdb|csv] [dir=.] [commands=createts,insert]")
+		fmt.Println("         : produce random time series instances with the summary statistics, daily profile and autocorrelation of a dataset")
+		fmt.Println("netcdf inject <root.device or pattern> [faults=stuck,spike,drift,dropout,skew,duplicate,unitflip] [measurements=a,b] [rate=0.01] [length=12] [magnitude=5] [skew=auto] [seed=1]")
+		fmt.Println("         : [start=time] [end=time] [suffix=_faults] [output=iotdb|csv|parquet] [dir=.] [report=faults.csv] : copy a device with injected faults and label/type series")
+		fmt.Println("netcdf analyze outliers <root.device or pattern> [detectors=mad,iqr,esd,rate] [threshold=3.5] [iqr=1.5] [season=1d] [alpha=0.05] [maxanomalies=0.02]")
+		fmt.Println("         : [start=time] [end=time] [output=report|iotdb] [report=outliers.csv] : flag outliers in <measurement>_outlier; ranges come from the min/max attributes")
+		fmt.Println("netcdf homes join <meta_data.csv> [database=root.ecobee.household] [id=Identifier] [tags=Country,ProvinceState,City] [dryrun=true] : upsert each home's metadata on its device series")
+		fmt.Println("         : netcdf homes select [database=] number_floors=2 provincestate=ON \"hvac~heat pump\" : list the devices whose TAGS and ATTRIBUTES match (= != >= <= > < ~)")
 		os.Exit(0)
 	}
 }
 
-/*func printDataSet(sds *client.SessionDataSet) []string {
-	const tab = "\t"
-	output := make([]string, 0)
-	showTimestamp := !sds.IsIgnoreTimeStamp()
-	if showTimestamp {
-		output = append(output, "Time"+tab+tab+tab+tab)
-	}
-	for i := 0; i < sds.GetColumnCount(); i++ {
-		output = append(output, sds.GetColumnName(i)+tab)
-	}
-	output = append(output, crlf)
-
-	for next, err := sds.Next(); err == nil && next; next, err = sds.Next() {
-		if showTimestamp {
-			output = append(output, sds.GetText(client.TimestampColumnName)+tab)
-		}
-		for i := 0; i < sds.GetColumnCount(); i++ {
-			columnName := sds.GetColumnName(i)
-			v := sds.GetValue(columnName)
-			if v == nil {
-				v = "null"
-			}
-			str := fmt.Sprintf("%v\t\t", v)
-			output = append(output, str)
-		}
-		output = append(output, crlf)
-	}
-	return output
-}*/
-
-/*
-type AutoGenerated struct {
-	Title           string   `json:"title"`
-	Name            string   `json:"name"`
-	Description     string   `json:"description"`
-	LongDescription string   `json:"long_description"`
-	Documentation   string   `json:"documentation"`
-	Version         string   `json:"version"`
-	LastChanges     string   `json:"last_changes"`
-	Keywords        []string `json:"keywords"`
-	Contributors    []struct {
-		Web   string `json:"web"`
-		Name  string `json:"name"`
-		Email string `json:"email"`
-	} `json:"contributors"`
-	Sources []struct {
-		Web    string `json:"web"`
-		Name   string `json:"name"`
-		Source string `json:"source"`
-	} `json:"sources"`
-	Licenses []struct {
-		ID      string `json:"id"`
-		Version string `json:"version"`
-		Name    string `json:"name"`
-		URL     string `json:"url"`
-	} `json:"licenses"`
-	External          bool   `json:"external"`
-	GeographicalScope string `json:"geographical-scope"`
-	IotdbGroupname    string `json:"iotdb-groupname"`
-	Resources         []struct {
-		Mediatype string `json:"mediatype"`
-		Format    string `json:"format"`
-		Path      string `json:"path"`
-		Encoding  string `json:"encoding,omitempty"`
-		Schema    string `json:"schema,omitempty"`
-		Dialect   struct {
-			CsvddfVersion  float64 `json:"csvddfVersion"`
-			Delimiter      string  `json:"delimiter"`
-			LineTerminator string  `json:"lineTerminator"`
-			Header         bool    `json:"header"`
-		} `json:"dialect,omitempty"`
-	} `json:"resources"`
-	Schemas struct {
-		OneMin struct {
-			PrimaryKey   string `json:"primaryKey"`
-			MissingValue string `json:"missingValue"`
-			Fields       []struct {
-				Name              string `json:"name"`
-				Description       string `json:"description"`
-				Type              string `json:"type"`
-				Format            string `json:"format,omitempty"`
-				OpsdContentfilter bool   `json:"opsd-contentfilter,omitempty"`
-				Unit              string `json:"unit,omitempty"`
-			} `json:"fields"`
-		} `json:"1min"`
-		One5Min struct {
-			PrimaryKey   string `json:"primaryKey"`
-			MissingValue string `json:"missingValue"`
-			Fields       []struct {
-				Name              string `json:"name"`
-				Description       string `json:"description"`
-				Type              string `json:"type"`
-				Format            string `json:"format,omitempty"`
-				OpsdContentfilter bool   `json:"opsd-contentfilter,omitempty"`
-				Unit              string `json:"unit,omitempty"`
-			} `json:"fields"`
-		} `json:"15min"`
-		Six0Min struct {
-			PrimaryKey   string `json:"primaryKey"`
-			MissingValue string `json:"missingValue"`
-			Fields       []struct {
-				Name              string `json:"name"`
-				Description       string `json:"description"`
-				Type              string `json:"type"`
-				Format            string `json:"format,omitempty"`
-				OpsdContentfilter bool   `json:"opsd-contentfilter,omitempty"`
-				Unit              string `json:"unit,omitempty"`
-			} `json:"fields"`
-		} `json:"60min"`
-	} `json:"schemas"`
-}
-*/
@@ -0,0 +1,450 @@
+package main // mqtt.go bridges dataset streams and an MQTT broker (ToN_IoT datasets are MQTT/Modbus telemetry).
+// publish: every measurement of every replayed row goes to the topic derived from its IoTDB path:
+//   root.ecobee.household.<id>.Indoor_AverageTemperature => ecobee/household/<id>/Indoor_AverageTemperature
+// subscribe: live topics are mapped back to IoTDB paths and inserted into the aligned time series of the device. The topic leaf must be a
+//   measurement of the device (its alias, its original name, or a name StandardName aliases to it): one of the series already in IoTDB,
+//   or of the summary of schema=<dataFile.csv> for a new device <database>.<node>, which is created the way createts creates it.
+//   Other topics, and values that are not of the measurement's type, are rejected.
+// Payloads are MqttPayload JSON; a subscriber also accepts a bare value and stamps it with the arrival time.
+
+import (
+	"encoding/json"
+	"errors"
+	"filesystem" // work module
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apache/iotdb-client-go/client"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	mqttTopicSep       = "/"
+	mqttWildcard       = "#"
+	mqttConnectTimeout = 10 * time.Second
+	mqttFlushInterval  = time.Second
+	mqttQuiesceMs      = 250
+)
+
+var mqttParameters = map[string]string{"broker": "tcp://127.0.0.1:1883", "qos": "0", "topic": mqttWildcard, "clientid": "", "schema": "", "dryrun": "false"}
+
+// MqttPayload is the message body of one measurement.
+type MqttPayload struct {
+	Timestamp int64       `json:"timestamp"` // unix milliseconds UTC
+	Value     interface{} `json:"value"`
+}
+
+// root.ecobee.household.<id>.Indoor_AverageTemperature => ecobee/household/<id>/Indoor_AverageTemperature
+func IotdbPathToMqttTopic(fullPath string) string {
+	return strings.ReplaceAll(strings.TrimPrefix(fullPath, "root."), iotdbPathSep, mqttTopicSep)
+}
+
+// ecobee/household/<id>/Indoor_AverageTemperature => (root.ecobee.household.<id>, Indoor_AverageTemperature)
+func MqttTopicToIotdbPath(topic string) (string, string) {
+	fullPath := "root." + strings.ReplaceAll(strings.Trim(topic, mqttTopicSep), mqttTopicSep, iotdbPathSep)
+	return splitIotdbPath(fullPath)
+}
+
+// ecobee/household/# => root.ecobee.household.**, ecobee/+/<id>/# => root.ecobee.*.<id>.**
+func mqttTopicPattern(topic string) string {
+	prefix := strings.Trim(strings.TrimSuffix(topic, mqttWildcard), mqttTopicSep)
+	if len(prefix) == 0 {
+		return "root" + iotdbPathSep + anyNodes
+	}
+	return "root" + iotdbPathSep + strings.ReplaceAll(strings.ReplaceAll(prefix, "+", anyOneNode), mqttTopicSep, iotdbPathSep) + iotdbPathSep + anyNodes
+}
+
+// MqttSchema resolves topics to the devices and MeasurementItems of the aligned time series they are inserted into.
+type MqttSchema struct {
+	Database string             // new devices are <Database>.<node>; "" when only existing devices are written
+	Items    []*MeasurementItem // the measurements of new devices
+	template map[string]*MeasurementItem
+	devices  map[string]map[string]*MeasurementItem // device with time series => measurementIndex
+}
+
+func NewMqttSchema(database string, items []*MeasurementItem) *MqttSchema {
+	return &MqttSchema{Database: database, Items: items, template: measurementIndex(items), devices: make(map[string]map[string]*MeasurementItem, 0)}
+}
+
+// The measurements and database of the summary of a data file, as createts reads them; an empty schema without a data file.
+func LoadMqttSchema(dataFilePath string, programArgs []string) (*MqttSchema, error) {
+	if len(dataFilePath) == 0 {
+		return NewMqttSchema("", nil), nil
+	}
+	summary, _, err := ReadDataFileSummary(dataFilePath, programArgs)
+	if err != nil {
+		return nil, err
+	}
+	database, items := summaryMeasurements(summary)
+	measurements := make([]*MeasurementItem, 0, len(items))
+	for _, item := range orderedMeasurements(items, false) {
+		if item.MeasurementAlias != LastColumnName { // messages do not carry the dataset name
+			measurements = append(measurements, item)
+		}
+	}
+	if !strings.HasPrefix(database, "root.") {
+		return nil, errors.New(dataFilePath + ": the summary has no root.<database> identifier")
+	}
+	return NewMqttSchema(database, measurements), nil
+}
+
+// Index items by alias and by original name.
+func measurementIndex(items []*MeasurementItem) map[string]*MeasurementItem {
+	index := make(map[string]*MeasurementItem, 2*len(items))
+	for _, item := range items {
+		index[item.MeasurementAlias] = item
+	}
+	for _, item := range items {
+		if _, ok := index[item.MeasurementName]; !ok {
+			index[item.MeasurementName] = item
+		}
+	}
+	return index
+}
+
+// Add the time series that exist in IoTDB.
+func (schema *MqttSchema) AddProfiles(profiles []IotdbTimeseriesProfile) {
+	items := make(map[string][]*MeasurementItem, 0)
+	for ndx, profile := range profiles {
+		device, _ := splitIotdbPath(profile.Timeseries)
+		mi := profile.ToMeasurementItem(ndx)
+		items[device] = append(items[device], &mi)
+	}
+	for device, deviceItems := range items {
+		schema.devices[device] = measurementIndex(deviceItems)
+	}
+}
+
+// The device and measurement of topic; an error for a topic that does not map to the schema.
+func (schema *MqttSchema) Resolve(topic string) (string, *MeasurementItem, error) {
+	device, leaf := MqttTopicToIotdbPath(topic)
+	if len(leaf) == 0 || !strings.Contains(device, iotdbPathSep) {
+		return "", nil, errors.New("the topic names no device and measurement")
+	}
+	index, exists := schema.devices[device]
+	if !exists {
+		node, found := strings.CutPrefix(device, schema.Database+iotdbPathSep)
+		if len(schema.Database) == 0 || !found || strings.Contains(node, iotdbPathSep) {
+			return "", nil, errors.New(device + " has no time series; schema=<dataFile.csv> creates devices of its database")
+		}
+		index = schema.template
+	}
+	item, ok := index[leaf]
+	if !ok {
+		alias, _ := StandardName(leaf)
+		if item, ok = index[alias]; !ok {
+			return "", nil, errors.New(device + " has no measurement " + leaf)
+		}
+	}
+	return device, item, nil
+}
+
+// Format a decoded JSON value as an INSERT value of item; an error when it is not of the item's type.
+func formatMqttValue(value interface{}, item *MeasurementItem) (string, error) {
+	var text string
+	switch v := value.(type) {
+	case float64:
+		text = strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		text = strconv.FormatBool(v)
+	case string:
+		text = strings.TrimSpace(v)
+	default:
+		text = fmt.Sprint(v)
+	}
+	formatted := formatDataItem(text, item.MeasurementType)
+	switch {
+	case strings.HasPrefix(formatted, "'") || formatted == "null":
+		return formatted, nil
+	case strings.EqualFold(item.MeasurementType, "boolean"):
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return "", errors.New(item.MeasurementAlias + " is boolean: " + text)
+		}
+		return strconv.FormatBool(b), nil
+	}
+	if _, err := strconv.ParseFloat(text, 64); err != nil {
+		return "", errors.New(item.MeasurementAlias + " is " + item.MeasurementType + ": " + text)
+	}
+	return formatted, nil
+}
+
+// MqttBridge owns one broker connection.
+type MqttBridge struct {
+	IoTDbAccess
+	Broker   string      `json:"broker"`
+	ClientId string      `json:"clientid"`
+	Qos      byte        `json:"qos"` // 0 or 1
+	Schema   *MqttSchema `json:"-"`   // of subscribed topics
+	conn     mqtt.Client
+	mutex    sync.Mutex
+	pending  map[string][]SensorFrame // device => frames waiting to be inserted; values are formatted by formatMqttValue
+	rejected map[string]int           // topic => rejected messages
+}
+
+func NewMqttBridge(parameters map[string]string) (*MqttBridge, error) {
+	qos, err := strconv.Atoi(parameters["qos"])
+	if err != nil || qos < 0 || qos > 1 {
+		return nil, errors.New("qos must be 0 or 1: " + parameters["qos"])
+	}
+	clientId := parameters["clientid"]
+	if len(clientId) == 0 {
+		clientId = "netcdf-" + filesystem.GetRandomIdentifier()
+	}
+	bridge := MqttBridge{Broker: parameters["broker"], ClientId: clientId, Qos: byte(qos), Schema: NewMqttSchema("", nil),
+		pending: make(map[string][]SensorFrame, 0), rejected: make(map[string]int, 0)}
+	options := mqtt.NewClientOptions().AddBroker(bridge.Broker).SetClientID(bridge.ClientId).SetAutoReconnect(true)
+	bridge.conn = mqtt.NewClient(options)
+	token := bridge.conn.Connect()
+	if !token.WaitTimeout(mqttConnectTimeout) {
+		return nil, errors.New("timed out connecting to MQTT broker " + bridge.Broker)
+	}
+	if token.Error() != nil {
+		return nil, token.Error()
+	}
+	fmt.Println("Connected to MQTT broker " + bridge.Broker + " as " + bridge.ClientId)
+	return &bridge, nil
+}
+
+func (bridge *MqttBridge) Close() {
+	bridge.conn.Disconnect(mqttQuiesceMs)
+}
+
+// Publish one message per measurement of frame. With QoS 1 waits for the broker acknowledgement.
+func (bridge *MqttBridge) PublishFrame(frame SensorFrame) {
+	names := make([]string, 0, len(frame.Measurements))
+	for name := range frame.Measurements {
+		names = append(names, name)
+	}
+	sort.Strings(names) // deterministic message order
+	for _, name := range names {
+		payload, err := json.Marshal(MqttPayload{Timestamp: frame.Timestamp, Value: frame.Measurements[name]})
+		if err != nil {
+			fmt.Println("PublishFrame: " + err.Error())
+			continue
+		}
+		token := bridge.conn.Publish(IotdbPathToMqttTopic(frame.MeasurementPath(name)), bridge.Qos, false, payload)
+		if bridge.Qos > 0 {
+			token.Wait()
+		}
+		if token.Error() != nil {
+			fmt.Println("PublishFrame: " + token.Error().Error())
+		}
+	}
+}
+
+// Decode a MqttPayload, or a bare JSON/text value stamped with the arrival time.
+func decodeMqttPayload(body []byte) MqttPayload {
+	var payload MqttPayload
+	if err := json.Unmarshal(body, &payload); err == nil && payload.Value != nil {
+		if payload.Timestamp <= 0 {
+			payload.Timestamp = time.Now().UTC().UnixMilli()
+		}
+		return payload
+	}
+	payload = MqttPayload{Timestamp: time.Now().UTC().UnixMilli()}
+	if err := json.Unmarshal(body, &payload.Value); err != nil {
+		payload.Value = strings.TrimSpace(string(body))
+	}
+	return payload
+}
+
+func (bridge *MqttBridge) onMessage(_ mqtt.Client, message mqtt.Message) {
+	payload := decodeMqttPayload(message.Payload())
+	bridge.mutex.Lock()
+	defer bridge.mutex.Unlock()
+	device, item, err := bridge.Schema.Resolve(message.Topic())
+	value := ""
+	if err == nil {
+		value, err = formatMqttValue(payload.Value, item)
+	}
+	if err != nil {
+		if bridge.rejected[message.Topic()] == 0 {
+			fmt.Println("Rejected MQTT topic " + message.Topic() + ": " + err.Error())
+		}
+		bridge.rejected[message.Topic()]++
+		return
+	}
+	frame := SensorFrame{Device: device, Timestamp: payload.Timestamp, Measurements: map[string]interface{}{item.MeasurementAlias: value}}
+	bridge.pending[device] = append(bridge.pending[device], frame)
+}
+
+// Format a decoded JSON or SessionDataSet value for an INSERT statement.
+func formatFrameValue(value interface{}) string {
+	switch v := value.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case int32:
+		return strconv.FormatInt(int64(v), 10)
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		return formatDataItem(v, "string")
+	default:
+		return formatDataItem(fmt.Sprint(v), "string")
+	}
+}
+
+// INSERT INTO <device> (time,a,b) ALIGNED VALUES (<timestamp>,1.5,true);
+func alignedInsertStatement(device string, timestamp int64, names, values []string) string {
+	return "INSERT INTO " + device + " (time," + strings.Join(names, ",") + ") ALIGNED VALUES (" + strconv.FormatInt(msToIotdb(timestamp), 10) + "," + strings.Join(values, ",") + ");"
+}
+
+// Take the pending frames: per device a CREATE when it has no time series yet, then one aligned INSERT per timestamp in time order.
+func (bridge *MqttBridge) pendingStatements() map[string][]string {
+	bridge.mutex.Lock()
+	defer bridge.mutex.Unlock()
+	pending := bridge.pending
+	bridge.pending = make(map[string][]SensorFrame, 0)
+
+	statements := make(map[string][]string, len(pending))
+	for device, frames := range pending {
+		rows := make(map[int64]map[string]interface{}, 0)
+		for _, frame := range frames {
+			if _, ok := rows[frame.Timestamp]; !ok {
+				rows[frame.Timestamp] = make(map[string]interface{}, 0)
+			}
+			for name, value := range frame.Measurements {
+				rows[frame.Timestamp][name] = value
+			}
+		}
+		if _, exists := bridge.Schema.devices[device]; !exists { // marked as created by flush once the statements have run
+			statements[device] = append(statements[device], createAlignedStatement(device, bridge.Schema.Items, nil))
+		}
+		timestamps := make([]int64, 0, len(rows))
+		for timestamp := range rows {
+			timestamps = append(timestamps, timestamp)
+		}
+		sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+		for _, timestamp := range timestamps {
+			names := make([]string, 0, len(rows[timestamp]))
+			for name := range rows[timestamp] {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			values := make([]string, 0, len(names))
+			for _, name := range names {
+				values = append(values, rows[timestamp][name].(string))
+			}
+			statements[device] = append(statements[device], alignedInsertStatement(device, timestamp, names, values))
+		}
+	}
+	return statements
+}
+
+// Insert the pending frames. A device whose statements fail gets its CREATE again with its next frames.
+func (bridge *MqttBridge) flush() {
+	for device, statements := range bridge.pendingStatements() {
+		if err := bridge.executeBatch(statements); err != nil {
+			fmt.Println("ExecuteBatchStatement(" + device + "): " + err.Error())
+			continue
+		}
+		bridge.mutex.Lock()
+		if _, exists := bridge.Schema.devices[device]; !exists {
+			bridge.Schema.devices[device] = bridge.Schema.template
+		}
+		bridge.mutex.Unlock()
+	}
+}
+
+// Ingest topic (MQTT wildcards allowed) into IoTDB until interrupted. Assumes clientConfig is assigned unless DryRun,
+// which prints the statements and knows only the devices of the schema= summary.
+func (bridge *MqttBridge) SubscribeIntoIotdb(topic string) error {
+	if !bridge.DryRun {
+		bridge.IoTDbAccess.session = client.NewSession(clientConfig)
+		if err := bridge.IoTDbAccess.session.Open(false, 0); err != nil {
+			return err
+		}
+		bridge.ActiveSession = true
+		defer bridge.IoTDbAccess.session.Close()
+		profiles, err := GetTimeseriesProfiles(&bridge.IoTDbAccess.session, mqttTopicPattern(topic))
+		if err != nil {
+			return err
+		}
+		bridge.mutex.Lock()
+		bridge.Schema.AddProfiles(profiles)
+		bridge.mutex.Unlock()
+	}
+
+	token := bridge.conn.Subscribe(topic, bridge.Qos, bridge.onMessage)
+	token.Wait()
+	if token.Error() != nil {
+		return token.Error()
+	}
+	fmt.Println("Subscribed to MQTT topic " + topic + "; inserting into IoTDB. Press Ctrl-C to stop.")
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	ticker := time.NewTicker(mqttFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			bridge.flush()
+		case <-interrupt:
+			bridge.conn.Unsubscribe(topic).Wait()
+			bridge.flush()
+			bridge.mutex.Lock()
+			for topic, count := range bridge.rejected {
+				fmt.Printf("%d messages of %s rejected\n", count, topic)
+			}
+			bridge.mutex.Unlock()
+			return nil
+		}
+	}
+}
+
+// programArgs: mqtt publish <replay source> [broker=tcp://host:1883] [qos=0|1] [speed=...] [from=...] [to=...]
+// programArgs: mqtt subscribe [broker=tcp://host:1883] [qos=0|1] [topic=ecobee/household/#] [schema=<dataFile.csv>] [dryrun=true]
+func ProcessMqttBridge(programArgs []string) {
+	if len(programArgs) < 3 {
+		checkErr("mqtt", errors.New("expected: mqtt publish <source> ... or mqtt subscribe ..."))
+	}
+	parameters := getProgramParameters(programArgs, mqttParameters)
+	bridge, err := NewMqttBridge(parameters)
+	checkErr("NewMqttBridge", err)
+	defer bridge.Close()
+
+	switch strings.ToLower(programArgs[2]) {
+	case "publish":
+		source := ""
+		if len(programArgs) > 3 {
+			source = programArgs[3]
+		}
+		if strings.HasPrefix(source, "root.") {
+			iotdbConnection, ok := Init_IoTDB(true)
+			if !ok {
+				checkErr("Init_IoTDB: ", errors.New(iotdbConnection))
+			}
+		}
+		replay, err := LoadReplay(programArgs[1:], bridge.PublishFrame)
+		checkErr("LoadReplay", err)
+		replay.StopAtEnd = true
+		replay.Start()
+		replay.Run()
+	case "subscribe":
+		bridge.DryRun = isDryRun(programArgs)
+		if !bridge.DryRun {
+			iotdbConnection, ok := Init_IoTDB(true)
+			if !ok {
+				checkErr("Init_IoTDB: ", errors.New(iotdbConnection))
+			}
+		}
+		bridge.Schema, err = LoadMqttSchema(parameters["schema"], programArgs)
+		checkErr("LoadMqttSchema", err)
+		err = bridge.SubscribeIntoIotdb(parameters["topic"])
+		checkErr("SubscribeIntoIotdb", err)
+	default:
+		checkErr("mqtt", errors.New("unknown mqtt mode: "+programArgs[2]))
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// testBroker is an in-process MQTT 3.1.1 broker: CONNECT, SUBSCRIBE, UNSUBSCRIBE, PUBLISH (QoS 0 and 1, delivered at QoS 0), PINGREQ, DISCONNECT.
+type testBroker struct {
+	listener      net.Listener
+	mutex         sync.Mutex
+	writes        sync.Mutex
+	subscriptions map[net.Conn][]string
+}
+
+func startTestBroker(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	broker := &testBroker{listener: listener, subscriptions: make(map[net.Conn][]string, 0)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go broker.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return "tcp://" + listener.Addr().String()
+}
+
+func (broker *testBroker) write(conn net.Conn, header byte, body []byte) {
+	packet := []byte{header}
+	length := len(body)
+	for {
+		digit := byte(length % 128)
+		if length /= 128; length > 0 {
+			digit |= 128
+		}
+		packet = append(packet, digit)
+		if length == 0 {
+			break
+		}
+	}
+	broker.writes.Lock()
+	defer broker.writes.Unlock()
+	conn.Write(append(packet, body...))
+}
+
+func (broker *testBroker) serve(conn net.Conn) {
+	defer func() {
+		broker.mutex.Lock()
+		delete(broker.subscriptions, conn)
+		broker.mutex.Unlock()
+		conn.Close()
+	}()
+	reader := bufio.NewReader(conn)
+	for {
+		header, err := reader.ReadByte()
+		if err != nil {
+			return
+		}
+		length, multiplier := 0, 1
+		for {
+			digit, err := reader.ReadByte()
+			if err != nil {
+				return
+			}
+			length += int(digit&127) * multiplier
+			multiplier *= 128
+			if digit&128 == 0 {
+				break
+			}
+		}
+		body := make([]byte, length)
+		if _, err := io.ReadFull(reader, body); err != nil {
+			return
+		}
+		switch header >> 4 {
+		case 1: // CONNECT
+			broker.write(conn, 0x20, []byte{0, 0})
+		case 3: // PUBLISH
+			n := int(binary.BigEndian.Uint16(body))
+			topic, payload := string(body[2:2+n]), body[2+n:]
+			if (header>>1)&3 > 0 {
+				broker.write(conn, 0x40, payload[:2])
+				payload = payload[2:]
+			}
+			broker.forward(topic, payload)
+		case 8: // SUBSCRIBE
+			granted := append([]byte{}, body[:2]...)
+			filters := make([]string, 0)
+			for rest := body[2:]; len(rest) > 2; {
+				n := int(binary.BigEndian.Uint16(rest))
+				filters = append(filters, string(rest[2:2+n]))
+				granted = append(granted, 0)
+				rest = rest[2+n+1:]
+			}
+			broker.mutex.Lock()
+			broker.subscriptions[conn] = append(broker.subscriptions[conn], filters...)
+			broker.mutex.Unlock()
+			broker.write(conn, 0x90, granted)
+		case 10: // UNSUBSCRIBE
+			broker.mutex.Lock()
+			for rest := body[2:]; len(rest) > 2; {
+				n := int(binary.BigEndian.Uint16(rest))
+				filters := broker.subscriptions[conn][:0]
+				for _, filter := range broker.subscriptions[conn] {
+					if filter != string(rest[2:2+n]) {
+						filters = append(filters, filter)
+					}
+				}
+				broker.subscriptions[conn] = filters
+				rest = rest[2+n:]
+			}
+			broker.mutex.Unlock()
+			broker.write(conn, 0xB0, body[:2])
+		case 12: // PINGREQ
+			broker.write(conn, 0xD0, nil)
+		case 14: // DISCONNECT
+			return
+		}
+	}
+}
+
+func (broker *testBroker) forward(topic string, payload []byte) {
+	receivers := make([]net.Conn, 0)
+	broker.mutex.Lock()
+	for conn, filters := range broker.subscriptions {
+		for _, filter := range filters {
+			if matchMqttFilter(filter, topic) {
+				receivers = append(receivers, conn)
+				break
+			}
+		}
+	}
+	broker.mutex.Unlock()
+	body := binary.BigEndian.AppendUint16(nil, uint16(len(topic)))
+	body = append(append(body, topic...), payload...)
+	for _, conn := range receivers {
+		broker.write(conn, 0x30, body)
+	}
+}
+
+func matchMqttFilter(filter, topic string) bool {
+	filterLevels, topicLevels := strings.Split(filter, mqttTopicSep), strings.Split(topic, mqttTopicSep)
+	for ndx, level := range filterLevels {
+		if level == mqttWildcard {
+			return true
+		}
+		if ndx >= len(topicLevels) || (level != "+" && level != topicLevels[ndx]) {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
+
+func waitFor(t *testing.T, what string, done func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for " + what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMqttBridge(t *testing.T) {
+	broker := startTestBroker(t)
+
+	// Every message on the broker, to check the topics and payloads of the publisher.
+	var mutex sync.Mutex
+	received := make(map[string]MqttPayload, 0)
+	observer := mqtt.NewClient(mqtt.NewClientOptions().AddBroker(broker).SetClientID("observer"))
+	if token := observer.Connect(); !token.WaitTimeout(mqttConnectTimeout) || token.Error() != nil {
+		t.Fatal("observer connect: ", token.Error())
+	}
+	defer observer.Disconnect(mqttQuiesceMs)
+	observer.Subscribe(mqttWildcard, 0, func(_ mqtt.Client, message mqtt.Message) {
+		var payload MqttPayload
+		if err := json.Unmarshal(message.Payload(), &payload); err != nil {
+			t.Error(message.Topic() + ": " + err.Error())
+		}
+		mutex.Lock()
+		received[message.Topic()] = payload
+		mutex.Unlock()
+	}).Wait()
+
+	subscriber, err := NewMqttBridge(map[string]string{"broker": broker, "qos": "1", "clientid": "subscriber"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer subscriber.Close()
+	subscriber.Schema = NewMqttSchema("root.ecobee.household", []*MeasurementItem{
+		{MeasurementName: "T_ctrl", MeasurementAlias: "T_ctrl", MeasurementType: "decimal", MeasurementUnits: "F"},
+		{MeasurementName: "HvacMode", MeasurementAlias: "HvacMode", MeasurementType: "string", MeasurementUnits: "unitless", ColumnOrder: 1},
+		{MeasurementName: "Indoor Temp", MeasurementAlias: "IndoorTemp", MeasurementType: "decimal", MeasurementUnits: "F", ColumnOrder: 2},
+	})
+	subscriber.Schema.AddProfiles([]IotdbTimeseriesProfile{{Timeseries: "root.ecobee.household.known.T_ctrl", DataType: "DOUBLE", Attributes: `{"datatype":"decimal","name":"T_ctrl"}`}})
+	subscriber.conn.Subscribe("ecobee/#", 1, subscriber.onMessage).Wait()
+
+	publisher, err := NewMqttBridge(map[string]string{"broker": broker, "qos": "1", "clientid": "publisher"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer publisher.Close()
+	const timestamp = int64(1483228800000)
+	publisher.PublishFrame(SensorFrame{Device: "root.ecobee.household.abc", Timestamp: timestamp, Measurements: map[string]interface{}{"T_ctrl": 71.5, "HvacMode": "heat"}})
+	waitFor(t, "the published frame", func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return len(received) == 2
+	})
+	for topic, value := range map[string]interface{}{"ecobee/household/abc/T_ctrl": 71.5, "ecobee/household/abc/HvacMode": "heat"} {
+		if payload := received[topic]; payload.Timestamp != timestamp || payload.Value != value {
+			t.Errorf("%s: got %+v, want value %v at %d", topic, payload, value, timestamp)
+		}
+	}
+
+	observer.Unsubscribe(mqttWildcard).Wait()
+
+	for topic, body := range map[string]string{
+		"ecobee/household/abc/Indoor Temp":   `{"timestamp":1483228800000,"value":70.25}`,
+		"ecobee/household/known/T_ctrl":      `{"timestamp":1483228800000,"value":68}`,
+		"ecobee":                             `1`,
+		"ecobee/household/abc/Unknown":       `1`,
+		"ecobee/household/abc/T_ctrl":        `"warm"`,
+		"ecobee/household/other/abc/T_ctrl":  `1`,
+		"ecobee/weather/station/Temperature": `1`,
+	} {
+		publisher.conn.Publish(topic, 1, false, body).Wait()
+	}
+
+	waitFor(t, "the subscriber", func() bool {
+		subscriber.mutex.Lock()
+		defer subscriber.mutex.Unlock()
+		return len(subscriber.pending["root.ecobee.household.abc"]) == 3 && len(subscriber.rejected) == 5
+	})
+	for _, topic := range []string{"ecobee", "ecobee/household/abc/Unknown", "ecobee/household/abc/T_ctrl", "ecobee/household/other/abc/T_ctrl", "ecobee/weather/station/Temperature"} {
+		if subscriber.rejected[topic] != 1 {
+			t.Errorf("topic %s was not rejected", topic)
+		}
+	}
+	statements := subscriber.pendingStatements()
+	created := "CREATE ALIGNED TIMESERIES root.ecobee.household.abc(T_ctrl DOUBLE encoding=GORILLA compressor=SNAPPY ATTRIBUTES('datatype'='decimal', 'name'='T_ctrl') TAGS('units'='F')," +
+		"HvacMode TEXT encoding=PLAIN compressor=SNAPPY ATTRIBUTES('datatype'='string', 'name'='HvacMode') TAGS('units'='unitless')," +
+		"IndoorTemp DOUBLE encoding=GORILLA compressor=SNAPPY ATTRIBUTES('datatype'='decimal', 'name'='Indoor Temp') TAGS('units'='F'));"
+	expected := map[string][]string{
+		"root.ecobee.household.abc":   {created, "INSERT INTO root.ecobee.household.abc (time,HvacMode,IndoorTemp,T_ctrl) ALIGNED VALUES (1483228800000,'heat',70.25,71.5);"},
+		"root.ecobee.household.known": {"INSERT INTO root.ecobee.household.known (time,T_ctrl) ALIGNED VALUES (1483228800000,68);"},
+	}
+	if len(statements) != len(expected) {
+		t.Errorf("statements of %d devices, want %d: %v", len(statements), len(expected), statements)
+	}
+	for device, want := range expected {
+		if got := statements[device]; strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Errorf("%s:\n got  %v\n want %v", device, got, want)
+		}
+	}
+	if again := subscriber.pendingStatements(); len(again) != 0 {
+		t.Errorf("pending frames were not taken: %v", again)
+	}
+
+	// The statements above never ran, so abc is created with its next frames, and only until they run.
+	next := SensorFrame{Device: "root.ecobee.household.abc", Timestamp: timestamp + 1000, Measurements: map[string]interface{}{"T_ctrl": "72"}}
+	add := func() { subscriber.pending[next.Device] = append(subscriber.pending[next.Device], next) }
+	add()
+	if statements := subscriber.pendingStatements()["root.ecobee.household.abc"]; len(statements) != 2 || statements[0] != created {
+		t.Errorf("abc was marked as created before its statements ran: %v", statements)
+	}
+	subscriber.DryRun = true
+	add()
+	subscriber.flush()
+	add()
+	if statements := subscriber.pendingStatements()["root.ecobee.household.abc"]; len(statements) != 1 || strings.HasPrefix(statements[0], "CREATE") {
+		t.Errorf("abc was created again after its statements ran: %v", statements)
+	}
+}
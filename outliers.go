@@ -0,0 +1,458 @@
+package main // outliers.go runs robust outlier detectors over the numeric series of IoTDB devices.
+// netcdf analyze outliers <root.device or pattern> [detectors=mad,iqr,esd,rate] [threshold=3.5] [iqr=1.5] [season=1d]
+//                         [alpha=0.05] [maxanomalies=0.02] [start=time] [end=time] [output=report|iotdb] [report=outliers.csv]
+//   mad   modified z-score 0.6745 (x - median) / MAD above threshold
+//   iqr   below Q1 - iqr x IQR or above Q3 + iqr x IQR
+//   esd   seasonal hybrid ESD: remove the median of each phase of season, then a generalized ESD test on the residuals
+//         with the median and MAD of the series, for at most maxanomalies of the points at significance alpha
+//   rate  a change faster than the 'maxrate' attribute (units per second), the rate of its units tag, or threshold robust deviations of the rate
+// Values outside the 'min'/'max' attributes that createts records from the summary file are always outliers ("range").
+// output=iotdb flags outliers in a companion BOOLEAN series <measurement>_outlier; the report lists every outlier and its detectors.
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apache/iotdb-client-go/client"
+)
+
+const (
+	detectorMad    = "mad"
+	detectorIqr    = "iqr"
+	detectorEsd    = "esd"
+	detectorRate   = "rate"
+	detectorRange  = "range"
+	outlierSuffix  = "_outlier"
+	madConsistency = 0.6745 // MAD of a normal distribution in standard deviations
+)
+
+var outlierParameters = map[string]string{"detectors": "mad,iqr,esd,rate", "threshold": "3.5", "iqr": "1.5", "season": "1d", "alpha": "0.05", "maxanomalies": "0.02",
+	"start": "", "end": "", "output": "report", "report": ""}
+var outlierDetectors = []string{detectorMad, detectorIqr, detectorEsd, detectorRate}
+var outlierOutputs = []string{"report", "iotdb"}
+
+// Plausible rates of change per second of common units when a series has no 'maxrate' attribute.
+var unitsMaxRate = map[string]float64{"F": 0.2, "°F": 0.2, "degF": 0.2, "C": 0.1, "°C": 0.1, "degC": 0.1, "%": 1}
+
+// OutlierSpec holds the detectors and thresholds of one series.
+type OutlierSpec struct {
+	Detectors    []string
+	Threshold    float64
+	IqrFactor    float64
+	SeasonMs     int64
+	Alpha        float64
+	MaxAnomalies float64
+	Min          float64 // metadata range; -Inf/+Inf when unknown
+	Max          float64
+	MaxRate      float64 // units per second; 0 derives it from the series
+}
+
+// Outlier is one flagged sample of a series.
+type Outlier struct {
+	Device      string   `json:"device"`
+	Measurement string   `json:"measurement"`
+	Timestamp   int64    `json:"timestamp"`
+	Value       float64  `json:"value"`
+	Detectors   []string `json:"detectors"`
+}
+
+// CSV createts records the summary range of numeric measurements as ATTRIBUTES('min', 'max') for the detectors.
+func (iot *IoTDbCsvDataFile) rangeAttributes(item *MeasurementItem) string {
+	switch strings.ToLower(item.MeasurementType) {
+	case "float", "double", "decimal", "integer", "int", "int32", "int64", "longint":
+	default:
+		return ""
+	}
+	attributes := ""
+	if minimum, ok := iot.summaryFloat(item, "min"); ok {
+		attributes += ", 'min'='" + strconv.FormatFloat(minimum, 'f', -1, 64) + "'"
+	}
+	if maximum, ok := iot.summaryFloat(item, "max"); ok {
+		attributes += ", 'max'='" + strconv.FormatFloat(maximum, 'f', -1, 64) + "'"
+	}
+	return attributes
+}
+
+// Thresholds of one series from its ATTRIBUTES('min', 'max', 'maxrate') and TAGS('units').
+func outlierSpecOf(spec OutlierSpec, profile IotdbTimeseriesProfile) OutlierSpec {
+	attributes := parseKeyValuePairs(profile.Attributes)
+	spec.Min, spec.Max = math.Inf(-1), math.Inf(1)
+	if minimum, err := strconv.ParseFloat(attributes["min"], 64); err == nil {
+		spec.Min = minimum
+	}
+	if maximum, err := strconv.ParseFloat(attributes["max"], 64); err == nil {
+		spec.Max = maximum
+	}
+	spec.MaxRate = unitsMaxRate[parseKeyValuePairs(profile.Tags)[unitsName]]
+	if maxRate, err := strconv.ParseFloat(attributes["maxrate"], 64); err == nil {
+		spec.MaxRate = maxRate
+	}
+	return spec
+}
+
+func median(sorted []float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	if len(sorted)%2 == 1 {
+		return sorted[len(sorted)/2]
+	}
+	return (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+}
+
+// Linear interpolation between the closest ranks.
+func quantile(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	position := q * float64(len(sorted)-1)
+	lower := int(math.Floor(position))
+	upper := min(lower+1, len(sorted)-1)
+	return sorted[lower] + (position-float64(lower))*(sorted[upper]-sorted[lower])
+}
+
+// Median and median absolute deviation.
+func medianMad(values []float64) (float64, float64) {
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	center := median(sorted)
+	deviations := make([]float64, len(values))
+	for ndx, v := range values {
+		deviations[ndx] = math.Abs(v - center)
+	}
+	sort.Float64s(deviations)
+	return center, median(deviations)
+}
+
+// Inverse of the standard normal distribution (Acklam's rational approximation).
+func normalQuantile(p float64) float64 {
+	a := []float64{-3.969683028665376e+01, 2.209460984245205e+02, -2.759285104469687e+02, 1.383577518672690e+02, -3.066479806614716e+01, 2.506628277459239e+00}
+	b := []float64{-5.447609879822406e+01, 1.615858368580409e+02, -1.556989798598866e+02, 6.680131188771972e+01, -1.328068155288572e+01}
+	c := []float64{-7.784894002430293e-03, -3.223964580411365e-01, -2.400758277161838e+00, -2.549732539343734e+00, 4.374664141464968e+00, 2.938163982698783e+00}
+	d := []float64{7.784695709041462e-03, 3.224671290700398e-01, 2.445134137142996e+00, 3.754408661907416e+00}
+	const low = 0.02425
+	switch {
+	case p < low:
+		q := math.Sqrt(-2 * math.Log(p))
+		return (((((c[0]*q+c[1])*q+c[2])*q+c[3])*q+c[4])*q + c[5]) / ((((d[0]*q+d[1])*q+d[2])*q+d[3])*q + 1)
+	case p > 1-low:
+		q := math.Sqrt(-2 * math.Log(1-p))
+		return -(((((c[0]*q+c[1])*q+c[2])*q+c[3])*q+c[4])*q + c[5]) / ((((d[0]*q+d[1])*q+d[2])*q+d[3])*q + 1)
+	}
+	q := p - 0.5
+	r := q * q
+	return (((((a[0]*r+a[1])*r+a[2])*r+a[3])*r+a[4])*r + a[5]) * q / (((((b[0]*r+b[1])*r+b[2])*r+b[3])*r+b[4])*r + 1)
+}
+
+// Inverse of Student's t distribution with df degrees of freedom (Cornish-Fisher expansion of the normal quantile).
+func studentQuantile(p, df float64) float64 {
+	z := normalQuantile(p)
+	z3, z5, z7 := z*z*z, math.Pow(z, 5), math.Pow(z, 7)
+	return z + (z3+z)/(4*df) + (5*z5+16*z3+3*z)/(96*df*df) + (3*z7+19*z5+17*z3-15*z)/(384*df*df*df)
+}
+
+// Indices of the samples that the detectors of spec flag, with the detectors that flagged each.
+func DetectOutliers(samples []Sample, spec OutlierSpec) map[int][]string {
+	flagged := make(map[int][]string, 0)
+	flag := func(ndx int, detector string) {
+		if !contains(flagged[ndx], detector) {
+			flagged[ndx] = append(flagged[ndx], detector)
+		}
+	}
+	values := make([]float64, len(samples))
+	for ndx, sample := range samples {
+		values[ndx], _ = numericValue(sample.Value)
+		if values[ndx] < spec.Min || values[ndx] > spec.Max {
+			flag(ndx, detectorRange)
+		}
+	}
+	if len(values) < 3 {
+		return flagged
+	}
+	center, mad := medianMad(values)
+	for _, detector := range spec.Detectors {
+		switch detector {
+		case detectorMad:
+			if mad == 0 {
+				continue
+			}
+			for ndx, v := range values {
+				if math.Abs(madConsistency*(v-center)/mad) > spec.Threshold {
+					flag(ndx, detector)
+				}
+			}
+		case detectorIqr:
+			sorted := append([]float64{}, values...)
+			sort.Float64s(sorted)
+			q1, q3 := quantile(sorted, 0.25), quantile(sorted, 0.75)
+			low, high := q1-spec.IqrFactor*(q3-q1), q3+spec.IqrFactor*(q3-q1)
+			for ndx, v := range values {
+				if v < low || v > high {
+					flag(ndx, detector)
+				}
+			}
+		case detectorEsd:
+			for _, ndx := range seasonalHybridEsd(samples, values, spec) {
+				flag(ndx, detector)
+			}
+		case detectorRate:
+			for _, ndx := range rateOutliers(samples, values, spec) {
+				flag(ndx, detector)
+			}
+		}
+	}
+	return flagged
+}
+
+// Seasonal hybrid ESD. The median and MAD of all residuals stand in for those of the remaining points at every step,
+// so the i-th test statistic is simply the i-th largest deviation.
+func seasonalHybridEsd(samples []Sample, values []float64, spec OutlierSpec) []int {
+	residuals := append([]float64{}, values...)
+	intervalMs := InferInterval(samples)
+	if spec.SeasonMs > 0 && intervalMs > 0 && spec.SeasonMs/intervalMs >= 2 {
+		phases := make(map[int64][]float64, 0)
+		for ndx, sample := range samples {
+			phase := (sample.Timestamp % spec.SeasonMs) / intervalMs
+			phases[phase] = append(phases[phase], values[ndx])
+		}
+		seasonal := make(map[int64]float64, len(phases))
+		for phase, phaseValues := range phases {
+			sort.Float64s(phaseValues)
+			seasonal[phase] = median(phaseValues)
+		}
+		for ndx, sample := range samples {
+			residuals[ndx] -= seasonal[(sample.Timestamp%spec.SeasonMs)/intervalMs]
+		}
+	}
+	center, mad := medianMad(residuals)
+	if mad == 0 {
+		return nil
+	}
+	order := make([]int, len(residuals))
+	for ndx := range order {
+		order[ndx] = ndx
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return math.Abs(residuals[order[i]]-center) > math.Abs(residuals[order[j]]-center)
+	})
+	n := float64(len(residuals))
+	anomalies := 0
+	for i := 1; i <= int(spec.MaxAnomalies*n) && n-float64(i)-1 > 0; i++ {
+		statistic := math.Abs(residuals[order[i-1]]-center) / (mad / madConsistency)
+		p := 1 - spec.Alpha/(2*(n-float64(i)+1))
+		t := studentQuantile(p, n-float64(i)-1)
+		critical := (n - float64(i)) * t / math.Sqrt((n-float64(i)-1+t*t)*(n-float64(i)+1))
+		if statistic > critical {
+			anomalies = i
+		}
+	}
+	return order[:anomalies]
+}
+
+// Samples reached faster than spec.MaxRate units per second from the previous sample, or, without a MaxRate,
+// with a rate of change more than threshold robust standard deviations from the median rate.
+func rateOutliers(samples []Sample, values []float64, spec OutlierSpec) []int {
+	rates := make([]float64, len(samples))
+	for ndx := 1; ndx < len(samples); ndx++ {
+		if seconds := float64(samples[ndx].Timestamp-samples[ndx-1].Timestamp) / 1000; seconds > 0 {
+			rates[ndx] = (values[ndx] - values[ndx-1]) / seconds
+		}
+	}
+	center, maxRate := 0.0, spec.MaxRate
+	if maxRate <= 0 {
+		var mad float64
+		center, mad = medianMad(rates[1:])
+		if mad == 0 {
+			return nil
+		}
+		maxRate = spec.Threshold * mad / madConsistency
+	}
+	outliers := make([]int, 0)
+	for ndx := 1; ndx < len(samples); ndx++ {
+		if math.Abs(rates[ndx]-center) > maxRate {
+			outliers = append(outliers, ndx)
+		}
+	}
+	return outliers
+}
+
+// Create the missing <measurement>_outlier series and set them true at each outlier.
+func writeOutlierFlags(session *client.Session, device string, columns []ResampleColumn, outliers []Outlier, detectors []string) error {
+	existing, err := GetTimeseriesProfiles(session, device+".*")
+	if err != nil {
+		return err
+	}
+	created := make(map[string]bool, 0)
+	for _, profile := range existing {
+		created[profile.Timeseries] = true
+	}
+	_, encoding, compressor := getClientStorage("boolean")
+	var sb strings.Builder
+	for _, column := range columns {
+		if created[device+iotdbPathSep+column.MeasurementAlias+outlierSuffix] {
+			continue
+		}
+		attributes := " ATTRIBUTES('datatype'='boolean', 'name'='" + strings.ReplaceAll(column.MeasurementName, "'", "''") + " outlier', 'detectors'='" + strings.Join(detectors, ",") + "') TAGS('units'='unitless')"
+		sb.WriteString(column.MeasurementAlias + outlierSuffix + " BOOLEAN encoding=" + encoding + " compressor=" + compressor + attributes + ",")
+	}
+	if sb.Len() > 0 {
+		sql := "CREATE ALIGNED TIMESERIES " + device + "(" + strings.TrimSuffix(sb.String(), ",") + ");"
+		if _, err := session.ExecuteNonQueryStatement(sql); err != nil {
+			return errors.New(sql + ": " + err.Error())
+		}
+	}
+	blockSize := getBlockSize(len(columns))
+	statements := make([]string, 0, blockSize)
+	for _, outlier := range outliers {
+		statements = append(statements, alignedInsertStatement(device, outlier.Timestamp, []string{outlier.Measurement + outlierSuffix}, []string{"true"}))
+		if len(statements) == blockSize {
+			if _, err := session.ExecuteBatchStatement(statements); err != nil {
+				return err
+			}
+			statements = statements[:0]
+		}
+	}
+	if len(statements) > 0 {
+		if _, err := session.ExecuteBatchStatement(statements); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeOutlierReport(fileName string, outliers []Outlier) error {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	csvWriter := csv.NewWriter(file)
+	_ = csvWriter.Write([]string{"device", "measurement", "time", "value", "detectors"})
+	for _, outlier := range outliers {
+		_ = csvWriter.Write([]string{outlier.Device, outlier.Measurement, formatMilliseconds(outlier.Timestamp), strconv.FormatFloat(outlier.Value, 'f', -1, 64), strings.Join(outlier.Detectors, "+")})
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// Companion series written by gaps, inject and outliers, and the label and type annotations, are not analyzed again.
+func isCompanionSeries(alias string) bool {
+	return strings.HasSuffix(alias, filledSuffix) || strings.HasSuffix(alias, outlierSuffix) || strings.EqualFold(alias, labelColumnName) || strings.EqualFold(alias, typeColumnName)
+}
+
+// programArgs: analyze outliers <root.device or pattern> [detectors=mad,iqr,esd,rate] [threshold=3.5] [iqr=1.5] [season=1d] [alpha=0.05] [maxanomalies=0.02] [start=] [end=] [output=report|iotdb] [report=]
+func ProcessAnalyze(programArgs []string) {
+	if len(programArgs) < 4 || programArgs[2] != "outliers" || !strings.HasPrefix(programArgs[3], "root.") {
+		checkErr("analyze", errors.New("expected: analyze outliers <root.device> [detectors=mad,iqr,esd,rate] [output=report|iotdb] [report=outliers.csv]"))
+	}
+	parameters := getProgramParameters(programArgs, outlierParameters)
+	spec := OutlierSpec{Detectors: splitList(strings.ToLower(parameters["detectors"]))}
+	for _, detector := range spec.Detectors {
+		if !contains(outlierDetectors, detector) {
+			checkErr("analyze", errors.New("detectors must be among "+strings.Join(outlierDetectors, ", ")+": "+detector))
+		}
+	}
+	var err error
+	spec.Threshold, err = strconv.ParseFloat(parameters["threshold"], 64)
+	checkErr("analyze(threshold)", err)
+	spec.IqrFactor, err = strconv.ParseFloat(parameters["iqr"], 64)
+	checkErr("analyze(iqr)", err)
+	spec.SeasonMs, err = ParseInterval(parameters["season"])
+	checkErr("analyze(season)", err)
+	spec.Alpha, err = strconv.ParseFloat(parameters["alpha"], 64)
+	if err != nil || spec.Alpha <= 0 || spec.Alpha >= 1 {
+		checkErr("analyze", errors.New("alpha must be in (0, 1): "+parameters["alpha"]))
+	}
+	spec.MaxAnomalies, err = strconv.ParseFloat(parameters["maxanomalies"], 64)
+	if err != nil || spec.MaxAnomalies <= 0 || spec.MaxAnomalies > 0.5 {
+		checkErr("analyze", errors.New("maxanomalies must be a fraction in (0, 0.5]: "+parameters["maxanomalies"]))
+	}
+	output := strings.ToLower(parameters["output"])
+	if !contains(outlierOutputs, output) {
+		checkErr("analyze", errors.New("output must be one of "+strings.Join(outlierOutputs, ", ")))
+	}
+	startTime, err := parseReplayTime(parameters["start"], 0)
+	checkErr("analyze(start)", err)
+	endTime, err := parseReplayTime(parameters["end"], time.Now().UTC().UnixMilli()+1)
+	checkErr("analyze(end)", err)
+
+	iotdbConnection, ok := Init_IoTDB(true)
+	if !ok {
+		checkErr("Init_IoTDB: ", errors.New(iotdbConnection))
+	}
+	session := client.NewSession(clientConfig)
+	err = session.Open(false, 0)
+	checkErr("Open", err)
+	defer session.Close()
+
+	devices := []IotdbDevice{{Device: programArgs[3]}}
+	if strings.Contains(programArgs[3], anyOneNode) {
+		devices, err = ShowDevices(&session, programArgs[3])
+		checkErr("ShowDevices", err)
+	}
+	report := make([]Outlier, 0)
+	fmt.Printf("%-48s %-32s %10s %9s  %s\n", "device", "measurement", "samples", "outliers", "by detector")
+	for _, device := range devices {
+		profiles, err := GetTimeseriesProfiles(&session, device.Device+".*")
+		checkErr("GetTimeseriesProfiles", err)
+		exported, err := exportColumns(profiles, nil, false, nil)
+		checkErr("analyze("+device.Device+")", err)
+		columns := make([]ResampleColumn, 0, len(exported))
+		for _, column := range exported {
+			switch column.IotdbType {
+			case "DOUBLE", "FLOAT", "INT32", "INT64":
+				if !isCompanionSeries(column.MeasurementAlias) {
+					columns = append(columns, ResampleColumn{ExportColumn: column})
+				}
+			}
+		}
+		if len(columns) == 0 {
+			continue
+		}
+		err = readDeviceSamples(&session, device.Device, columns, startTime, endTime)
+		checkErr("readDeviceSamples", err)
+
+		deviceOutliers := make([]Outlier, 0)
+		for _, column := range columns {
+			flagged := DetectOutliers(column.Samples, outlierSpecOf(spec, profiles[column.ColumnOrder]))
+			byDetector := make(map[string]int, 0)
+			indices := make([]int, 0, len(flagged))
+			for ndx, detectors := range flagged {
+				indices = append(indices, ndx)
+				for _, detector := range detectors {
+					byDetector[detector]++
+				}
+			}
+			sort.Ints(indices)
+			for _, ndx := range indices {
+				value, _ := numericValue(column.Samples[ndx].Value)
+				deviceOutliers = append(deviceOutliers, Outlier{Device: device.Device, Measurement: column.MeasurementAlias, Timestamp: column.Samples[ndx].Timestamp, Value: value, Detectors: flagged[ndx]})
+			}
+			counts := make([]string, 0, len(byDetector))
+			for _, detector := range append([]string{detectorRange}, outlierDetectors...) {
+				if byDetector[detector] > 0 {
+					counts = append(counts, detector+"="+strconv.Itoa(byDetector[detector]))
+				}
+			}
+			fmt.Printf("%-48s %-32s %10d %9d  %s\n", device.Device, column.MeasurementAlias, len(column.Samples), len(indices), strings.Join(counts, " "))
+		}
+		report = append(report, deviceOutliers...)
+		if output == "iotdb" && len(deviceOutliers) > 0 {
+			err = writeOutlierFlags(&session, device.Device, columns, deviceOutliers, spec.Detectors)
+			checkErr("writeOutlierFlags", err)
+			fmt.Println("IOTDB TEST QUERY: SELECT * FROM " + device.Device + " WHERE " + deviceOutliers[0].Measurement + outlierSuffix + " = true LIMIT 2;")
+		}
+	}
+	if len(parameters["report"]) > 0 {
+		err = writeOutlierReport(parameters["report"], report)
+		checkErr("writeOutlierReport", err)
+		fmt.Println("Outliers written to " + parameters["report"])
+	}
+}
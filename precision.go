@@ -0,0 +1,97 @@
+package main // precision.go converts between the unix milliseconds of frames, samples and time parameters and the timestamps stored in IoTDB.
+// The unit of IoTDB timestamps is the server's timestamp_precision (iotdb-system.properties): ms (the default), us or ns. It cannot change once
+// data is written, so set IOTDB_TIMESTAMP_PRECISION in netcdf.env to the same value (or pass precision=us to every command).
+// Data files are inserted at full precision: epoch integers in s, ms, µs or ns and ISO times with fractional seconds (filesystem/timestamp.go)
+// keep every digit the server can store, and query and export print them back with as many decimals as the precision has.
+// Streams, replay and the analysis commands keep working in milliseconds; only the SQL and SessionDataSet boundaries convert.
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"filesystem" // work module
+)
+
+const (
+	precisionMilliseconds = "ms"
+	precisionMicroseconds = "us"
+	precisionNanoseconds  = "ns"
+)
+
+// IoTDB timestamp units per millisecond.
+var iotdbPrecisionUnits = map[string]int64{precisionMilliseconds: 1, precisionMicroseconds: 1000, precisionNanoseconds: 1000000}
+
+// Time column layouts of exported and queried rows; RFC 3339 with the decimals of the precision.
+var iotdbPrecisionLayouts = map[string]string{
+	precisionMilliseconds: filesystem.TimeFormatNano,
+	precisionMicroseconds: filesystem.DateFormat + "T15:04:05.000000Z07:00",
+	precisionNanoseconds:  filesystem.DateFormat + "T15:04:05.000000000Z07:00",
+}
+
+var iotdbPrecision = precisionMilliseconds
+
+// Read IOTDB_TIMESTAMP_PRECISION; a precision=ms|us|ns program parameter overrides it.
+func configureIotdbPrecision(programArgs []string) error {
+	precision := os.Getenv("IOTDB_TIMESTAMP_PRECISION")
+	for _, arg := range programArgs {
+		if value, found := strings.CutPrefix(arg, "precision="); found {
+			precision = value
+		}
+	}
+	if len(precision) == 0 {
+		return nil
+	}
+	if _, ok := iotdbPrecisionUnits[precision]; !ok {
+		return errors.New("timestamp precision must be one of ms, us, ns: " + precision)
+	}
+	iotdbPrecision = precision
+	return nil
+}
+
+// The IoTDB timestamp of t; digits below the precision are truncated.
+func iotdbTimestamp(t time.Time) int64 {
+	switch iotdbPrecision {
+	case precisionMicroseconds:
+		return t.UnixMicro()
+	case precisionNanoseconds:
+		return t.UnixNano()
+	}
+	return t.UnixMilli()
+}
+
+func iotdbTime(timestamp int64) time.Time {
+	switch iotdbPrecision {
+	case precisionMicroseconds:
+		return time.UnixMicro(timestamp).UTC()
+	case precisionNanoseconds:
+		return time.Unix(0, timestamp).UTC()
+	}
+	return time.UnixMilli(timestamp).UTC()
+}
+
+// Unix milliseconds to an IoTDB timestamp.
+func msToIotdb(timestamp int64) int64 {
+	return timestamp * iotdbPrecisionUnits[iotdbPrecision]
+}
+
+// An IoTDB timestamp to unix milliseconds (rounded down, so a sample stays inside its millisecond).
+func iotdbToMs(timestamp int64) int64 {
+	units := iotdbPrecisionUnits[iotdbPrecision]
+	if timestamp < 0 && timestamp%units != 0 {
+		return timestamp/units - 1
+	}
+	return timestamp / units
+}
+
+// RFC 3339 UTC time of an IoTDB timestamp, e.g. 2016-01-01T00:00:00.123456Z for us.
+func formatIotdbTimestamp(timestamp int64) string {
+	return iotdbTime(timestamp).Format(iotdbPrecisionLayouts[iotdbPrecision])
+}
+
+// SQL time range of [startTime, endTime) unix milliseconds.
+func iotdbTimeRange(startTime, endTime int64) string {
+	return " WHERE time >= " + strconv.FormatInt(msToIotdb(startTime), 10) + " AND time < " + strconv.FormatInt(msToIotdb(endTime), 10)
+}
@@ -0,0 +1,238 @@
+package main // query.go runs IoTDB SQL from the command line and prints the result set as an aligned table, CSV or JSON lines.
+// netcdf query "SELECT * FROM root.ecobee.household.<id> LIMIT 2" [format=table|csv|json] [fetchsize=1024]
+// The "IOTDB TEST QUERY:" lines printed by createts and insert can be pasted as they are; several statements may be separated by ';'.
+// netcdf describe root.ecobee.household.* lists the devices of a pattern and the names, types, attributes and units of their time series.
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/apache/iotdb-client-go/client"
+)
+
+const (
+	testQueryPrefix = "IOTDB TEST QUERY:"
+	nullText        = "null"
+	tableColumnGap  = "  "
+)
+
+var queryParameters = map[string]string{"format": "table", "fetchsize": "1024"}
+var queryFormats = []string{"table", "csv", "json"}
+
+// Split pasted SQL into statements at the semicolons outside quotes ('a;b', "a;b", `a;b`), dropping the test query prefix.
+func splitStatements(sql string) []string {
+	statements := make([]string, 0)
+	quote := rune(0) // the open quote; a doubled quote ('') closes and reopens it
+	start := 0
+	sql = strings.ReplaceAll(sql, testQueryPrefix, "")
+	for ndx, r := range sql + ";" {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+		case r == ';':
+			if statement := strings.TrimSpace(sql[start:min(ndx, len(sql))]); len(statement) > 0 {
+				statements = append(statements, statement)
+			}
+			start = ndx + 1
+		}
+	}
+	if statement := strings.TrimSpace(sql[min(start, len(sql)):]); quote != 0 && len(statement) > 0 {
+		statements = append(statements, statement) // an unterminated quote: IoTDB reports it
+	}
+	return statements
+}
+
+// Column names of the result set, starting with Time unless the statement has no timestamps (SHOW, COUNT ...).
+func dataSetColumns(sds *client.SessionDataSet) []string {
+	columns := make([]string, 0, sds.GetColumnCount()+1)
+	if !sds.IsIgnoreTimeStamp() {
+		columns = append(columns, client.TimestampColumnName)
+	}
+	for ndx := 0; ndx < sds.GetColumnCount(); ndx++ {
+		columns = append(columns, sds.GetColumnName(ndx))
+	}
+	return columns
+}
+
+// Text of the current row; nil values are "" so each format chooses its own null.
+func dataSetRow(sds *client.SessionDataSet, columns []string) []string {
+	row := make([]string, 0, len(columns))
+	for _, columnName := range columns {
+		if columnName == client.TimestampColumnName {
+			row = append(row, formatIotdbTimestamp(sds.GetTimestamp()))
+			continue
+		}
+		if sds.GetValue(columnName) == nil {
+			row = append(row, "")
+			continue
+		}
+		row = append(row, sds.GetText(columnName))
+	}
+	return row
+}
+
+// Print every row of sds, fetching it from IoTDB page by page. Returns the number of rows.
+// A table is aligned to the widest value of its first page of pageSize rows.
+func printDataSet(sds *client.SessionDataSet, format string, pageSize int, w io.Writer) (int, error) {
+	columns := dataSetColumns(sds)
+	rowCount := 0
+	switch format {
+	case "csv":
+		csvWriter := csv.NewWriter(w)
+		_ = csvWriter.Write(columns)
+		for next, err := sds.Next(); next || err != nil; next, err = sds.Next() {
+			if err != nil {
+				return rowCount, err
+			}
+			_ = csvWriter.Write(dataSetRow(sds, columns))
+			rowCount++
+			if rowCount%pageSize == 0 {
+				csvWriter.Flush()
+			}
+		}
+		csvWriter.Flush()
+		return rowCount, csvWriter.Error()
+
+	case "json":
+		encoder := json.NewEncoder(w)
+		for next, err := sds.Next(); next || err != nil; next, err = sds.Next() {
+			if err != nil {
+				return rowCount, err
+			}
+			line := make(map[string]interface{}, len(columns))
+			for _, columnName := range columns {
+				if columnName == client.TimestampColumnName {
+					line[columnName] = sds.GetTimestamp()
+				} else {
+					line[columnName] = sds.GetValue(columnName)
+				}
+			}
+			if err := encoder.Encode(line); err != nil {
+				return rowCount, err
+			}
+			rowCount++
+		}
+		return rowCount, nil
+	}
+
+	widths := make([]int, len(columns))
+	for ndx, columnName := range columns {
+		widths[ndx] = utf8.RuneCountInString(columnName)
+	}
+	page := make([][]string, 0, pageSize)
+	printRow := func(row []string) {
+		cells := make([]string, len(row))
+		for ndx, cell := range row {
+			if len(cell) == 0 {
+				cell = nullText
+			}
+			cells[ndx] = cell + strings.Repeat(" ", max(0, widths[ndx]-utf8.RuneCountInString(cell)))
+		}
+		fmt.Fprintln(w, strings.TrimRight(strings.Join(cells, tableColumnGap), " "))
+	}
+	flushPage := func() {
+		if rowCount <= pageSize { // first page: size the columns, then print the header.
+			for _, row := range page {
+				for ndx, cell := range row {
+					widths[ndx] = max(widths[ndx], max(utf8.RuneCountInString(cell), len(nullText)))
+				}
+			}
+			printRow(columns)
+			separators := make([]string, len(columns))
+			for ndx := range columns {
+				separators[ndx] = strings.Repeat("-", widths[ndx])
+			}
+			fmt.Fprintln(w, strings.Join(separators, tableColumnGap))
+		}
+		for _, row := range page {
+			printRow(row)
+		}
+		page = page[:0]
+	}
+	for next, err := sds.Next(); next || err != nil; next, err = sds.Next() {
+		if err != nil {
+			return rowCount, err
+		}
+		page = append(page, dataSetRow(sds, columns))
+		rowCount++
+		if len(page) == pageSize {
+			flushPage()
+		}
+	}
+	if len(page) > 0 || rowCount == 0 {
+		flushPage()
+	}
+	return rowCount, nil
+}
+
+// Execute each statement and print its result set to w.
+func (iot *IoTDbAccess) ExecuteQueries(sql, format string, pageSize int, w io.Writer) error {
+	timeout := tailQueryTimeout
+	for _, statement := range splitStatements(sql) {
+		iot.Sql = statement
+		sds, err := iot.session.ExecuteQueryStatement(iot.Sql, &timeout)
+		if err != nil {
+			return errors.New(iot.Sql + ": " + err.Error())
+		}
+		rowCount, err := printDataSet(sds, format, pageSize, w)
+		sds.Close()
+		if err != nil {
+			return errors.New(iot.Sql + ": " + err.Error())
+		}
+		if format == "table" {
+			fmt.Fprintln(w, "("+strconv.Itoa(rowCount)+" rows)")
+		}
+	}
+	return nil
+}
+
+// programArgs: query "<IoTDB SQL>" [format=table|csv|json] [fetchsize=1024]
+func ProcessQuery(programArgs []string) {
+	if len(programArgs) < 3 {
+		checkErr("query", errors.New("expected: query \"<IoTDB SQL>\" [format=table|csv|json] [fetchsize=1024]"))
+	}
+	parameters := getProgramParameters(programArgs, queryParameters)
+	format := strings.ToLower(parameters["format"])
+	if !contains(queryFormats, format) {
+		checkErr("query", errors.New("format must be one of "+strings.Join(queryFormats, ", ")))
+	}
+	fetchSize, err := strconv.Atoi(parameters["fetchsize"])
+	if err != nil || fetchSize <= 0 {
+		checkErr("query", errors.New("fetchsize must be a positive number of rows: "+parameters["fetchsize"]))
+	}
+	iotdbConnection, ok := Init_IoTDB(true)
+	if !ok {
+		checkErr("Init_IoTDB: ", errors.New(iotdbConnection))
+	}
+	clientConfig.FetchSize = int32(fetchSize) // rows fetched from IoTDB per page of the SessionDataSet
+
+	var iot IoTDbAccess
+	iot.session = client.NewSession(clientConfig)
+	err = iot.session.Open(false, 0)
+	checkErr("Open", err)
+	iot.ActiveSession = true
+	defer iot.session.Close()
+	err = iot.ExecuteQueries(programArgs[2], format, fetchSize, os.Stdout)
+	checkErr("ExecuteQueries", err)
+}
+
+// programArgs: describe <root.device|pattern> [format=table|csv|json] : the devices of the pattern, then their time series with ATTRIBUTES and TAGS.
+func ProcessDescribe(programArgs []string) {
+	if len(programArgs) < 3 {
+		checkErr("describe", errors.New("expected: describe <root.device or pattern> [format=table|csv|json]"))
+	}
+	pattern := strings.TrimSpace(programArgs[2])
+	sql := "SHOW DEVICES " + pattern + " WITH DATABASE; SHOW TIMESERIES " + pattern + ".*"
+	ProcessQuery(append([]string{programArgs[0], "query", sql}, programArgs[3:]...))
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		sql      string
+		expected []string
+	}{
+		{"SELECT * FROM root.a.b LIMIT 2;", []string{"SELECT * FROM root.a.b LIMIT 2"}},
+		{"IOTDB TEST QUERY: SELECT COUNT(*) FROM root.a.*; IOTDB TEST QUERY: SELECT * FROM root.a.* LIMIT 2;",
+			[]string{"SELECT COUNT(*) FROM root.a.*", "SELECT * FROM root.a.* LIMIT 2"}},
+		{"SELECT s FROM root.a.b WHERE s = 'a;b'; SHOW DEVICES", []string{"SELECT s FROM root.a.b WHERE s = 'a;b'", "SHOW DEVICES"}},
+		{`SELECT s FROM root.a.b WHERE s = "a;b"`, []string{`SELECT s FROM root.a.b WHERE s = "a;b"`}},
+		{"SELECT `a;b` FROM root.x;;", []string{"SELECT `a;b` FROM root.x"}},
+		{"SELECT s FROM root.a.b WHERE s = 'it''s;ok'; SHOW DATABASES", []string{"SELECT s FROM root.a.b WHERE s = 'it''s;ok'", "SHOW DATABASES"}},
+		{"SELECT s FROM root.a.b WHERE s = 'open;", []string{"SELECT s FROM root.a.b WHERE s = 'open;"}},
+		{" ; ", []string{}},
+	}
+	for _, test := range tests {
+		if got := splitStatements(test.sql); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("splitStatements(%q)\n got  %q\n want %q", test.sql, got, test.expected)
+		}
+	}
+}
@@ -0,0 +1,423 @@
+package main // replay.go replays an ingested dataset as if it were a live sensor stream for digital-twin simulations.
+// Pacing uses the original timestamps divided by a speed factor: speed=1 is real time, speed=60 plays an hour per minute, speed=0 is as fast as possible.
+// Frames come from IoTDB (source is a root.* device pattern) or directly from the source CSV/NC files through the IoTDbCsvDataFile and NetCDF loaders.
+// Controls (start, pause, resume, stop, seek, speed) are accepted from WebSocket clients of the serve command.
+
+import (
+	"errors"
+	"filesystem" // work module
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apache/iotdb-client-go/client"
+)
+
+const (
+	replayAsFastAsPossible = 0.0
+	actionStart            = "start"
+	actionPause            = "pause"
+	actionResume           = "resume"
+	actionStop             = "stop"
+	actionSeek             = "seek"
+	actionSpeed            = "speed"
+)
+
+var replayParameters = map[string]string{"speed": "1", "from": "", "to": "", "clock": "wall"}
+
+// ReplayClock decides how long a replay waits between frames. The SimulatedClock is deterministic.
+type ReplayClock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// WallClock waits in real time.
+type WallClock struct{}
+
+func (WallClock) Now() time.Time                         { return time.Now() }
+func (WallClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// SimulatedClock never waits: After() advances the clock by exactly d so a replay produces the same sequence every run.
+type SimulatedClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func NewSimulatedClock(start time.Time) *SimulatedClock {
+	return &SimulatedClock{now: start}
+}
+
+func (sc *SimulatedClock) Now() time.Time {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	return sc.now
+}
+
+func (sc *SimulatedClock) After(d time.Duration) <-chan time.Time {
+	sc.mutex.Lock()
+	sc.now = sc.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- sc.now
+	sc.mutex.Unlock()
+	return ch
+}
+
+type replayCommand struct {
+	Action string
+	Time   int64   // seek target, unix milliseconds
+	Speed  float64 // new speed factor
+}
+
+// DatasetReplay publishes Frames (sorted by Timestamp) in dataset-time order.
+type DatasetReplay struct {
+	Source    string            `json:"source"`
+	Frames    []SensorFrame     `json:"-"`
+	Speed     float64           `json:"speed"`
+	Clock     ReplayClock       `json:"-"`
+	Publish   func(SensorFrame) `json:"-"`
+	StopAtEnd bool              `json:"stopatend"` // return from Run() after the last frame instead of pausing.
+	Units     map[string]string `json:"units"`     // measurement => units, for the subscription units filter.
+	position  int
+	paused    bool
+	commands  chan replayCommand
+	done      chan struct{} // closed when Run() returns.
+}
+
+func NewDatasetReplay(source string, frames []SensorFrame, speed float64, clock ReplayClock, publish func(SensorFrame)) *DatasetReplay {
+	sort.SliceStable(frames, func(i, j int) bool { return frames[i].Timestamp < frames[j].Timestamp })
+	return &DatasetReplay{Source: source, Frames: frames, Speed: speed, Clock: clock, Publish: publish, paused: true, commands: make(chan replayCommand, 16), done: make(chan struct{})}
+}
+
+// Return false if the replay has already stopped.
+func (rp *DatasetReplay) send(cmd replayCommand) bool {
+	select {
+	case rp.commands <- cmd:
+		return true
+	case <-rp.done:
+		return false
+	}
+}
+
+func (rp *DatasetReplay) Start() bool  { return rp.send(replayCommand{Action: actionStart}) }
+func (rp *DatasetReplay) Pause() bool  { return rp.send(replayCommand{Action: actionPause}) }
+func (rp *DatasetReplay) Resume() bool { return rp.send(replayCommand{Action: actionResume}) }
+func (rp *DatasetReplay) Stop() bool   { return rp.send(replayCommand{Action: actionStop}) }
+
+// Continue from the first frame at or after t.
+func (rp *DatasetReplay) Seek(t time.Time) bool {
+	return rp.send(replayCommand{Action: actionSeek, Time: t.UTC().UnixMilli()})
+}
+
+func (rp *DatasetReplay) SetSpeed(speed float64) bool {
+	return rp.send(replayCommand{Action: actionSpeed, Speed: speed})
+}
+
+// Apply one control; return false on stop.
+func (rp *DatasetReplay) apply(cmd replayCommand) bool {
+	switch cmd.Action {
+	case actionStart:
+		rp.position = 0
+		rp.paused = false
+	case actionPause:
+		rp.paused = true
+	case actionResume:
+		rp.paused = false
+	case actionSeek:
+		rp.position = sort.Search(len(rp.Frames), func(i int) bool { return rp.Frames[i].Timestamp >= cmd.Time })
+	case actionSpeed:
+		if cmd.Speed >= 0 {
+			rp.Speed = cmd.Speed
+		}
+	case actionStop:
+		return false
+	}
+	return true
+}
+
+// Wall time to wait before publishing frame at position.
+func (rp *DatasetReplay) delay(position int) time.Duration {
+	if position == 0 || rp.Speed <= replayAsFastAsPossible {
+		return 0
+	}
+	gap := rp.Frames[position].Timestamp - rp.Frames[position-1].Timestamp
+	return time.Duration(float64(gap) * float64(time.Millisecond) / rp.Speed)
+}
+
+// Blocks until Stop(), or until the last frame is published when StopAtEnd. The replay starts paused; call Start() or Resume().
+func (rp *DatasetReplay) Run() {
+	defer close(rp.done)
+	fmt.Printf("%s%d%s", "Replaying "+rp.Source+": ", len(rp.Frames), " frames.\n")
+	for {
+		if rp.paused || rp.position >= len(rp.Frames) {
+			if rp.position >= len(rp.Frames) && !rp.paused {
+				fmt.Println("Replay of " + rp.Source + " finished.")
+				if rp.StopAtEnd {
+					return
+				}
+				rp.paused = true
+			}
+			if !rp.apply(<-rp.commands) {
+				return
+			}
+			continue
+		}
+		select {
+		case cmd := <-rp.commands:
+			if !rp.apply(cmd) {
+				return
+			}
+		case <-rp.Clock.After(rp.delay(rp.position)):
+			rp.Publish(rp.Frames[rp.position])
+			rp.position++
+		}
+	}
+}
+
+// Convert a CSV field into the Go value matching its XSD data type; nil if empty.
+func parseDataItem(s, dataType string) interface{} {
+	s = strings.TrimSpace(s)
+	if len(s) == 0 {
+		return nil
+	}
+	switch strings.ToLower(dataType) {
+	case "decimal", "double", "float":
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	case "integer", "int", "int32", "longint", "int64":
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(s); err == nil {
+			return b
+		}
+	}
+	return s
+}
+
+// One frame per data row; the device is the dataset itself.
+func (iot *IoTDbCsvDataFile) ReplayFrames() ([]SensorFrame, error) {
+	timeIndex := iot.GetRowNumberFromName(iot.TimeMeasurementName) - 1
+	if timeIndex < 0 {
+		return nil, errors.New("time column not found in summary file: " + iot.TimeMeasurementName)
+	}
+	device := IotDatasetPrefix(iot.Identifier, iot.DatasetName)
+	frames := make([]SensorFrame, 0, len(iot.Dataset))
+	for r := 1; r < len(iot.Dataset); r++ {
+		startTime, err := iot.TimeParser.Parse(iot.Dataset[r][timeIndex])
+		if err != nil {
+			fmt.Println("Bad start time: <" + iot.Dataset[r][timeIndex] + ">")
+			continue
+		}
+		frame := SensorFrame{Device: device, Timestamp: startTime.UTC().UnixMilli(), Measurements: make(map[string]interface{}, 0)}
+		for _, item := range iot.Measurements {
+			if item.Ignore || item.ColumnOrder >= len(iot.Dataset[r]) || item.MeasurementName == LastColumnName {
+				continue
+			}
+			value := parseDataItem(iot.Dataset[r][item.ColumnOrder], item.MeasurementType)
+			if value != nil {
+				frame.Measurements[item.MeasurementAlias] = value
+			}
+		}
+		frames = append(frames, frame)
+	}
+	return frames, nil
+}
+
+// Measurement alias => units, as replayed in frames.
+func (iot *IoTDbCsvDataFile) MeasurementUnits() map[string]string {
+	units := make(map[string]string, 0)
+	for _, item := range iot.Measurements {
+		if !item.Ignore && len(item.MeasurementUnits) > 0 {
+			units[item.MeasurementAlias] = item.MeasurementUnits
+		}
+	}
+	return units
+}
+
+// Ecobee layout: one block of Dimensions["time"] rows per HouseIndices device, read from the csv/ conversion of the *.nc file.
+func (cdf *NetCDF) ReplayFrames() ([]SensorFrame, error) {
+	err := cdf.ReadCsvFile(cdf.DataFilePath+"/csv/"+cdf.DatasetName+csvExtension, true) // isDataset: yes
+	if err != nil {
+		return nil, err
+	}
+	cdf.NormalizeValues()
+	timeIndex := 1
+	blockSize := cdf.Dimensions["time"]
+	frames := make([]SensorFrame, 0, len(cdf.Dataset))
+	for block := 0; block < len(cdf.HouseIndices) && blockSize > 0; block++ {
+		device := IotDatasetPrefix(cdf.Identifier, cdf.HouseIndices[block])
+		startRow := blockSize*block + 1
+		endRow := startRow + blockSize
+		if endRow > len(cdf.Dataset) {
+			endRow = len(cdf.Dataset)
+		}
+		for r := startRow; r < endRow; r++ {
+			startTime, err := cdf.TimeParser.Parse(cdf.Dataset[r][timeIndex])
+			if err != nil {
+				fmt.Println("Appears to be a bad time: " + cdf.Dataset[r][timeIndex])
+				continue
+			}
+			frame := SensorFrame{Device: device, Timestamp: startTime.UTC().UnixMilli(), Measurements: make(map[string]interface{}, 0)}
+			for _, item := range cdf.Measurements {
+				if item.Ignore || item.ColumnOrder >= len(cdf.Dataset[r]) || item.MeasurementName == LastColumnName {
+					continue
+				}
+				value := parseDataItem(cdf.Dataset[r][item.ColumnOrder], item.MeasurementType)
+				if value != nil {
+					frame.Measurements[item.MeasurementAlias] = value
+				}
+			}
+			frames = append(frames, frame)
+		}
+	}
+	return frames, nil
+}
+
+// Measurement alias => units, as replayed in frames.
+func (cdf *NetCDF) MeasurementUnits() map[string]string {
+	units := make(map[string]string, 0)
+	for _, item := range cdf.Measurements {
+		if !item.Ignore && len(item.MeasurementUnits) > 0 {
+			units[item.MeasurementAlias] = item.MeasurementUnits
+		}
+	}
+	return units
+}
+
+// Full time series path => TAGS('units').
+func profileUnits(profiles []IotdbTimeseriesProfile) map[string]string {
+	units := make(map[string]string, 0)
+	for _, profile := range profiles {
+		if unit := parseKeyValuePairs(profile.Tags)[unitsName]; len(unit) > 0 {
+			units[profile.Timeseries] = unit
+		}
+	}
+	return units
+}
+
+// Read frames for every device matching pattern within [startTime, endTime) unix milliseconds. Assumes clientConfig is assigned.
+func LoadIotdbReplayFrames(pattern string, startTime, endTime int64) ([]SensorFrame, map[string]string, error) {
+	session := client.NewSession(clientConfig)
+	if err := session.Open(false, 0); err != nil {
+		return nil, nil, err
+	}
+	defer session.Close()
+	profiles, err := GetTimeseriesProfiles(&session, pattern)
+	if err != nil {
+		return nil, nil, err
+	}
+	prefix, suffix := splitIotdbPath(pattern)
+	sql := "SELECT " + suffix + " FROM " + prefix + iotdbTimeRange(startTime, endTime)
+	timeout := tailQueryTimeout
+	sds, err := session.ExecuteQueryStatement(sql, &timeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer sds.Close()
+	frames := make([]SensorFrame, 0)
+	_, err = sessionDataSetFrames(sds, nil, func(frame SensorFrame) { frames = append(frames, frame) })
+	return frames, profileUnits(profiles), err
+}
+
+// Return the key=value program arguments whose keys appear in defaults, e.g. speed=60 from=2017-01-01T00:00:00Z to=2017-02-01T00:00:00Z clock=simulated
+func getProgramParameters(programArgs []string, defaults map[string]string) map[string]string {
+	parameters := make(map[string]string, len(defaults))
+	for k, v := range defaults {
+		parameters[k] = v
+	}
+	for _, arg := range programArgs {
+		tokens := strings.SplitN(arg, "=", 2)
+		if len(tokens) == 2 {
+			if _, ok := parameters[strings.ToLower(tokens[0])]; ok {
+				parameters[strings.ToLower(tokens[0])] = tokens[1]
+			}
+		}
+	}
+	return parameters
+}
+
+// Return unix milliseconds; an empty value returns defaultValue.
+func parseReplayTime(value string, defaultValue int64) (int64, error) {
+	if len(value) == 0 {
+		return defaultValue, nil
+	}
+	t, err := filesystem.GetStartTimeFromLongint(value)
+	if err != nil {
+		return defaultValue, err
+	}
+	return t.UTC().UnixMilli(), nil
+}
+
+// programArgs: replay <file.csv timeColumn | file.nc ncType timeColumn | root.device.pattern> [speed=N] [from=T] [to=T] [clock=wall|simulated]
+func LoadReplay(programArgs []string, publish func(SensorFrame)) (*DatasetReplay, error) {
+	if len(programArgs) < 3 {
+		return nil, errors.New("replay expects a source: <file.csv> <timeColumn>, <file.nc> <ncType> <timeColumn>, or <root.device.pattern>")
+	}
+	source := programArgs[2]
+	sourceArgs := programArgs[1:] // sourceArgs[1] is the source, matching the data file commands.
+	parameters := getProgramParameters(programArgs, replayParameters)
+	speed, err := strconv.ParseFloat(strings.Replace(parameters["speed"], "max", "0", 1), 64)
+	if err != nil {
+		return nil, errors.New("bad replay speed: " + parameters["speed"])
+	}
+	fromTime, err := parseReplayTime(parameters["from"], 0)
+	if err != nil {
+		return nil, err
+	}
+	toTime, err := parseReplayTime(parameters["to"], time.Now().UTC().UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+
+	var frames []SensorFrame
+	var units map[string]string
+	switch {
+	case strings.HasPrefix(source, "root."):
+		frames, units, err = LoadIotdbReplayFrames(source, fromTime, toTime)
+	case sourceExt(source) == csvExtension:
+		var iotdbDataFile IoTDbCsvDataFile
+		iotdbDataFile, err = Initialize_IoTDbCsvDataFile(false, sourceArgs)
+		if err == nil && iotdbDataFile.Dataset == nil { // an adapter has read it
+			err = iotdbDataFile.ReadCsvFile(iotdbDataFile.DataFilePath, true) // isDataset: yes
+			iotdbDataFile.NormalizeValues()
+		}
+		if err == nil {
+			frames, err = iotdbDataFile.ReplayFrames()
+			units = iotdbDataFile.MeasurementUnits()
+		}
+	case sourceExt(source) == ncExtension:
+		var xcdf NetCDF
+		xcdf, err = Initialize_IoTDbNcDataFile(false, sourceArgs)
+		if err == nil {
+			frames, err = xcdf.ReplayFrames()
+			units = xcdf.MeasurementUnits()
+		}
+	default:
+		err = errors.New("Cannot replay source: " + source)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(frames) == 0 {
+		return nil, errors.New("no frames to replay from " + source)
+	}
+
+	inRange := frames[:0] // file sources are filtered here; IoTDB sources were filtered by the query.
+	for _, frame := range frames {
+		if frame.Timestamp >= fromTime && frame.Timestamp < toTime {
+			inRange = append(inRange, frame)
+		}
+	}
+	var clock ReplayClock = WallClock{}
+	if strings.EqualFold(parameters["clock"], "simulated") {
+		clock = NewSimulatedClock(time.UnixMilli(fromTime).UTC())
+	}
+	replay := NewDatasetReplay(source, inRange, speed, clock, publish)
+	replay.Units = units
+	return replay, nil
+}
@@ -0,0 +1,401 @@
+package main // resample.go puts ingested series on a common time grid for digital-twin models (OPSD ships 15, 30 and 60 minute variants; AMP is minutely).
+// netcdf resample <root.device or pattern> interval=60min [method=mean|sum|last|linear|step] [methods=Energy:sum,State:last]
+//                 [start=time] [end=time] [output=iotdb|csv|parquet] [suffix=_60min] [dir=.]
+// Aggregations use the samples of [t, t+interval) and are stamped t, like IoTDB GROUP BY; empty intervals are left out.
+// Interpolations estimate the value at each grid time t from the samples around it: linear between neighbours, step holds the last sample.
+// Numeric series default to mean; boolean and text series (states) default to last. Output goes to the device <device><suffix>, e.g. root.opsd.household_60min.
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apache/iotdb-client-go/client"
+)
+
+const (
+	resampleMean   = "mean"
+	resampleSum    = "sum"
+	resampleLast   = "last"
+	resampleLinear = "linear"
+	resampleStep   = "step"
+)
+
+var resampleParameters = map[string]string{"interval": "", "method": "", "methods": "", "start": "", "end": "", "output": "iotdb", "suffix": "", "dir": "."}
+var resampleMethods = []string{resampleMean, resampleSum, resampleLast, resampleLinear, resampleStep}
+var resampleOutputs = []string{"iotdb", "csv", "parquet"}
+
+// Sample is one value of one series.
+type Sample struct {
+	Timestamp int64       `json:"timestamp"` // unix milliseconds UTC
+	Value     interface{} `json:"value"`
+}
+
+// Parse 15min, 60min, 1h, 30s, 1d or a Go duration into milliseconds.
+func ParseInterval(interval string) (int64, error) {
+	value := strings.ToLower(strings.TrimSpace(interval))
+	switch {
+	case strings.HasSuffix(value, "min"):
+		value = strings.TrimSuffix(value, "in")
+	case strings.HasSuffix(value, "d"):
+		days, err := strconv.ParseInt(strings.TrimSuffix(value, "d"), 10, 64)
+		if err != nil {
+			return 0, errors.New("bad interval: " + interval)
+		}
+		value = strconv.FormatInt(days*24, 10) + "h"
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration.Milliseconds() <= 0 {
+		return 0, errors.New("bad interval: " + interval + "; expected e.g. 15min, 1h, 1d")
+	}
+	return duration.Milliseconds(), nil
+}
+
+// Start of the interval that contains timestamp.
+func floorInterval(timestamp, intervalMs int64) int64 {
+	floor := timestamp - timestamp%intervalMs
+	if timestamp < 0 && floor != timestamp {
+		floor -= intervalMs
+	}
+	return floor
+}
+
+// Default method by MeasurementType: states keep their last value.
+func defaultResampleMethod(measurementType, numericMethod string) string {
+	switch measurementType {
+	case "boolean", "string", "unicode", "datetime":
+		return resampleLast
+	}
+	if len(numericMethod) > 0 {
+		return numericMethod
+	}
+	return resampleMean
+}
+
+// Resample samples (sorted by time) onto the grid [startTime, endTime) of intervalMs. Only last and step accept non-numeric values.
+func Resample(samples []Sample, startTime, endTime, intervalMs int64, method string) ([]Sample, error) {
+	resampled := make([]Sample, 0)
+	if len(samples) == 0 {
+		return resampled, nil
+	}
+	numeric := method != resampleLast && method != resampleStep
+	values := make([]float64, len(samples))
+	for ndx, sample := range samples {
+		if !numeric {
+			continue
+		}
+		v, ok := numericValue(sample.Value)
+		if !ok {
+			return nil, fmt.Errorf("%s needs numeric values; got %v", method, sample.Value)
+		}
+		values[ndx] = v
+	}
+
+	switch method {
+	case resampleMean, resampleSum, resampleLast:
+		for ndx := 0; ndx < len(samples); {
+			bucket := floorInterval(samples[ndx].Timestamp, intervalMs)
+			count, sum := 0, 0.0
+			last := samples[ndx].Value
+			for ; ndx < len(samples) && samples[ndx].Timestamp < bucket+intervalMs; ndx++ {
+				count++
+				sum += values[ndx]
+				last = samples[ndx].Value
+			}
+			if bucket < startTime || bucket >= endTime {
+				continue
+			}
+			switch method {
+			case resampleMean:
+				resampled = append(resampled, Sample{Timestamp: bucket, Value: sum / float64(count)})
+			case resampleSum:
+				resampled = append(resampled, Sample{Timestamp: bucket, Value: sum})
+			default:
+				resampled = append(resampled, Sample{Timestamp: bucket, Value: last})
+			}
+		}
+
+	case resampleLinear, resampleStep:
+		firstTime := samples[0].Timestamp // nothing can be estimated before the first sample.
+		if startTime > firstTime {
+			firstTime = startTime
+		}
+		gridTime := floorInterval(firstTime, intervalMs)
+		if gridTime < firstTime {
+			gridTime += intervalMs
+		}
+		next := 0 // first sample after gridTime
+		for ; gridTime < endTime && gridTime <= samples[len(samples)-1].Timestamp; gridTime += intervalMs {
+			for next < len(samples) && samples[next].Timestamp <= gridTime {
+				next++
+			}
+			previous := next - 1
+			if previous < 0 {
+				continue
+			}
+			if method == resampleStep || samples[previous].Timestamp == gridTime {
+				resampled = append(resampled, Sample{Timestamp: gridTime, Value: samples[previous].Value})
+				continue
+			}
+			fraction := float64(gridTime-samples[previous].Timestamp) / float64(samples[next].Timestamp-samples[previous].Timestamp)
+			resampled = append(resampled, Sample{Timestamp: gridTime, Value: values[previous] + fraction*(values[next]-values[previous])})
+		}
+
+	default:
+		return nil, errors.New("resample method must be one of " + strings.Join(resampleMethods, ", "))
+	}
+	return resampled, nil
+}
+
+// ResampleColumn is one resampled series of a device.
+type ResampleColumn struct {
+	ExportColumn
+	Method  string   `json:"method"`
+	Samples []Sample `json:"-"`
+}
+
+// Read every sample of the columns of device within [startTime, endTime).
+func readDeviceSamples(session *client.Session, device string, columns []ResampleColumn, startTime, endTime int64) error {
+	aliases := make([]string, 0, len(columns))
+	columnIndex := make(map[string]int, len(columns))
+	for ndx, column := range columns {
+		aliases = append(aliases, column.MeasurementAlias)
+		columnIndex[column.MeasurementAlias] = ndx
+	}
+	sql := "SELECT " + strings.Join(aliases, ",") + " FROM " + device + iotdbTimeRange(startTime, endTime)
+	timeout := tailQueryTimeout
+	sds, err := session.ExecuteQueryStatement(sql, &timeout)
+	if err != nil {
+		return errors.New(sql + ": " + err.Error())
+	}
+	defer sds.Close()
+	for next, err := sds.Next(); next || err != nil; next, err = sds.Next() {
+		if err != nil {
+			return err
+		}
+		for ndx := 0; ndx < sds.GetColumnCount(); ndx++ {
+			columnName := sds.GetColumnName(ndx)
+			_, alias := splitIotdbPath(columnName)
+			position, ok := columnIndex[alias]
+			value := sds.GetValue(columnName)
+			if ok && value != nil {
+				columns[position].Samples = append(columns[position].Samples, Sample{Timestamp: iotdbToMs(sds.GetTimestamp()), Value: value})
+			}
+		}
+	}
+	return nil
+}
+
+// Merge resampled columns into rows ordered by time; nil where a column has no value.
+func resampledRows(columns []ResampleColumn) ([]int64, map[int64][]interface{}) {
+	rows := make(map[int64][]interface{}, 0)
+	for ndx, column := range columns {
+		for _, sample := range column.Samples {
+			row, ok := rows[sample.Timestamp]
+			if !ok {
+				row = make([]interface{}, len(columns))
+				rows[sample.Timestamp] = row
+			}
+			row[ndx] = sample.Value
+		}
+	}
+	timestamps := make([]int64, 0, len(rows))
+	for timestamp := range rows {
+		timestamps = append(timestamps, timestamp)
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	return timestamps, rows
+}
+
+// IoTDB data type of a resampled column: aggregates and interpolations of numbers are DOUBLE.
+func resampledDataType(column ResampleColumn) (string, string) {
+	if column.Method == resampleLast || column.Method == resampleStep {
+		return column.IotdbType, column.MeasurementType
+	}
+	return "DOUBLE", "double"
+}
+
+// Create the missing aligned time series of the resampled device and insert its rows.
+func writeResampledDevice(session *client.Session, device string, columns []ResampleColumn) error {
+	existing, err := GetTimeseriesProfiles(session, device+".*")
+	if err != nil {
+		return err
+	}
+	created := make(map[string]bool, 0)
+	for _, profile := range existing {
+		created[profile.Timeseries] = true
+	}
+	var sb strings.Builder
+	for _, column := range columns {
+		if created[device+iotdbPathSep+column.MeasurementAlias] {
+			continue
+		}
+		dataType, xsdType := resampledDataType(column)
+		_, encoding, compressor := getClientStorage(xsdType)
+		attributes := " ATTRIBUTES('datatype'='" + xsdType + "', 'name'='" + strings.ReplaceAll(column.MeasurementName, "'", "''") + "', 'resample'='" + column.Method + "') TAGS('units'='" + column.MeasurementUnits + "')"
+		sb.WriteString(column.MeasurementAlias + " " + dataType + " encoding=" + encoding + " compressor=" + compressor + attributes + ",")
+	}
+	if sb.Len() > 0 {
+		sql := "CREATE ALIGNED TIMESERIES " + device + "(" + strings.TrimSuffix(sb.String(), ",") + ");"
+		if _, err := session.ExecuteNonQueryStatement(sql); err != nil {
+			return errors.New(sql + ": " + err.Error())
+		}
+	}
+
+	timestamps, rows := resampledRows(columns)
+	blockSize := getBlockSize(len(columns))
+	statements := make([]string, 0, blockSize)
+	for _, timestamp := range timestamps {
+		names := make([]string, 0, len(columns))
+		values := make([]string, 0, len(columns))
+		for ndx, value := range rows[timestamp] {
+			if value != nil {
+				names = append(names, columns[ndx].MeasurementAlias)
+				values = append(values, formatFrameValue(value))
+			}
+		}
+		statements = append(statements, alignedInsertStatement(device, timestamp, names, values))
+		if len(statements) == blockSize {
+			if _, err := session.ExecuteBatchStatement(statements); err != nil {
+				return err
+			}
+			statements = statements[:0]
+		}
+	}
+	if len(statements) > 0 {
+		if _, err := session.ExecuteBatchStatement(statements); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write the resampled rows to export files named after the resampled device.
+func exportResampledDevice(device, format, outputPath string, columns []ResampleColumn) ([]string, error) {
+	exportColumns := make([]ExportColumn, 0, len(columns))
+	for _, column := range columns {
+		exportColumn := column.ExportColumn
+		exportColumn.IotdbType, exportColumn.MeasurementType = resampledDataType(column)
+		exportColumns = append(exportColumns, exportColumn)
+	}
+	de := DeviceExport{Device: device, Columns: exportColumns, Format: format, OutputPath: outputPath}
+	timestamps, rows := resampledRows(columns)
+	for _, timestamp := range timestamps {
+		values := make([]*string, len(columns))
+		for ndx, value := range rows[timestamp] {
+			if value != nil {
+				text, ok := value.(string)
+				if !ok {
+					text = formatFrameValue(value)
+				}
+				values[ndx] = &text
+			}
+		}
+		if err := de.writeRow(msToIotdb(timestamp), values); err != nil {
+			return de.Files, err
+		}
+	}
+	if de.current == nil {
+		if err := de.nextChunk(); err != nil {
+			return de.Files, err
+		}
+	}
+	return de.Files, de.current.Close()
+}
+
+// Measurement:method pairs, e.g. Energy:sum,State:last
+func parseResampleMethods(value string) (map[string]string, error) {
+	methods := make(map[string]string, 0)
+	for _, pair := range splitList(value) {
+		tokens := strings.SplitN(pair, ":", 2)
+		if len(tokens) != 2 || !contains(resampleMethods, strings.ToLower(tokens[1])) {
+			return nil, errors.New("methods expects measurement:method pairs with a method of " + strings.Join(resampleMethods, ", ") + ": " + pair)
+		}
+		methods[tokens[0]] = strings.ToLower(tokens[1])
+	}
+	return methods, nil
+}
+
+// programArgs: resample <root.device or pattern> interval=60min [method=] [methods=] [start=] [end=] [output=iotdb|csv|parquet] [suffix=_60min] [dir=.]
+func ProcessResample(programArgs []string) {
+	if len(programArgs) < 3 || !strings.HasPrefix(programArgs[2], "root.") {
+		checkErr("resample", errors.New("expected: resample <root.device> interval=60min [method=mean|sum|last|linear|step] [methods=a:sum,b:last] [output=iotdb|csv|parquet]"))
+	}
+	parameters := getProgramParameters(programArgs, resampleParameters)
+	intervalMs, err := ParseInterval(parameters["interval"])
+	checkErr("resample(interval)", err)
+	method := strings.ToLower(parameters["method"])
+	if len(method) > 0 && !contains(resampleMethods, method) {
+		checkErr("resample", errors.New("method must be one of "+strings.Join(resampleMethods, ", ")))
+	}
+	methods, err := parseResampleMethods(parameters["methods"])
+	checkErr("resample(methods)", err)
+	output := strings.ToLower(parameters["output"])
+	if !contains(resampleOutputs, output) {
+		checkErr("resample", errors.New("output must be one of "+strings.Join(resampleOutputs, ", ")))
+	}
+	suffix := parameters["suffix"]
+	if len(suffix) == 0 {
+		suffix = "_" + strings.ToLower(parameters["interval"])
+	}
+	startTime, err := parseReplayTime(parameters["start"], 0)
+	checkErr("resample(start)", err)
+	endTime, err := parseReplayTime(parameters["end"], time.Now().UTC().UnixMilli()+1)
+	checkErr("resample(end)", err)
+
+	iotdbConnection, ok := Init_IoTDB(true)
+	if !ok {
+		checkErr("Init_IoTDB: ", errors.New(iotdbConnection))
+	}
+	session := client.NewSession(clientConfig)
+	err = session.Open(false, 0)
+	checkErr("Open", err)
+	defer session.Close()
+
+	devices := []IotdbDevice{{Device: programArgs[2]}}
+	if strings.Contains(programArgs[2], anyOneNode) {
+		devices, err = ShowDevices(&session, programArgs[2])
+		checkErr("ShowDevices", err)
+	}
+	for _, device := range devices {
+		if strings.HasSuffix(device.Device, suffix) {
+			continue // already resampled
+		}
+		profiles, err := GetTimeseriesProfiles(&session, device.Device+".*")
+		checkErr("GetTimeseriesProfiles", err)
+		exported, err := exportColumns(profiles, nil, false, nil)
+		checkErr("resample("+device.Device+")", err)
+		columns := make([]ResampleColumn, 0, len(exported))
+		for _, column := range exported {
+			columnMethod, ok := methods[column.MeasurementAlias]
+			if !ok {
+				columnMethod, ok = methods[column.MeasurementName]
+			}
+			if !ok {
+				columnMethod = defaultResampleMethod(column.MeasurementType, method)
+			}
+			columns = append(columns, ResampleColumn{ExportColumn: column, Method: columnMethod})
+		}
+		err = readDeviceSamples(&session, device.Device, columns, startTime, endTime)
+		checkErr("readDeviceSamples", err)
+		for ndx := range columns {
+			columns[ndx].Samples, err = Resample(columns[ndx].Samples, startTime, endTime, intervalMs, columns[ndx].Method)
+			checkErr("Resample("+device.Device+iotdbPathSep+columns[ndx].MeasurementAlias+")", err)
+		}
+
+		resampledDevice := device.Device + suffix
+		if output == "iotdb" {
+			err = writeResampledDevice(&session, resampledDevice, columns)
+			checkErr("writeResampledDevice", err)
+			fmt.Println("Resampled " + device.Device + " into " + resampledDevice)
+			fmt.Println("IOTDB TEST QUERY: SELECT * FROM " + resampledDevice + " LIMIT 2;")
+		} else {
+			files, err := exportResampledDevice(resampledDevice, output, parameters["dir"], columns)
+			checkErr("exportResampledDevice", err)
+			fmt.Println("Resampled " + device.Device + " into " + strings.Join(files, ", "))
+		}
+	}
+}
@@ -0,0 +1,261 @@
+// Strongly typed access to the sensor datasets that netcdf loads into IoTDB.
+// Regenerate the Go code from the repository root with:
+//   protoc --go_out=. --go_opt=module=netcdf --go-grpc_out=. --go-grpc_opt=module=netcdf sensorpb/sensorstream.proto
+// Python: python -m grpc_tools.protoc -I . --python_out=. --grpc_python_out=. sensorpb/sensorstream.proto
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: sensorpb/sensorstream.proto
+
+package sensorpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	SensorStream_ListDatasets_FullMethodName    = "/netcdf.sensorstream.v1.SensorStream/ListDatasets"
+	SensorStream_DescribeDataset_FullMethodName = "/netcdf.sensorstream.v1.SensorStream/DescribeDataset"
+	SensorStream_Query_FullMethodName           = "/netcdf.sensorstream.v1.SensorStream/Query"
+	SensorStream_Subscribe_FullMethodName       = "/netcdf.sensorstream.v1.SensorStream/Subscribe"
+)
+
+// SensorStreamClient is the client API for SensorStream service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type SensorStreamClient interface {
+	// One entry per IoTDB device: root.<group>.<dataset> or root.ecobee.household.<id>.
+	ListDatasets(ctx context.Context, in *ListDatasetsRequest, opts ...grpc.CallOption) (*ListDatasetsResponse, error)
+	// The MeasurementItem list of one device with units and types.
+	DescribeDataset(ctx context.Context, in *DescribeDatasetRequest, opts ...grpc.CallOption) (*DatasetDescription, error)
+	// Raw or aggregated values of a path over a time range.
+	Query(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (*QueryResponse, error)
+	// Frames inserted (or replayed) after from_time for every device matching path_pattern.
+	Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (SensorStream_SubscribeClient, error)
+}
+
+type sensorStreamClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewSensorStreamClient(cc grpc.ClientConnInterface) SensorStreamClient {
+	return &sensorStreamClient{cc}
+}
+
+func (c *sensorStreamClient) ListDatasets(ctx context.Context, in *ListDatasetsRequest, opts ...grpc.CallOption) (*ListDatasetsResponse, error) {
+	out := new(ListDatasetsResponse)
+	err := c.cc.Invoke(ctx, SensorStream_ListDatasets_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sensorStreamClient) DescribeDataset(ctx context.Context, in *DescribeDatasetRequest, opts ...grpc.CallOption) (*DatasetDescription, error) {
+	out := new(DatasetDescription)
+	err := c.cc.Invoke(ctx, SensorStream_DescribeDataset_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sensorStreamClient) Query(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (*QueryResponse, error) {
+	out := new(QueryResponse)
+	err := c.cc.Invoke(ctx, SensorStream_Query_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sensorStreamClient) Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (SensorStream_SubscribeClient, error) {
+	stream, err := c.cc.NewStream(ctx, &SensorStream_ServiceDesc.Streams[0], SensorStream_Subscribe_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &sensorStreamSubscribeClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type SensorStream_SubscribeClient interface {
+	Recv() (*Frame, error)
+	grpc.ClientStream
+}
+
+type sensorStreamSubscribeClient struct {
+	grpc.ClientStream
+}
+
+func (x *sensorStreamSubscribeClient) Recv() (*Frame, error) {
+	m := new(Frame)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// SensorStreamServer is the server API for SensorStream service.
+// All implementations must embed UnimplementedSensorStreamServer
+// for forward compatibility
+type SensorStreamServer interface {
+	// One entry per IoTDB device: root.<group>.<dataset> or root.ecobee.household.<id>.
+	ListDatasets(context.Context, *ListDatasetsRequest) (*ListDatasetsResponse, error)
+	// The MeasurementItem list of one device with units and types.
+	DescribeDataset(context.Context, *DescribeDatasetRequest) (*DatasetDescription, error)
+	// Raw or aggregated values of a path over a time range.
+	Query(context.Context, *QueryRequest) (*QueryResponse, error)
+	// Frames inserted (or replayed) after from_time for every device matching path_pattern.
+	Subscribe(*SubscribeRequest, SensorStream_SubscribeServer) error
+	mustEmbedUnimplementedSensorStreamServer()
+}
+
+// UnimplementedSensorStreamServer must be embedded to have forward compatible implementations.
+type UnimplementedSensorStreamServer struct {
+}
+
+func (UnimplementedSensorStreamServer) ListDatasets(context.Context, *ListDatasetsRequest) (*ListDatasetsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListDatasets not implemented")
+}
+func (UnimplementedSensorStreamServer) DescribeDataset(context.Context, *DescribeDatasetRequest) (*DatasetDescription, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DescribeDataset not implemented")
+}
+func (UnimplementedSensorStreamServer) Query(context.Context, *QueryRequest) (*QueryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Query not implemented")
+}
+func (UnimplementedSensorStreamServer) Subscribe(*SubscribeRequest, SensorStream_SubscribeServer) error {
+	return status.Errorf(codes.Unimplemented, "method Subscribe not implemented")
+}
+func (UnimplementedSensorStreamServer) mustEmbedUnimplementedSensorStreamServer() {}
+
+// UnsafeSensorStreamServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to SensorStreamServer will
+// result in compilation errors.
+type UnsafeSensorStreamServer interface {
+	mustEmbedUnimplementedSensorStreamServer()
+}
+
+func RegisterSensorStreamServer(s grpc.ServiceRegistrar, srv SensorStreamServer) {
+	s.RegisterService(&SensorStream_ServiceDesc, srv)
+}
+
+func _SensorStream_ListDatasets_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListDatasetsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SensorStreamServer).ListDatasets(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SensorStream_ListDatasets_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SensorStreamServer).ListDatasets(ctx, req.(*ListDatasetsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SensorStream_DescribeDataset_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DescribeDatasetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SensorStreamServer).DescribeDataset(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SensorStream_DescribeDataset_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SensorStreamServer).DescribeDataset(ctx, req.(*DescribeDatasetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SensorStream_Query_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QueryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SensorStreamServer).Query(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SensorStream_Query_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SensorStreamServer).Query(ctx, req.(*QueryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SensorStream_Subscribe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(SensorStreamServer).Subscribe(m, &sensorStreamSubscribeServer{stream})
+}
+
+type SensorStream_SubscribeServer interface {
+	Send(*Frame) error
+	grpc.ServerStream
+}
+
+type sensorStreamSubscribeServer struct {
+	grpc.ServerStream
+}
+
+func (x *sensorStreamSubscribeServer) Send(m *Frame) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// SensorStream_ServiceDesc is the grpc.ServiceDesc for SensorStream service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var SensorStream_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "netcdf.sensorstream.v1.SensorStream",
+	HandlerType: (*SensorStreamServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListDatasets",
+			Handler:    _SensorStream_ListDatasets_Handler,
+		},
+		{
+			MethodName: "DescribeDataset",
+			Handler:    _SensorStream_DescribeDataset_Handler,
+		},
+		{
+			MethodName: "Query",
+			Handler:    _SensorStream_Query_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Subscribe",
+			Handler:       _SensorStream_Subscribe_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "sensorpb/sensorstream.proto",
+}
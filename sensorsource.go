@@ -0,0 +1,316 @@
+package main // sensorsource.go writes any SensorSource into IoTDB with one ProcessTimeseries engine.
+// A SensorSource has a database (Identifier), a dataset name, a schema of measurements in column order and devices whose rows are read in batches.
+// NetCDF (*.nc, inserted from its csv/ conversion) and IoTDbCsvDataFile (*.csv) are SensorSources; a new format plugs in by implementing the interface
+// and then creates, drops, deletes and inserts exactly like the others.
+// The summary functions are shared by the sources described by an xsv-stats summary file: a header row, one row per field and an end row with the Identifier.
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/apache/iotdb-client-go/client"
+)
+
+// The data file side of ProcessTimeseries.
+type SensorSource interface {
+	Access() *IoTDbAccess                                                         // session, commands and dry run
+	Database() string                                                             // Identifier
+	Name() string                                                                 // DatasetName; also the value of the DatasetName measurement
+	Schema() []*MeasurementItem                                                   // in column order, without ignored measurements
+	Devices() []string                                                            // device nodes below the database
+	Attributes(item *MeasurementItem) string                                      // createts ATTRIBUTES after 'datatype' and 'name', each starting with ", "
+	Batches(blockSize int, write func(device string, batch RowBatch) error) error // the insert rows, at most blockSize per batch
+}
+
+// Rows of one device: Values[i] holds the Schema() values at Timestamps[i].
+type RowBatch struct {
+	Timestamps []int64
+	Values     [][]string
+}
+
+// Command-line parameters: {createdb dropts createts delete insert}. Always output dataset description.
+// create time series root.datasets.etsi.household_data_60min_singleindex.DE_KN_industrial1_grid_import with datatype=FLOAT, encoding=GORILLA, compressor=SNAPPY;
+// ProcessTimeseries is the only place where the session of a source is instantiated and clientConfig is used.
+func ProcessTimeseries(source SensorSource) error {
+	access := source.Access()
+	if access.ActiveSession {
+		access.session = client.NewSession(clientConfig)
+		if err := access.session.Open(false, 0); err != nil {
+			return errors.New("session.Open: " + err.Error())
+		}
+		defer access.session.Close()
+	}
+	access.progress("Processing time series for dataset " + source.Name() + " ...")
+
+	for _, command := range access.TimeseriesCommands {
+		var err error
+		switch command {
+		case "createdb":
+			sql := "CREATE DATABASE " + source.Database()
+			if err = access.executeNonQuery(sql); err != nil {
+				return errors.New("ExecuteNonQueryStatement(createDBstatement): " + err.Error())
+			}
+			access.progress(sql)
+
+		case "dropts": // time series schema; one statement per device.
+			for _, device := range source.Devices() {
+				if err = access.executeNonQuery("DROP TIMESERIES " + IotDatasetPrefix(source.Database(), device) + ".*"); err != nil {
+					return errors.New("ExecuteNonQueryStatement(dropStatement): " + err.Error())
+				}
+			}
+
+		case "createts":
+			// Setting an alias, tag, and attribute for an aligned timeseries is supported as of Nov. 3, 2023 (v1.2.2).
+			// Note: For a group of aligned timeseries, Iotdb does not support different compressions.
+			// https://iotdb.apache.org/UserGuide/V1.0.x/Reference/SQL-Reference.html#schema-statement
+			for _, device := range source.Devices() {
+				if err = access.executeNonQuery(createTimeseriesStatement(source, device)); err != nil {
+					return errors.New("ExecuteNonQueryStatement(createStatement): " + err.Error())
+				}
+			}
+			access.progress("IOTDB TEST QUERY: show timeseries " + sourcePattern(source) + ".*;")
+
+		case "delete": // remove all data; retain schema; multiple commands.
+			for _, device := range source.Devices() {
+				deleteStatements := make([]string, 0)
+				for _, item := range source.Schema() {
+					deleteStatements = append(deleteStatements, "DELETE FROM "+IotDatasetPrefix(source.Database(), device)+"."+item.MeasurementAlias+";")
+				}
+				if err = access.executeBatch(deleteStatements); err != nil {
+					return errors.New("ExecuteBatchStatement(deleteStatements): " + err.Error())
+				}
+			}
+
+		case "insert": // insert(append) data; retain schema. Automatically inserts long time column as first column (which should be UTC).
+			schema := source.Schema()
+			columns := formattedColumnNames(schema)
+			blocks := 0
+			err = source.Batches(getBlockSize(len(schema)), func(device string, batch RowBatch) error {
+				if len(batch.Timestamps) == 0 {
+					return nil
+				}
+				if blocks++; blocks == 1 {
+					access.progressf("Writing blocks: ")
+				}
+				access.progressf(".")
+				var insert strings.Builder
+				insert.WriteString("INSERT INTO " + IotDatasetPrefix(source.Database(), device) + " (time, " + columns + ") ALIGNED VALUES ")
+				for r, timestamp := range batch.Timestamps {
+					if r > 0 {
+						insert.WriteString(",")
+					}
+					insert.WriteString("(" + strconv.FormatInt(timestamp, 10))
+					for c, item := range schema {
+						insert.WriteString("," + formatDataItem(batch.Values[r][c], item.MeasurementType))
+					}
+					insert.WriteString(")")
+				}
+				if err := access.executeNonQuery(insert.String() + ";"); err != nil {
+					return errors.New("ExecuteNonQueryStatement(insertStatement): " + err.Error())
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			access.progress("\nIOTDB TEST QUERY: SELECT COUNT(*) FROM " + sourcePattern(source) + ";")
+			access.progress("IOTDB TEST QUERY: SELECT * FROM " + sourcePattern(source) + " LIMIT 2;")
+		}
+		access.progress("Timeseries <" + command + "> completed.")
+	} // for
+
+	return nil
+}
+
+// CREATE ALIGNED TIMESERIES root.etsidata.device.household_data_1min_singleindex (utc_timestamp TEXT encoding=PLAIN compressor=SNAPPY ATTRIBUTES(...) TAGS(...), etc);
+func createTimeseriesStatement(source SensorSource, device string) string {
+	return createAlignedStatement(IotDatasetPrefix(source.Database(), device), source.Schema(), source.Attributes)
+}
+
+// CREATE ALIGNED TIMESERIES of the items of device; attributes (nil for none) adds to the ATTRIBUTES of an item.
+func createAlignedStatement(device string, items []*MeasurementItem, attributes func(item *MeasurementItem) string) string {
+	columns := make([]string, 0, len(items))
+	for _, item := range items {
+		dataType, encoding, compressor := getClientStorage(item.MeasurementType)
+		more := ""
+		if attributes != nil {
+			more = attributes(item)
+		}
+		columns = append(columns, item.MeasurementAlias+" "+dataType+" encoding="+encoding+" compressor="+compressor+" ATTRIBUTES('datatype'='"+item.MeasurementType+"', 'name'='"+strings.ReplaceAll(item.MeasurementName, "'", "''")+"'"+more+") TAGS('units'='"+item.MeasurementUnits+"')")
+	}
+	return "CREATE ALIGNED TIMESERIES " + device + "(" + strings.Join(columns, ",") + ");"
+}
+
+// The device of a single-device source, else every device of the database; for the IOTDB TEST QUERY hints.
+func sourcePattern(source SensorSource) string {
+	if devices := source.Devices(); len(devices) == 1 {
+		return IotDatasetPrefix(source.Database(), devices[0])
+	}
+	return source.Database() + ".*"
+}
+
+// The values of a data row in schema order; the DatasetName measurement holds datasetName.
+func schemaValues(record []string, schema []*MeasurementItem, datasetName string) []string {
+	values := make([]string, len(schema))
+	for ndx, item := range schema {
+		switch {
+		case item.MeasurementName == LastColumnName:
+			values[ndx] = datasetName
+		case item.ColumnOrder < len(record):
+			values[ndx] = record[item.ColumnOrder]
+		}
+	}
+	return values
+}
+
+// Sort items by ColumnOrder; ignored items are kept only withIgnored.
+func orderedMeasurements(items []*MeasurementItem, withIgnored bool) []*MeasurementItem {
+	ordered := make([]*MeasurementItem, 0, len(items))
+	for _, item := range items {
+		if withIgnored || !item.Ignore {
+			ordered = append(ordered, item)
+		}
+	}
+	sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].ColumnOrder < ordered[j].ColumnOrder })
+	return ordered
+}
+
+// Return list of ordered dataset column names as string. Does not include enclosing ()
+func formattedColumnNames(schema []*MeasurementItem) string {
+	aliases := make([]string, 0, len(schema))
+	for _, item := range schema {
+		if !item.Ignore {
+			aliases = append(aliases, item.MeasurementAlias)
+		}
+	}
+	return strings.Join(aliases, ",") + " "
+}
+
+// Change tiny values to 0, not null. Missing value tokens of a data package become empty values, which are nulls.
+func normalizeValues(dataset [][]string, missingValues []string) {
+	const minimum = 10e-10
+	changed := 0
+	for r := 1; r < len(dataset); r++ { // skip the header row
+		for c := range dataset[r] {
+			if len(dataset[r][c]) > 0 && contains(missingValues, dataset[r][c]) {
+				dataset[r][c] = ""
+				continue
+			}
+			fval, err := strconv.ParseFloat(dataset[r][c], 64)
+			if err == nil && math.Abs(fval) < minimum {
+				dataset[r][c] = "0"
+				changed++
+			}
+		}
+	}
+	if changed > 0 {
+		fmt.Print(changed)
+		fmt.Print(" absolute tiny values changed to 0 [< ")
+		fmt.Print(minimum)
+		fmt.Println("]")
+	}
+}
+
+// The column of name in the summary header row, or -1.
+func summaryColumnNumber(summary [][]string, name string) int {
+	if len(summary) == 0 {
+		return -1
+	}
+	for ndx := range summary[0] {
+		if strings.EqualFold(summary[0][ndx], name) {
+			return ndx
+		}
+	}
+	return -1
+}
+
+// row[column], or empty when the row has no such column.
+func summaryCell(row []string, column int) string {
+	if column < 0 || column >= len(row) {
+		return ""
+	}
+	return row[column]
+}
+
+// Assign the measurements of a summary in column order and return them with the Identifier of its end row. Use XSD data types.
+// Expects {Units, DatasetName} fields to have been appended to the summary file; a description column comes from a data package (datapackage.go).
+// The DatasetName measurement is added in case data column names are the same for different sampling intervals.
+func summaryMeasurements(summary [][]string) (string, []*MeasurementItem) {
+	unitsColumn := summaryColumnNumber(summary, unitsName)
+	descriptionColumn := summaryColumnNumber(summary, "description")
+	identifier := ""
+	items := make([]*MeasurementItem, 0)
+	ndx := 0
+	for ; ndx < maxColumns && ndx+1 < len(summary); ndx++ { // iterate over summary file rows.
+		row := summary[ndx+1]
+		if row[0] == interpolated || strings.TrimSpace(row[0]) == endOfFields {
+			identifier = summaryCell(row, 1)
+			break
+		}
+		dataColumnName, _ := StandardName(row[0]) // dataColumnName is normalized for IotDB naming conventions.
+		ignore := row[0] == "time" || isEmptyDataColumn(row)
+		if ignore {
+			fmt.Println("Ignoring empty data column " + dataColumnName)
+		}
+		items = append(items, &MeasurementItem{
+			MeasurementName:        strings.TrimSpace(row[0]), // the field as it is in the data file, recorded in ATTRIBUTES('name'); export writes it back.
+			MeasurementAlias:       dataColumnName,
+			MeasurementType:        rowsXsdMap[summaryCell(row, 1)],
+			MeasurementUnits:       summaryCell(row, unitsColumn),
+			MeasurementDescription: summaryCell(row, descriptionColumn),
+			ColumnOrder:            ndx,
+			Ignore:                 ignore,
+		})
+	}
+	items = append(items, &MeasurementItem{
+		MeasurementName:  LastColumnName,
+		MeasurementAlias: LastColumnName,
+		MeasurementType:  "string",
+		MeasurementUnits: "unitless",
+		ColumnOrder:      ndx,
+		Ignore:           false,
+	})
+	return identifier, items
+}
+
+// Return the summary column columnName={summaryColumnNames} of every measurement, indexed by ColumnOrder. Return false if column name not found.
+// Integer statistics are truncated; an empty statistic defaults to the summary type, which is nearly always Unicode.
+func summaryStatValues(summary [][]string, items []*MeasurementItem, columnName string) ([]string, bool) {
+	_, columnIndex := find(summaryColumnNames, columnName)
+	if columnIndex < 0 {
+		return []string{}, false
+	}
+	stats := make([]string, len(items))
+	for _, item := range items {
+		if item.Ignore || item.MeasurementName == LastColumnName || item.ColumnOrder >= len(stats) || item.ColumnOrder+1 >= len(summary) {
+			continue
+		}
+		row := summary[item.ColumnOrder+1]
+		stat := strings.TrimSpace(summaryCell(row, columnIndex))
+		if strings.HasPrefix(strings.ToLower(item.MeasurementType), "int") || strings.HasPrefix(strings.ToLower(item.MeasurementType), "long") {
+			if index := strings.Index(stat, "."); index >= 0 {
+				stat = stat[0:index]
+			}
+		}
+		if len(stat) == 0 {
+			stat = strings.TrimSpace(summaryCell(row, 1))
+		}
+		stats[item.ColumnOrder] = stat
+	}
+	return stats, true
+}
+
+// Return string-formatted value from: columnName={summaryColumnNames}, fieldName={measurement names}
+func summaryValue(summary [][]string, items []*MeasurementItem, columnName, fieldName string) string {
+	columnNames, found1 := summaryStatValues(summary, items, summaryColumnNames[0])
+	columnValues, found2 := summaryStatValues(summary, items, columnName)
+	_, index := find(columnNames, fieldName) // exact match
+	if !found1 || !found2 || index < 0 {
+		return ""
+	}
+	return columnValues[index]
+}
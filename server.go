@@ -0,0 +1,242 @@
+package main // server.go is the WebSocket publish/subscribe server for streaming sensor data (Python, Golang, Java clients).
+// Listens on WSPORT (netcdf.env). Connect to ws://<host>:<WSPORT>/subscribe and send JSON requests:
+//   {"action":"subscribe","pattern":"root.ecobee.household.<id>.*"}
+//   {"action":"subscribe","pattern":"root.ecobee.household.*.*","units":["F"],"where":">=80","window":{"kind":"tumbling","seconds":3600},"policy":"disconnect"}
+//   {"action":"unsubscribe","pattern":"root.ecobee.household.<id>.*"}
+// The server answers each request with a StreamReply and then sends one SensorFrame JSON message per matching device row.
+// When started as `netcdf replay ...` the replay is controlled with:
+//   {"action":"start"} {"action":"pause"} {"action":"resume"} {"action":"stop"}
+//   {"action":"seek","time":"2017-01-15T00:00:00Z"} {"action":"speed","speed":60}
+
+import (
+	"filesystem" // work module
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	defaultWsPort     = "9898"
+	subscribeEndpoint = "/subscribe"
+	actionSubscribe   = "subscribe"
+	actionUnsubscribe = "unsubscribe"
+)
+
+// StreamRequest is sent by a client.
+type StreamRequest struct {
+	Action  string  `json:"action"`
+	Pattern string  `json:"pattern"`
+	Time    string  `json:"time"`  // seek target
+	Speed   float64 `json:"speed"` // replay speed factor
+	SubscriptionOptions
+}
+
+// StreamReply acknowledges a StreamRequest.
+type StreamReply struct {
+	Action  string `json:"action"`
+	Pattern string `json:"pattern"`
+	Status  string `json:"status"` // ok or error text
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	CheckOrigin:     func(r *http.Request) bool { return true }, // clients are not browsers on the same origin.
+}
+
+// SensorStreamServer owns the hub shared by every connection.
+type SensorStreamServer struct {
+	Hub    *SensorStreamHub
+	Port   string
+	Replay *DatasetReplay // nil unless started with the replay command.
+}
+
+func GetWebSocketPort() string {
+	port := os.Getenv("WSPORT")
+	if len(port) == 0 {
+		return defaultWsPort
+	}
+	return port
+}
+
+// One goroutine writes to the connection; each subscription forwards its frames to that writer.
+func (server *SensorStreamServer) handleSubscribe(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		fmt.Println("handleSubscribe(Upgrade): " + err.Error())
+		return
+	}
+	defer conn.Close()
+	fmt.Println("Client connected from " + r.RemoteAddr)
+
+	outbound := make(chan interface{}, frameBufferSize)
+	done := make(chan struct{})          // closed when the client disconnects.
+	writerStopped := make(chan struct{}) // closed when the connection can no longer be written.
+	var forwarders sync.WaitGroup
+	subscriptions := make(map[string]*Subscriber, 0) // pattern => subscriber
+	defer func() {
+		for _, sub := range subscriptions {
+			server.Hub.Unsubscribe(sub.Id)
+		}
+		close(done)
+		forwarders.Wait()
+		fmt.Println("Client disconnected from " + r.RemoteAddr)
+	}()
+
+	go func() {
+		defer close(writerStopped)
+		for {
+			select {
+			case <-done:
+				return
+			case message := <-outbound:
+				if err := conn.WriteJSON(message); err != nil {
+					fmt.Println("handleSubscribe(WriteJSON): " + err.Error())
+					conn.Close() // unblocks ReadJSON below.
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		var request StreamRequest
+		if err := conn.ReadJSON(&request); err != nil {
+			return
+		}
+		reply := StreamReply{Action: request.Action, Pattern: request.Pattern, Status: "ok"}
+		switch strings.ToLower(request.Action) {
+		case actionSubscribe:
+			if !strings.HasPrefix(request.Pattern, "root.") {
+				reply.Status = "pattern must start with root."
+				break
+			}
+			if sub, ok := subscriptions[request.Pattern]; ok && server.Hub.IsSubscribed(sub.Id) {
+				break
+			}
+			sub, err := server.Hub.Subscribe(request.Pattern, request.SubscriptionOptions)
+			if err != nil {
+				reply.Status = err.Error()
+				break
+			}
+			subscriptions[request.Pattern] = sub
+			forwarders.Add(1)
+			go func() {
+				defer forwarders.Done()
+				for frame := range sub.Frames {
+					select {
+					case outbound <- frame:
+					case <-done:
+						return
+					case <-writerStopped:
+						return
+					}
+				}
+				if sub.Disconnected {
+					select {
+					case outbound <- StreamReply{Action: actionUnsubscribe, Pattern: sub.Pattern, Status: "disconnected: subscriber buffer is full"}:
+					case <-done:
+					case <-writerStopped:
+					}
+				}
+			}()
+		case actionUnsubscribe:
+			sub, ok := subscriptions[request.Pattern]
+			if !ok {
+				reply.Status = "not subscribed"
+				break
+			}
+			server.Hub.Unsubscribe(sub.Id)
+			delete(subscriptions, request.Pattern)
+		case actionStart, actionPause, actionResume, actionStop, actionSeek, actionSpeed:
+			reply.Status = server.controlReplay(request)
+		default:
+			reply.Status = "unknown action; expected subscribe or unsubscribe"
+		}
+		select {
+		case outbound <- reply:
+		case <-writerStopped:
+			return
+		}
+	}
+}
+
+// Return reply status.
+func (server *SensorStreamServer) controlReplay(request StreamRequest) string {
+	if server.Replay == nil {
+		return "no replay; start the server with the replay command"
+	}
+	ok := false
+	switch strings.ToLower(request.Action) {
+	case actionStart:
+		ok = server.Replay.Start()
+	case actionPause:
+		ok = server.Replay.Pause()
+	case actionResume:
+		ok = server.Replay.Resume()
+	case actionStop:
+		ok = server.Replay.Stop()
+	case actionSeek:
+		seekTime, err := filesystem.GetStartTimeFromLongint(request.Time)
+		if err != nil {
+			return "bad seek time: " + request.Time
+		}
+		ok = server.Replay.Seek(seekTime)
+	case actionSpeed:
+		if request.Speed < 0 {
+			return "speed must be >= 0"
+		}
+		ok = server.Replay.SetSpeed(request.Speed)
+	}
+	if !ok {
+		return "replay has stopped"
+	}
+	return "ok"
+}
+
+// Blocks serving WebSocket, gRPC, REST and SSE clients. serve: data inserted into IoTDB by other netcdf processes is streamed by the IotdbTail.
+// replay: frames come from the replayed dataset only.
+func ServeSensorStreams(programArgs []string) {
+	server := SensorStreamServer{Hub: NewSensorStreamHub(), Port: GetWebSocketPort()}
+	isReplay := strings.EqualFold(programArgs[1], "replay")
+	needsIotdb := !isReplay || (len(programArgs) > 2 && strings.HasPrefix(programArgs[2], "root."))
+	iotdbConnection, ok := Init_IoTDB(needsIotdb) // gRPC queries use IoTDB even when replaying a file.
+	if !ok {
+		checkErr("Init_IoTDB: ", fmt.Errorf("IoTDB not available at %s", iotdbConnection))
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	if isReplay {
+		replay, err := LoadReplay(programArgs, server.Hub.Publish)
+		checkErr("LoadReplay", err)
+		server.Replay = replay
+		server.Hub.AddUnits(replay.Units)
+		go replay.Run()
+		fmt.Println("Replay is paused; send {\"action\":\"start\"} after subscribing.")
+	} else {
+		go func() {
+			err := NewIotdbTail(server.Hub).Run(stop)
+			checkErr("IotdbTail.Run", err)
+		}()
+	}
+
+	service := NewSensorStreamService(server.Hub)
+	go func() {
+		err := ServeGrpc(service)
+		checkErr("ServeGrpc", err)
+	}()
+	go func() {
+		err := ServeHttp(service)
+		checkErr("ServeHttp", err)
+	}()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(subscribeEndpoint, server.handleSubscribe)
+	fmt.Println("Streaming sensor data at ws://0.0.0.0:" + server.Port + subscribeEndpoint)
+	err := http.ListenAndServe(":"+server.Port, mux)
+	checkErr("ListenAndServe", err)
+}
@@ -0,0 +1,455 @@
+package main // stream.go fans out sensor measurement frames to publish/subscribe clients.
+// Subscribers name IoTDB path patterns: `*` matches exactly one path node and `**` matches one or more nodes.
+// Example: root.ecobee.household.*.Indoor_AverageTemperature  or  root.ecobee.household.**
+// Frames arrive either from the IoTDB tail (data inserted by other netcdf processes) or from in-process publishers such as a replay.
+// The tail notices inserts by the growing number of points of each series, not by their time, so historical datasets stream as they are ingested.
+
+import (
+	"errors"
+	"filesystem" // work module
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apache/iotdb-client-go/client"
+)
+
+const (
+	frameBufferSize  = 1024
+	tailPollInterval = time.Second
+	tailQueryTimeout = int64(30000) // milliseconds
+	anyOneNode       = "*"
+	anyNodes         = "**"
+	iotdbPathSep     = "."
+)
+
+var iotdbAggregations = []string{"avg", "sum", "count", "min_value", "max_value", "first_value", "last_value", "extreme", "min_time", "max_time"}
+
+// SensorFrame is one aligned row of measurements for a single device at a single time.
+type SensorFrame struct {
+	Device       string                 `json:"device"`    // root.ecobee.household.<id>
+	Timestamp    int64                  `json:"timestamp"` // unix milliseconds UTC
+	Measurements map[string]interface{} `json:"measurements"`
+}
+
+// Return the full IoTDB path for one measurement of the frame.
+func (sf SensorFrame) MeasurementPath(measurement string) string {
+	return sf.Device + iotdbPathSep + measurement
+}
+
+// Subscriber receives the frames that match its path pattern and SubscriptionOptions.
+type Subscriber struct {
+	Id           string              `json:"id"`
+	Pattern      string              `json:"pattern"`
+	Options      SubscriptionOptions `json:"options"`
+	Frames       chan SensorFrame    `json:"-"`
+	Disconnected bool                `json:"disconnected"` // set before Frames is closed by the disconnect policy.
+	predicate    *ValuePredicate
+	window       *WindowAggregator
+	dropped      int64
+	mutex        sync.Mutex
+}
+
+// SensorStreamHub routes published frames to every matching Subscriber. Safe for concurrent use.
+type SensorStreamHub struct {
+	mutex       sync.RWMutex
+	subscribers map[string]*Subscriber
+	units       map[string]string // full measurement path or bare measurement name => TAGS('units')
+}
+
+func NewSensorStreamHub() *SensorStreamHub {
+	return &SensorStreamHub{subscribers: make(map[string]*Subscriber, 0), units: make(map[string]string, 0)}
+}
+
+func (hub *SensorStreamHub) Subscribe(pattern string, options SubscriptionOptions) (*Subscriber, error) {
+	sub, err := NewSubscriber(pattern, options)
+	if err != nil {
+		return nil, err
+	}
+	hub.Register(sub)
+	return sub, nil
+}
+
+// A Subscriber that receives no frames until Register; its history can be shaped first.
+func NewSubscriber(pattern string, options SubscriptionOptions) (*Subscriber, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	sub := &Subscriber{Id: filesystem.GetRandomIdentifier(), Pattern: strings.TrimSpace(pattern), Options: options, Frames: make(chan SensorFrame, options.BufferSize)}
+	if len(strings.TrimSpace(options.Where)) > 0 {
+		sub.predicate, _ = ParseValuePredicate(options.Where)
+	}
+	if options.Window != nil {
+		sub.window = NewWindowAggregator(*options.Window)
+	}
+	return sub, nil
+}
+
+func (hub *SensorStreamHub) Register(sub *Subscriber) {
+	hub.mutex.Lock()
+	hub.subscribers[sub.Id] = sub
+	hub.mutex.Unlock()
+}
+
+// Shape the stored frames of a subscriber that is not yet registered, so its window sees them before any live frame.
+func (hub *SensorStreamHub) ShapeHistory(sub *Subscriber, history []SensorFrame) []SensorFrame {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+	shaped := make([]SensorFrame, 0, len(history))
+	for _, frame := range history {
+		shaped = append(shaped, sub.Shape(frame, hub.unitsOf)...)
+	}
+	return shaped
+}
+
+// Closes the subscriber channel.
+func (hub *SensorStreamHub) Unsubscribe(id string) {
+	hub.remove(id, false)
+}
+
+func (hub *SensorStreamHub) remove(id string, disconnected bool) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	sub, ok := hub.subscribers[id]
+	if ok {
+		delete(hub.subscribers, id)
+		sub.Disconnected = disconnected
+		close(sub.Frames)
+	}
+}
+
+func (hub *SensorStreamHub) IsSubscribed(id string) bool {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+	_, ok := hub.subscribers[id]
+	return ok
+}
+
+// Register measurement units for the units filter. Keys are full paths (root.a.b.c) or bare measurement names that apply to every device.
+func (hub *SensorStreamHub) AddUnits(units map[string]string) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	for name, unit := range units {
+		hub.units[name] = unit
+	}
+}
+
+// The caller holds hub.mutex.
+func (hub *SensorStreamHub) unitsOf(device, measurement string) string {
+	if unit, ok := hub.units[device+iotdbPathSep+measurement]; ok {
+		return unit
+	}
+	return hub.units[measurement]
+}
+
+// Return the distinct patterns of all current subscribers.
+func (hub *SensorStreamHub) Patterns() []string {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+	distinct := make(map[string]bool, 0)
+	patterns := make([]string, 0)
+	for _, sub := range hub.subscribers {
+		if !distinct[sub.Pattern] {
+			distinct[sub.Pattern] = true
+			patterns = append(patterns, sub.Pattern)
+		}
+	}
+	return patterns
+}
+
+// Deliver frame to each subscriber. Never blocks: a full subscriber buffer drops its oldest frame or disconnects the subscriber.
+func (hub *SensorStreamHub) Publish(frame SensorFrame) {
+	slow := make([]string, 0)
+	hub.mutex.RLock()
+	for _, sub := range hub.subscribers {
+		if !sub.deliver(frame, hub.unitsOf) {
+			slow = append(slow, sub.Id)
+		}
+	}
+	hub.mutex.RUnlock()
+	for _, id := range slow {
+		fmt.Println("Subscriber " + id + " is not keeping up; disconnected.")
+		hub.remove(id, true)
+	}
+}
+
+// Return a copy of frame holding only the measurements whose full path matches pattern; false if none match.
+func FilterFrame(frame SensorFrame, pattern string) (SensorFrame, bool) {
+	matched := SensorFrame{Device: frame.Device, Timestamp: frame.Timestamp, Measurements: make(map[string]interface{}, 0)}
+	for name, value := range frame.Measurements {
+		if MatchIotdbPath(pattern, frame.MeasurementPath(name)) {
+			matched.Measurements[name] = value
+		}
+	}
+	return matched, len(matched.Measurements) > 0
+}
+
+// IoTDB path pattern matching: `*` is one node; `**` is one or more nodes.
+func MatchIotdbPath(pattern, path string) bool {
+	return matchPathNodes(strings.Split(pattern, iotdbPathSep), strings.Split(path, iotdbPathSep))
+}
+
+func matchPathNodes(pattern, nodes []string) bool {
+	if len(pattern) == 0 {
+		return len(nodes) == 0
+	}
+	if pattern[0] == anyNodes {
+		for ndx := 1; ndx <= len(nodes); ndx++ {
+			if matchPathNodes(pattern[1:], nodes[ndx:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(nodes) == 0 {
+		return false
+	}
+	if pattern[0] != anyOneNode && pattern[0] != nodes[0] {
+		return false
+	}
+	return matchPathNodes(pattern[1:], nodes[1:])
+}
+
+// Split root.a.b.c into (root.a.b, c).
+func splitIotdbPath(fullPath string) (string, string) {
+	index := strings.LastIndex(fullPath, iotdbPathSep)
+	if index < 0 {
+		return "", fullPath
+	}
+	return fullPath[:index], fullPath[index+1:]
+}
+
+// Split a result column into (device, measurement). Aggregation columns keep their function: avg(root.a.b.c) => (root.a.b, avg(c))
+func splitColumnName(columnName string) (string, string) {
+	open := strings.Index(columnName, "(")
+	if open > 0 && strings.HasSuffix(columnName, ")") {
+		device, measurement := splitIotdbPath(columnName[open+1 : len(columnName)-1])
+		return device, columnName[:open] + "(" + measurement + ")"
+	}
+	return splitIotdbPath(columnName)
+}
+
+// Raw or aggregated values of path within [startTime, endTime) unix milliseconds. intervalMs > 0 groups an aggregation into time windows.
+// root.ecobee.household.*.Indoor_AverageTemperature, avg, 3600000 => SELECT avg(Indoor_AverageTemperature) FROM root.ecobee.household.* GROUP BY ([start, end), 3600000ms)
+func BuildRangeQuery(path string, startTime, endTime int64, aggregation string, intervalMs int64) (string, error) {
+	prefix, suffix := splitIotdbPath(strings.TrimSpace(path))
+	if !strings.HasPrefix(prefix, "root") || len(suffix) == 0 {
+		return "", errors.New("path must be root.<device>.<measurement>: " + path)
+	}
+	if endTime <= 0 {
+		endTime = time.Now().UTC().UnixMilli() + 1
+	}
+	start := strconv.FormatInt(msToIotdb(startTime), 10)
+	end := strconv.FormatInt(msToIotdb(endTime), 10)
+	aggregation = strings.ToLower(strings.TrimSpace(aggregation))
+	if len(aggregation) == 0 {
+		return "SELECT " + suffix + " FROM " + prefix + " WHERE time >= " + start + " AND time < " + end, nil
+	}
+	if !contains(iotdbAggregations, aggregation) {
+		return "", errors.New("unsupported aggregation " + aggregation + "; expected one of " + strings.Join(iotdbAggregations, ", "))
+	}
+	sql := "SELECT " + aggregation + "(" + suffix + ") FROM " + prefix
+	if intervalMs > 0 {
+		return sql + " GROUP BY ([" + start + ", " + end + "), " + strconv.FormatInt(intervalMs, 10) + "ms)", nil
+	}
+	return sql + " WHERE time >= " + start + " AND time < " + end, nil
+}
+
+// Turn a subscription pattern into a raw data query: root.ecobee.household.*.* => SELECT * FROM root.ecobee.household.*
+// afterTime is an IoTDB timestamp, so rows within the same millisecond are not delivered twice; math.MinInt64 selects every row.
+func patternQuery(pattern string, afterTime int64) string {
+	prefix, suffix := splitIotdbPath(pattern)
+	if afterTime == math.MinInt64 {
+		return "SELECT " + suffix + " FROM " + prefix
+	}
+	return "SELECT " + suffix + " FROM " + prefix + " WHERE time > " + strconv.FormatInt(afterTime, 10)
+}
+
+// The number of points and latest time of each series of a pattern: root.ecobee.household.*.* => SELECT count(*), max_time(*) FROM root.ecobee.household.*
+func patternMarksQuery(pattern string) string {
+	prefix, suffix := splitIotdbPath(pattern)
+	return "SELECT count(" + suffix + "), max_time(" + suffix + ") FROM " + prefix
+}
+
+// Convert every row of a time-aligned SessionDataSet into one frame per device (unix milliseconds). Returns the latest IoTDB timestamp seen.
+// keep, when not nil, chooses the values by series path and IoTDB timestamp.
+func sessionDataSetFrames(sds *client.SessionDataSet, keep func(path string, timestamp int64) bool, publish func(SensorFrame)) (int64, error) {
+	latest := int64(-1)
+	for next, err := sds.Next(); next; next, err = sds.Next() {
+		if err != nil {
+			return latest, err
+		}
+		timestamp := iotdbToMs(sds.GetTimestamp())
+		if sds.GetTimestamp() > latest {
+			latest = sds.GetTimestamp()
+		}
+		frames := make(map[string]*SensorFrame, 0)
+		for ndx := 0; ndx < sds.GetColumnCount(); ndx++ {
+			columnName := sds.GetColumnName(ndx)
+			value := sds.GetValue(columnName)
+			if value == nil || (keep != nil && !keep(columnName, sds.GetTimestamp())) {
+				continue
+			}
+			device, measurement := splitColumnName(columnName)
+			frame, ok := frames[device]
+			if !ok {
+				frame = &SensorFrame{Device: device, Timestamp: timestamp, Measurements: make(map[string]interface{}, 0)}
+				frames[device] = frame
+			}
+			frame.Measurements[measurement] = value
+		}
+		for _, frame := range frames {
+			publish(*frame)
+		}
+	}
+	return latest, nil
+}
+
+// seriesMark is what the tail has seen of one series: its number of points and the IoTDB timestamp of its latest delivered point.
+type seriesMark struct {
+	Count int64
+	Last  int64
+}
+
+// IotdbTail polls the number of points of each series of each subscribed pattern. When it grows, the rows after the last delivered
+// time of that series are published to the hub, whatever their timestamps. Points inserted before the last delivered time of their
+// series (out of order) are counted but not streamed.
+type IotdbTail struct {
+	IoTDbAccess
+	Hub      *SensorStreamHub
+	Interval time.Duration
+	marks    map[string]map[string]seriesMark // pattern => series path => mark
+}
+
+func NewIotdbTail(hub *SensorStreamHub) *IotdbTail {
+	return &IotdbTail{Hub: hub, Interval: tailPollInterval, marks: make(map[string]map[string]seriesMark, 0)}
+}
+
+// Runs until stop is closed. Assumes clientConfig has been assigned by Init_IoTDB().
+func (tail *IotdbTail) Run(stop <-chan struct{}) error {
+	tail.IoTDbAccess.session = client.NewSession(clientConfig)
+	if err := tail.IoTDbAccess.session.Open(false, 0); err != nil {
+		return err
+	}
+	tail.ActiveSession = true
+	defer tail.IoTDbAccess.session.Close()
+
+	ticker := time.NewTicker(tail.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+			tail.poll()
+		}
+	}
+}
+
+// The count and max_time of each series of pattern.
+func (tail *IotdbTail) readMarks(pattern string) (map[string]seriesMark, error) {
+	timeout := tailQueryTimeout
+	tail.Sql = patternMarksQuery(pattern)
+	sds, err := tail.IoTDbAccess.session.ExecuteQueryStatement(tail.Sql, &timeout)
+	if err != nil {
+		return nil, errors.New(tail.Sql + ": " + err.Error())
+	}
+	defer sds.Close()
+	marks := make(map[string]seriesMark, 0)
+	if next, err := sds.Next(); !next || err != nil {
+		return marks, err
+	}
+	for ndx := 0; ndx < sds.GetColumnCount(); ndx++ {
+		columnName := sds.GetColumnName(ndx)
+		value, ok := sds.GetValue(columnName).(int64)
+		if !ok {
+			continue
+		}
+		if path, found := strings.CutPrefix(columnName, "count("); found {
+			path = strings.TrimSuffix(path, ")")
+			mark := marks[path]
+			mark.Count = value
+			marks[path] = mark
+		} else if path, found := strings.CutPrefix(columnName, "max_time("); found {
+			path = strings.TrimSuffix(path, ")")
+			mark := marks[path]
+			mark.Last = value
+			marks[path] = mark
+		}
+	}
+	return marks, nil
+}
+
+func (tail *IotdbTail) poll() {
+	patterns := tail.Hub.Patterns()
+	for pattern := range tail.marks {
+		if !contains(patterns, pattern) { // a later subscriber again starts from the rows of its subscription.
+			delete(tail.marks, pattern)
+		}
+	}
+	for _, pattern := range patterns {
+		marks, seen := tail.marks[pattern]
+		current, err := tail.readMarks(pattern)
+		if err != nil {
+			fmt.Println("IotdbTail: " + err.Error())
+			continue
+		}
+		if !seen { // only stream data inserted after the first subscription.
+			profiles, err := GetTimeseriesProfiles(&tail.IoTDbAccess.session, pattern)
+			if err != nil {
+				fmt.Println("IotdbTail: " + err.Error())
+			}
+			tail.Hub.AddUnits(profileUnits(profiles))
+			tail.marks[pattern] = current
+			continue
+		}
+		grown := make(map[string]int64, 0) // series path => last delivered time
+		afterTime := int64(math.MaxInt64)
+		for path, mark := range current {
+			previous, known := marks[path]
+			if !known {
+				previous.Last = math.MinInt64 // a new series: all of its points are new
+			}
+			if mark.Count > previous.Count {
+				grown[path] = previous.Last
+				afterTime = min(afterTime, previous.Last)
+			} else {
+				current[path] = seriesMark{Count: mark.Count, Last: previous.Last}
+			}
+		}
+		if len(grown) > 0 {
+			timeout := tailQueryTimeout
+			tail.Sql = patternQuery(pattern, afterTime)
+			sds, err := tail.IoTDbAccess.session.ExecuteQueryStatement(tail.Sql, &timeout)
+			if err != nil {
+				fmt.Println("IotdbTail: " + tail.Sql + ": " + err.Error())
+				continue // the marks stay, so the rows are tried again
+			}
+			delivered := make(map[string]int64, len(grown))
+			_, err = sessionDataSetFrames(sds, func(path string, timestamp int64) bool {
+				last, ok := grown[path]
+				if !ok || timestamp <= last {
+					return false
+				}
+				if latest, found := delivered[path]; !found || timestamp > latest {
+					delivered[path] = timestamp
+				}
+				return true
+			}, tail.Hub.Publish)
+			sds.Close()
+			if err != nil {
+				fmt.Println("IotdbTail: " + err.Error())
+			}
+			for path, last := range grown { // rows inserted after the count are delivered once, and counted by the next poll
+				if latest, found := delivered[path]; found {
+					last = max(last, latest)
+				}
+				current[path] = seriesMark{Count: current[path].Count, Last: last}
+			}
+		}
+		tail.marks[pattern] = current
+	}
+}
@@ -0,0 +1,371 @@
+package main // subscription.go narrows what each subscriber of the SensorStreamHub receives and how fast it must read.
+// A raw firehose of 990 houses x 30 variables is rarely wanted, so a subscription may ask for:
+//   measurements  only these measurement names, e.g. ["Indoor_AverageTemperature","T_ctrl"]
+//   units         only measurements tagged with these TAGS('units'), e.g. ["F"]
+//   where         a value predicate "[measurement] op number", e.g. "T_ctrl>=75" keeps frames where T_ctrl >= 75,
+//                 ">=75" keeps only the measurements whose value is >= 75
+//   window        tumbling or sliding mean/min/max over N seconds of dataset time: {"kind":"sliding","seconds":3600,"step":300}
+//   buffer/policy the subscriber buffer size and what happens when it is full: drop-oldest (default) or disconnect
+// Windows close when a later frame of the same device arrives, so a replay at any speed yields the same aggregates.
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	policyDropOldest    = "drop-oldest"
+	policyDisconnect    = "disconnect"
+	windowTumbling      = "tumbling"
+	windowSliding       = "sliding"
+	maxSubscriberBuffer = 65536
+)
+
+var (
+	overflowPolicies   = []string{policyDropOldest, policyDisconnect}
+	windowKinds        = []string{windowTumbling, windowSliding}
+	windowAggregates   = []string{"mean", "min", "max"}
+	predicateOperators = []string{">=", "<=", "!=", "==", ">", "<", "="} // two character operators first
+)
+
+// WindowSpec asks for aggregates over windows of Seconds, emitted every Step seconds (Step == Seconds for tumbling windows).
+type WindowSpec struct {
+	Kind       string   `json:"kind"`
+	Seconds    int64    `json:"seconds"`
+	Step       int64    `json:"step,omitempty"`
+	Aggregates []string `json:"aggregates,omitempty"` // default mean, min, max
+}
+
+// SubscriptionOptions are the server-side filters, window and backpressure policy of one subscription. The zero value is the raw stream.
+type SubscriptionOptions struct {
+	Measurements []string    `json:"measurements,omitempty"`
+	Units        []string    `json:"units,omitempty"`
+	Where        string      `json:"where,omitempty"`
+	Window       *WindowSpec `json:"window,omitempty"`
+	BufferSize   int         `json:"buffer,omitempty"`
+	Policy       string      `json:"policy,omitempty"`
+}
+
+// ValuePredicate is a parsed SubscriptionOptions.Where; an empty Measurement applies to every numeric measurement.
+type ValuePredicate struct {
+	Measurement string
+	Operator    string
+	Value       float64
+}
+
+// "T_ctrl>=75" => {T_ctrl >= 75}; ">=75" => {>= 75}
+func ParseValuePredicate(where string) (*ValuePredicate, error) {
+	where = strings.TrimSpace(where)
+	for _, operator := range predicateOperators {
+		index := strings.Index(where, operator)
+		if index < 0 {
+			continue
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(where[index+len(operator):]), 64)
+		if err != nil {
+			return nil, errors.New("predicate value must be a number: " + where)
+		}
+		return &ValuePredicate{Measurement: strings.TrimSpace(where[:index]), Operator: operator, Value: value}, nil
+	}
+	return nil, errors.New("predicate needs one of " + strings.Join(predicateOperators, " ") + ": " + where)
+}
+
+func (vp *ValuePredicate) Test(value float64) bool {
+	switch vp.Operator {
+	case ">=":
+		return value >= vp.Value
+	case "<=":
+		return value <= vp.Value
+	case "!=":
+		return value != vp.Value
+	case ">":
+		return value > vp.Value
+	case "<":
+		return value < vp.Value
+	default: // == and =
+		return value == vp.Value
+	}
+}
+
+// Return the value as float64 when it is numeric.
+func numericValue(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// Fill in defaults and reject unknown values.
+func (options *SubscriptionOptions) Validate() error {
+	if options.BufferSize <= 0 {
+		options.BufferSize = frameBufferSize
+	}
+	if options.BufferSize > maxSubscriberBuffer {
+		return fmt.Errorf("buffer must be at most %d frames", maxSubscriberBuffer)
+	}
+	options.Policy = strings.ToLower(strings.TrimSpace(options.Policy))
+	if len(options.Policy) == 0 {
+		options.Policy = policyDropOldest
+	}
+	if !contains(overflowPolicies, options.Policy) {
+		return errors.New("policy must be one of " + strings.Join(overflowPolicies, ", "))
+	}
+	if len(strings.TrimSpace(options.Where)) > 0 {
+		if _, err := ParseValuePredicate(options.Where); err != nil {
+			return err
+		}
+	}
+	if options.Window == nil {
+		return nil
+	}
+	window := options.Window
+	window.Kind = strings.ToLower(strings.TrimSpace(window.Kind))
+	if len(window.Kind) == 0 {
+		window.Kind = windowTumbling
+	}
+	if !contains(windowKinds, window.Kind) {
+		return errors.New("window kind must be one of " + strings.Join(windowKinds, ", "))
+	}
+	if window.Seconds <= 0 {
+		return errors.New("window seconds must be > 0")
+	}
+	if window.Kind == windowTumbling || window.Step <= 0 {
+		window.Step = window.Seconds
+	}
+	if window.Step > window.Seconds {
+		return errors.New("window step must not exceed window seconds")
+	}
+	if len(window.Aggregates) == 0 {
+		window.Aggregates = windowAggregates
+	}
+	for _, aggregate := range window.Aggregates {
+		if !contains(windowAggregates, aggregate) {
+			return errors.New("window aggregate must be one of " + strings.Join(windowAggregates, ", ") + ": " + aggregate)
+		}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+type windowSample struct {
+	Timestamp int64
+	Value     float64
+}
+
+// windowState holds the samples of one device that can still fall into an open window.
+type windowState struct {
+	nextEnd int64                     // end (exclusive, unix milliseconds) of the next window to emit
+	samples map[string][]windowSample // measurement => samples in time order
+}
+
+// WindowAggregator turns frames into one aggregate frame per device and window, stamped with the window start like IoTDB GROUP BY.
+type WindowAggregator struct {
+	Spec    WindowSpec
+	sizeMs  int64
+	stepMs  int64
+	devices map[string]*windowState
+}
+
+func NewWindowAggregator(spec WindowSpec) *WindowAggregator {
+	return &WindowAggregator{Spec: spec, sizeMs: spec.Seconds * 1000, stepMs: spec.Step * 1000, devices: make(map[string]*windowState, 0)}
+}
+
+// Add the numeric measurements of frame and return the frames of every window that closed before it.
+func (wa *WindowAggregator) Add(frame SensorFrame) []SensorFrame {
+	state, ok := wa.devices[frame.Device]
+	if !ok {
+		state = &windowState{nextEnd: wa.stepEnd(frame.Timestamp), samples: make(map[string][]windowSample, 0)}
+		wa.devices[frame.Device] = state
+	}
+	closed := make([]SensorFrame, 0)
+	for frame.Timestamp >= state.nextEnd {
+		if aggregate, ok := wa.aggregate(frame.Device, state); ok {
+			closed = append(closed, aggregate)
+		}
+		state.nextEnd += wa.stepMs
+		if state.prune(state.nextEnd - wa.sizeMs) {
+			state.nextEnd = wa.stepEnd(frame.Timestamp) // skip the empty windows of a gap
+		}
+	}
+	for name, value := range frame.Measurements {
+		if v, ok := numericValue(value); ok {
+			state.samples[name] = append(state.samples[name], windowSample{Timestamp: frame.Timestamp, Value: v})
+		}
+	}
+	return closed
+}
+
+// End of the step that contains timestamp.
+func (wa *WindowAggregator) stepEnd(timestamp int64) int64 {
+	end := timestamp - timestamp%wa.stepMs + wa.stepMs
+	if timestamp < 0 && timestamp%wa.stepMs != 0 {
+		end -= wa.stepMs
+	}
+	return end
+}
+
+// Drop samples before startTime; return true when nothing is left.
+func (state *windowState) prune(startTime int64) bool {
+	for name, samples := range state.samples {
+		keep := 0
+		for keep < len(samples) && samples[keep].Timestamp < startTime {
+			keep++
+		}
+		if keep == len(samples) {
+			delete(state.samples, name)
+		} else {
+			state.samples[name] = samples[keep:]
+		}
+	}
+	return len(state.samples) == 0
+}
+
+func (wa *WindowAggregator) aggregate(device string, state *windowState) (SensorFrame, bool) {
+	startTime := state.nextEnd - wa.sizeMs
+	frame := SensorFrame{Device: device, Timestamp: startTime, Measurements: make(map[string]interface{}, 0)}
+	for name, samples := range state.samples {
+		count, sum, minimum, maximum := 0, 0.0, 0.0, 0.0
+		for _, sample := range samples {
+			if sample.Timestamp < startTime || sample.Timestamp >= state.nextEnd {
+				continue
+			}
+			if count == 0 || sample.Value < minimum {
+				minimum = sample.Value
+			}
+			if count == 0 || sample.Value > maximum {
+				maximum = sample.Value
+			}
+			sum += sample.Value
+			count++
+		}
+		if count == 0 {
+			continue
+		}
+		for _, aggregate := range wa.Spec.Aggregates {
+			switch aggregate {
+			case "mean":
+				frame.Measurements["mean("+name+")"] = sum / float64(count)
+			case "min":
+				frame.Measurements["min("+name+")"] = minimum
+			case "max":
+				frame.Measurements["max("+name+")"] = maximum
+			}
+		}
+	}
+	return frame, len(frame.Measurements) > 0
+}
+
+// Apply the pattern, measurement, units and value filters to frame; false if nothing is left.
+func (sub *Subscriber) match(frame SensorFrame, unitsOf func(device, measurement string) string) (SensorFrame, bool) {
+	matched, ok := FilterFrame(frame, sub.Pattern)
+	if !ok {
+		return matched, false
+	}
+	for name, value := range matched.Measurements {
+		if len(sub.Options.Measurements) > 0 && !contains(sub.Options.Measurements, name) {
+			delete(matched.Measurements, name)
+			continue
+		}
+		if len(sub.Options.Units) > 0 && !containsFold(sub.Options.Units, unitsOf(matched.Device, name)) {
+			delete(matched.Measurements, name)
+			continue
+		}
+		if sub.predicate != nil && len(sub.predicate.Measurement) == 0 {
+			if v, ok := numericValue(value); !ok || !sub.predicate.Test(v) {
+				delete(matched.Measurements, name)
+			}
+		}
+	}
+	if sub.predicate != nil && len(sub.predicate.Measurement) > 0 {
+		v, ok := numericValue(frame.Measurements[sub.predicate.Measurement])
+		if !ok || !sub.predicate.Test(v) {
+			return matched, false
+		}
+	}
+	return matched, len(matched.Measurements) > 0
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter, aggregate and enqueue frame. Returns false when the disconnect policy requires the subscriber to be removed.
+func (sub *Subscriber) deliver(frame SensorFrame, unitsOf func(device, measurement string) string) bool {
+	matched, ok := sub.match(frame, unitsOf)
+	if !ok {
+		return true
+	}
+	sub.mutex.Lock()
+	defer sub.mutex.Unlock()
+	for _, f := range sub.aggregate(matched) {
+		if !sub.enqueue(f) {
+			return false
+		}
+	}
+	return true
+}
+
+// The frames of a matched frame: itself, or the windows it completes. The caller holds sub.mutex.
+func (sub *Subscriber) aggregate(matched SensorFrame) []SensorFrame {
+	if sub.window != nil {
+		return sub.window.Add(matched)
+	}
+	return []SensorFrame{matched}
+}
+
+// Filter and aggregate a stored frame the way deliver does, for frames sent to the client before the live ones (history).
+func (sub *Subscriber) Shape(frame SensorFrame, unitsOf func(device, measurement string) string) []SensorFrame {
+	matched, ok := sub.match(frame, unitsOf)
+	if !ok {
+		return nil
+	}
+	sub.mutex.Lock()
+	defer sub.mutex.Unlock()
+	return sub.aggregate(matched)
+}
+
+// Never blocks: a full buffer either drops its oldest frame or reports that the subscriber must be disconnected.
+func (sub *Subscriber) enqueue(frame SensorFrame) bool {
+	for {
+		select {
+		case sub.Frames <- frame:
+			return true
+		default:
+		}
+		if sub.Options.Policy == policyDisconnect {
+			return false
+		}
+		select {
+		case <-sub.Frames:
+			sub.dropped++
+			if sub.dropped == 1 {
+				fmt.Println("Subscriber " + sub.Id + " is not keeping up; dropping its oldest frames.")
+			}
+		default:
+		}
+	}
+}
@@ -0,0 +1,373 @@
+package main // synthetic.go produces random time series instances of a CSV dataset as test data for digital-twin simulations.
+// netcdf synthesize <dataFile.csv> <timeMeasurementName> [devices=3] [seed=1] [rows=0] [start=time] [sample=10000]
+//                   [output=iotdb|csv] [dir=.] [commands=createts,insert]
+// Each numeric measurement keeps the mean, stddev, min and max of the summary file. The first sample= rows of the data file supply
+// its daily (hour of day) profile and the lag-1 autocorrelation of what remains, so the synthetic series drift like the real one.
+// Text measurements repeat their observed states as often as the sample does. rows=0 produces as many rows as the sample.
+// The synthetic devices <dataset>_synthetic_<seed>_<n> have the schema of the dataset; the same seed produces the same devices.
+// output=csv writes <dir>/<device>.csv together with a copy of the summary file, ready for: netcdf <device>.csv <timeMeasurementName> createts insert
+
+import (
+	"encoding/csv"
+	"errors"
+	"filesystem" // work module
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	hoursPerDay        = 24
+	maxDecimals        = 6
+	deviceLevelSpread  = 0.1 // stddev of each synthetic device's level offset, as a fraction of the measurement stddev
+	unixLayoutPrefix   = "unix:"
+	syntheticSeparator = "_synthetic_"
+)
+
+var synthesizeParameters = map[string]string{"devices": "3", "seed": "1", "rows": "0", "start": "", "sample": "10000", "output": "iotdb", "dir": ".", "commands": "createts,insert"}
+var synthesizeOutputs = []string{"iotdb", "csv"}
+
+// MeasurementModel is what the generator learned about one data column.
+type MeasurementModel struct {
+	MeasurementItem
+	Numeric     bool
+	Mean        float64
+	Stddev      float64
+	Min         float64
+	Max         float64
+	Decimals    int                  // largest number of decimals in the sample
+	Daily       [hoursPerDay]float64 // deviation of the hour-of-day mean from Mean
+	Phi         float64              // lag-1 autocorrelation of the deseasonalized values
+	Sigma       float64              // stddev of the deseasonalized values
+	States      []string             // observed values of text columns
+	Persistence float64              // probability that a text value repeats
+}
+
+// DatasetModel generates rows of a dataset in the column order of its data file.
+type DatasetModel struct {
+	Header     []string
+	TimeColumn int
+	TimeLayout string // unixLayoutPrefix + epoch unit, or a time.Parse layout
+	Location   *time.Location
+	IntervalMs int64
+	StartTime  int64
+	Rows       int
+	Models     []*MeasurementModel // indexed by column; nil for columns that are not measurements
+}
+
+// Value of a summary statistic (summaryColumnNames) of item; false if it is missing or not a number.
+func (iot *IoTDbCsvDataFile) summaryFloat(item *MeasurementItem, statName string) (float64, bool) {
+	column := iot.GetColumnNumberFromName(statName)
+	if column < 0 || item.ColumnOrder+1 >= len(iot.Summary) || column >= len(iot.Summary[item.ColumnOrder+1]) {
+		return 0, false
+	}
+	value, err := strconv.ParseFloat(strings.TrimSpace(iot.Summary[item.ColumnOrder+1][column]), 64)
+	return value, err == nil
+}
+
+// The layout that reads value the way the dataset's TimestampParser does.
+func timeLayoutOf(value string, parser *filesystem.TimestampParser) (string, error) {
+	if layout := parser.CompactLayoutOf(value); len(layout) > 0 {
+		return layout, nil
+	}
+	if epoch, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return unixLayoutPrefix + parser.EpochUnitOf(epoch), nil
+	}
+	layouts := append(append(append([]string{}, parser.Layouts...), filesystem.ISO8601ZonedLayouts...), filesystem.ISO8601LocalLayouts...)
+	for _, layout := range layouts {
+		if _, err := time.Parse(layout, value); err == nil {
+			return layout, nil
+		}
+	}
+	return "", errors.New("unrecognized time: <" + value + ">")
+}
+
+// Wall-clock layouts are formatted in the zone of the dataset.
+func formatTimeLayout(timestamp int64, layout string, location *time.Location) string {
+	switch layout {
+	case unixLayoutPrefix + filesystem.EpochSeconds:
+		return strconv.FormatInt(timestamp/1000, 10)
+	case unixLayoutPrefix + filesystem.EpochMilliseconds:
+		return strconv.FormatInt(timestamp, 10)
+	case unixLayoutPrefix + filesystem.EpochMicroseconds:
+		return strconv.FormatInt(timestamp*1000, 10)
+	case unixLayoutPrefix + filesystem.EpochNanoseconds:
+		return strconv.FormatInt(timestamp*1000000, 10)
+	}
+	return time.UnixMilli(timestamp).In(location).Format(layout)
+}
+
+func decimalsOf(value string) int {
+	index := strings.Index(value, ".")
+	if index < 0 {
+		return 0
+	}
+	return min(len(value)-index-1, maxDecimals)
+}
+
+// Learn a DatasetModel from the summary statistics and the rows of sample (header first).
+func (iot *IoTDbCsvDataFile) LearnDatasetModel(sample [][]string) (*DatasetModel, error) {
+	if len(sample) < 3 {
+		return nil, errors.New("the sample of " + iot.DataFilePath + " needs at least 2 rows")
+	}
+	dm := DatasetModel{Header: sample[0], TimeColumn: iot.GetRowNumberFromName(iot.TimeMeasurementName) - 1, Location: iot.TimeParser.Location, Rows: len(sample) - 1, Models: make([]*MeasurementModel, len(sample[0]))}
+	if dm.TimeColumn < 0 || dm.TimeColumn >= len(dm.Header) {
+		return nil, errors.New("time measurement " + iot.TimeMeasurementName + " is not in the summary file")
+	}
+	var err error
+	dm.TimeLayout, err = timeLayoutOf(strings.TrimSpace(sample[1][dm.TimeColumn]), iot.TimeParser)
+	if err != nil {
+		return nil, err
+	}
+	timestamps := make([]int64, len(sample)-1)
+	times := make([]Sample, 0, len(sample)-1)
+	for r := 1; r < len(sample); r++ {
+		t, err := iot.TimeParser.Parse(sample[r][dm.TimeColumn])
+		if err != nil {
+			return nil, errors.New("bad time in sample row " + strconv.Itoa(r) + ": <" + sample[r][dm.TimeColumn] + ">")
+		}
+		timestamps[r-1] = t.UTC().UnixMilli()
+		times = append(times, Sample{Timestamp: timestamps[r-1]})
+	}
+	dm.StartTime = timestamps[0]
+	dm.IntervalMs = InferInterval(times)
+	if dm.IntervalMs <= 0 {
+		return nil, errors.New("cannot infer the sampling interval of " + iot.DataFilePath)
+	}
+
+	for _, item := range iot.Measurements {
+		if item.Ignore || item.MeasurementName == LastColumnName || item.ColumnOrder >= len(dm.Header) {
+			continue
+		}
+		model := MeasurementModel{MeasurementItem: *item}
+		values := make([]string, len(sample)-1)
+		for r := 1; r < len(sample); r++ {
+			if item.ColumnOrder < len(sample[r]) {
+				values[r-1] = strings.TrimSpace(sample[r][item.ColumnOrder])
+			}
+		}
+		switch strings.ToLower(item.MeasurementType) {
+		case "float", "double", "decimal", "integer", "int", "int32", "int64", "longint":
+			model.Numeric = true
+			model.learnNumeric(iot, values, timestamps)
+		default:
+			model.learnStates(values)
+		}
+		dm.Models[item.ColumnOrder] = &model
+	}
+	return &dm, nil
+}
+
+func (model *MeasurementModel) learnNumeric(iot *IoTDbCsvDataFile, values []string, timestamps []int64) {
+	var hourSum, hourCount [hoursPerDay]float64
+	parsed := make([]float64, 0, len(values))
+	hours := make([]int, 0, len(values))
+	for ndx, value := range values {
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			continue
+		}
+		hour := time.UnixMilli(timestamps[ndx]).UTC().Hour()
+		hourSum[hour] += v
+		hourCount[hour]++
+		parsed = append(parsed, v)
+		hours = append(hours, hour)
+		model.Decimals = max(model.Decimals, decimalsOf(value))
+	}
+	sampleMean, sampleVariance := meanVariance(parsed)
+	var ok bool
+	if model.Mean, ok = iot.summaryFloat(&model.MeasurementItem, "mean"); !ok {
+		model.Mean = sampleMean
+	}
+	if model.Stddev, ok = iot.summaryFloat(&model.MeasurementItem, "stddev"); !ok {
+		model.Stddev = math.Sqrt(sampleVariance)
+	}
+	if model.Min, ok = iot.summaryFloat(&model.MeasurementItem, "min"); !ok {
+		model.Min = math.Inf(-1)
+	}
+	if model.Max, ok = iot.summaryFloat(&model.MeasurementItem, "max"); !ok {
+		model.Max = math.Inf(1)
+	}
+	if len(parsed) < 2 {
+		model.Sigma = model.Stddev
+		return
+	}
+	profileVariance := 0.0
+	for hour := 0; hour < hoursPerDay; hour++ {
+		if hourCount[hour] > 0 {
+			model.Daily[hour] = hourSum[hour]/hourCount[hour] - sampleMean
+			profileVariance += hourCount[hour] * model.Daily[hour] * model.Daily[hour]
+		}
+	}
+	profileVariance /= float64(len(parsed))
+	residuals := make([]float64, len(parsed))
+	for ndx, v := range parsed {
+		residuals[ndx] = v - sampleMean - model.Daily[hours[ndx]]
+	}
+	_, residualVariance := meanVariance(residuals)
+	if residualVariance > 0 {
+		covariance := 0.0
+		for ndx := 1; ndx < len(residuals); ndx++ {
+			covariance += residuals[ndx] * residuals[ndx-1]
+		}
+		model.Phi = math.Max(-0.99, math.Min(0.99, covariance/float64(len(residuals)-1)/residualVariance))
+	}
+	// the summary describes the whole file: whatever variance the daily profile does not explain is noise.
+	model.Sigma = math.Sqrt(math.Max(model.Stddev*model.Stddev-profileVariance, 0))
+}
+
+func (model *MeasurementModel) learnStates(values []string) {
+	repeats := 0
+	for ndx, value := range values {
+		if len(value) == 0 {
+			continue
+		}
+		model.States = append(model.States, value)
+		if ndx > 0 && value == values[ndx-1] {
+			repeats++
+		}
+	}
+	if len(values) > 1 {
+		model.Persistence = float64(repeats) / float64(len(values)-1)
+	}
+}
+
+func meanVariance(values []float64) (float64, float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+	sum, squares := 0.0, 0.0
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	for _, v := range values {
+		squares += (v - mean) * (v - mean)
+	}
+	return mean, squares / float64(len(values))
+}
+
+// Generate the rows (header first) of one synthetic device; every device must use the next value of the same random source.
+func (dm *DatasetModel) Generate(random *rand.Rand) [][]string {
+	rows := make([][]string, 0, dm.Rows+1)
+	rows = append(rows, dm.Header)
+	levels := make([]float64, len(dm.Models))
+	noise := make([]float64, len(dm.Models))
+	states := make([]string, len(dm.Models))
+	for c, model := range dm.Models {
+		if model != nil && model.Numeric {
+			levels[c] = random.NormFloat64() * deviceLevelSpread * model.Stddev
+		}
+	}
+	for r := 0; r < dm.Rows; r++ {
+		timestamp := dm.StartTime + int64(r)*dm.IntervalMs
+		hour := time.UnixMilli(timestamp).UTC().Hour()
+		row := make([]string, len(dm.Header))
+		for c, model := range dm.Models {
+			switch {
+			case c == dm.TimeColumn:
+				row[c] = formatTimeLayout(timestamp, dm.TimeLayout, dm.Location)
+			case model == nil:
+			case model.Numeric:
+				// AR(1) noise keeps the stddev Sigma while following the sample's autocorrelation.
+				noise[c] = model.Phi*noise[c] + math.Sqrt(1-model.Phi*model.Phi)*model.Sigma*random.NormFloat64()
+				value := math.Max(model.Min, math.Min(model.Max, model.Mean+levels[c]+model.Daily[hour]+noise[c]))
+				row[c] = strconv.FormatFloat(value, 'f', model.Decimals, 64)
+			case len(model.States) > 0:
+				if r == 0 || random.Float64() >= model.Persistence {
+					states[c] = model.States[random.Intn(len(model.States))]
+				}
+				row[c] = states[c]
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func writeCsvRows(fileName string, rows [][]string) error {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	csvWriter := csv.NewWriter(file)
+	if err = csvWriter.WriteAll(rows); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// programArgs: synthesize <dataFile.csv> <timeMeasurementName> [devices=3] [seed=1] [rows=0] [start=time] [sample=10000] [output=iotdb|csv] [dir=.] [commands=createts,insert]
+func ProcessSynthesize(programArgs []string) {
+	if len(programArgs) < 4 || sourceExt(programArgs[2]) != csvExtension {
+		checkErr("synthesize", errors.New("expected: synthesize <dataFile.csv> <timeMeasurementName> [devices=3] [seed=1] [output=iotdb|csv]"))
+	}
+	parameters := getProgramParameters(programArgs, synthesizeParameters)
+	output := strings.ToLower(parameters["output"])
+	if !contains(synthesizeOutputs, output) {
+		checkErr("synthesize", errors.New("output must be one of "+strings.Join(synthesizeOutputs, ", ")))
+	}
+	devices, err := strconv.Atoi(parameters["devices"])
+	if err != nil || devices <= 0 {
+		checkErr("synthesize", errors.New("devices must be a positive number: "+parameters["devices"]))
+	}
+	seed, err := strconv.ParseInt(parameters["seed"], 10, 64)
+	checkErr("synthesize(seed)", err)
+	rows, err := strconv.Atoi(parameters["rows"])
+	if err != nil || rows < 0 {
+		checkErr("synthesize", errors.New("rows must be a number >= 0: "+parameters["rows"]))
+	}
+	sampleRows, err := strconv.Atoi(parameters["sample"])
+	if err != nil || sampleRows < 2 {
+		checkErr("synthesize", errors.New("sample must be a number of rows >= 2: "+parameters["sample"]))
+	}
+	commands := GetTimeseriesCommands(splitList(parameters["commands"]))
+
+	iot, err := Initialize_IoTDbCsvDataFile(false, programArgs[1:]) // the summary file only
+	checkErr("Initialize_IoTDbCsvDataFile", err)
+	err = iot.ReadCsvFile(iot.DataFilePath, true)
+	checkErr("ReadCsvFile", err)
+	sample := iot.Dataset[:min(len(iot.Dataset), sampleRows+1)]
+	model, err := iot.LearnDatasetModel(sample)
+	checkErr("LearnDatasetModel", err)
+	if rows > 0 {
+		model.Rows = rows
+	}
+	model.StartTime, err = parseReplayTime(parameters["start"], model.StartTime)
+	checkErr("synthesize(start)", err)
+	if output == "iotdb" {
+		iotdbConnection, ok := Init_IoTDB(true)
+		if !ok {
+			checkErr("Init_IoTDB: ", errors.New(iotdbConnection))
+		}
+	}
+
+	random := rand.New(rand.NewSource(seed))
+	for n := 1; n <= devices; n++ {
+		synthetic := iot // same schema and summary
+		synthetic.DatasetName = iot.DatasetName + syntheticSeparator + strconv.FormatInt(seed, 10) + "_" + strconv.Itoa(n)
+		synthetic.Description = "synthetic instance of " + iot.DatasetName
+		synthetic.DataFilePath = filepath.Join(parameters["dir"], synthetic.DatasetName+csvExtension)
+		synthetic.Dataset = model.Generate(random)
+		if output == "csv" {
+			err = writeCsvRows(synthetic.DataFilePath, synthetic.Dataset)
+			checkErr("writeCsvRows", err)
+			err = writeCsvRows(GetSummaryFilename(synthetic.DataFilePath), iot.Summary)
+			checkErr("writeCsvRows(summary)", err)
+			fmt.Println("Wrote " + synthetic.DataFilePath)
+			continue
+		}
+		synthetic.IoTDbAccess = IoTDbAccess{ActiveSession: true, TimeseriesCommands: commands}
+		err = synthetic.ProcessTimeseries()
+		checkErr("ProcessTimeseries(synthetic)", err)
+	}
+	if output == "csv" {
+		fmt.Println("Insert with: netcdf " + filepath.Join(parameters["dir"], iot.DatasetName+syntheticSeparator+strconv.FormatInt(seed, 10)+"_1"+csvExtension) + " " + iot.TimeMeasurementName + " createts insert")
+	}
+}
@@ -0,0 +1,293 @@
+package main // toniot.go adapts the raw ToN_IoT telemetry files (IoT_Fridge.csv, IoT_Motion_Light.csv, ...) so they load without a processed/ pre-step.
+// netcdf <IoT_Motion_Light.csv> utc_timestamp createts insert [adapter=auto|toniot|none] [timezone=Australia/Sydney] [ambiguous=earlier] [database=root.toniot.raw]
+// The raw files have separate local date ("31-Mar-19") and time (" 12:36:55") columns and the label (0 normal, 1 attack) and type (normal or the attack name) annotations.
+//   date, time   are merged into the utc_timestamp column (epoch seconds, Longint), the time column of the dataset;
+//   on/off       and the other two-valued strings (true/false, open/closed, yes/no) become booleans;
+//   categories   other strings with at most maxCategories values become integer codes, recorded in ATTRIBUTES('categories'='high=0,low=1');
+//   label, type  are stored with ATTRIBUTES('annotation'='true') so analyze leaves them alone (see isCompanionSeries).
+// adapter=auto applies the adapter to files with date, time, label and type columns. A raw file without a summary file is summarized from
+// the known ToN_IoT fields; its database comes from database=.
+
+import (
+	"errors"
+	"filesystem" // work module
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	toniotTimeColumn = "utc_timestamp"
+	toniotTimezone   = "Australia/Sydney" // the ToN_IoT testbed is at UNSW Canberra
+	maxCategories    = 16
+)
+
+var toniotParameters = map[string]string{"adapter": "auto", "timezone": "", "ambiguous": "", "database": ""}
+var toniotLayouts = []string{"2-Jan-06 15:04:05", "2-Jan-2006 15:04:05"}
+
+// Tokens of two-valued categorical strings.
+var booleanTokens = map[string]bool{"on": true, "off": false, "true": true, "false": false, "open": true, "closed": false, "yes": true, "no": false, "1": true, "0": false}
+
+// xsv type and units of the known ToN_IoT fields, for raw files without a summary file.
+var toniotFields = map[string][2]string{
+	"fridge_temperature":        {"Float", "°C"},
+	"temp_condition":            {"Unicode", "unitless"},
+	"door_state":                {"Unicode", "unitless"},
+	"sphone_signal":             {"Unicode", "unitless"},
+	"latitude":                  {"Double", "degrees"},
+	"longitude":                 {"Double", "degrees"},
+	"fc1_read_input_register":   {"Integer", "unitless"},
+	"fc2_read_discrete_value":   {"Integer", "unitless"},
+	"fc3_read_holding_register": {"Integer", "unitless"},
+	"fc4_read_coil":             {"Integer", "unitless"},
+	"motion_status":             {"Integer", "unitless"},
+	"light_status":              {"Unicode", "unitless"},
+	"current_temperature":       {"Float", "°C"},
+	"thermostat_status":         {"Integer", "unitless"},
+	"temperature":               {"Float", "°C"},
+	"pressure":                  {"Float", "mb"},
+	"humidity":                  {"Float", "%rh"},
+	labelColumnName:             {"Integer", "unitless"},
+	typeColumnName:              {"Unicode", "unicode"},
+}
+
+// Index of name in fields, ignoring case and surrounding blanks; -1 if absent.
+func fieldIndex(fields []string, name string) int {
+	for ndx, field := range fields {
+		if strings.EqualFold(strings.TrimSpace(field), name) {
+			return ndx
+		}
+	}
+	return -1
+}
+
+// A ToN_IoT header has date, time, label and type columns.
+func isToniotHeader(fields []string) bool {
+	for _, name := range []string{"date", "time", labelColumnName, typeColumnName} {
+		if fieldIndex(fields, name) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func isToniotFile(dataFilePath string) bool {
+	header, err := readCsvRecords(dataFilePath, true)
+	return err == nil && len(header) > 0 && isToniotHeader(header[0])
+}
+
+// Whether adapter= selects the ToN_IoT adapter for a file with these field names.
+func useToniotAdapter(fields []string, programArgs []string) (bool, error) {
+	switch adapter := strings.ToLower(getProgramParameters(programArgs, toniotParameters)["adapter"]); adapter {
+	case "none":
+		return false, nil
+	case "auto":
+		return isToniotHeader(fields), nil
+	case "toniot":
+		if fieldIndex(fields, "date") < 0 || fieldIndex(fields, "time") < 0 {
+			return false, errors.New("adapter=toniot needs date and time columns")
+		}
+		return true, nil
+	default:
+		return false, errors.New("unknown adapter " + adapter + "; expected auto, toniot or none")
+	}
+}
+
+// The xsv-like summary of a raw ToN_IoT file without a summary file; database is given by database=.
+func toniotSummary(dataFilePath string, programArgs []string) ([][]string, error) {
+	header, err := readCsvRecords(dataFilePath, true)
+	if err != nil {
+		return nil, err
+	}
+	database := getProgramParameters(programArgs, toniotParameters)["database"]
+	if len(database) == 0 {
+		return nil, errors.New(dataFilePath + ": no summary file for the ToN_IoT file; give database=root.<group>.<name>")
+	}
+	summary := [][]string{{"field", "type", "sum", "min", "max", unitsName}}
+	for _, field := range header[0] {
+		known, ok := toniotFields[strings.ToLower(strings.TrimSpace(field))]
+		if !ok {
+			known = [2]string{"Unicode", ""}
+		}
+		summary = append(summary, []string{strings.TrimSpace(field), known[0], "", "", "", known[1]})
+	}
+	return append(summary, []string{endOfFields, database, "", "", "", ""}), nil
+}
+
+// Set row[column] when the summary has the column.
+func setSummaryCell(row []string, column int, value string) {
+	if column >= 0 && column < len(row) {
+		row[column] = value
+	}
+}
+
+// Rewrite the Summary and Dataset of a ToN_IoT file: merge date and time into utc_timestamp, map the categorical strings and annotate label and type.
+// Reads the dataset when it has not been read; the measurements are mapped again, keeping the Identifier.
+func (iot *IoTDbCsvDataFile) ApplyAdapter(programArgs []string) error {
+	if len(iot.Summary) < 2 {
+		return nil
+	}
+	fields := make([]string, 0, len(iot.Summary))
+	for _, row := range iot.Summary[1:] {
+		fields = append(fields, row[0])
+	}
+	apply, err := useToniotAdapter(fields, programArgs)
+	if err != nil || !apply {
+		return err
+	}
+	if iot.Dataset == nil {
+		if iot.Dataset, err = readCsvRecords(iot.DataFilePath, false); err != nil {
+			return err
+		}
+		iot.NormalizeValues()
+	}
+	if len(iot.Dataset) == 0 {
+		return errors.New(iot.DataFilePath + ": no header row")
+	}
+	dateColumn, timeColumn := fieldIndex(iot.Dataset[0], "date"), fieldIndex(iot.Dataset[0], "time")
+	if dateColumn < 0 || timeColumn < 0 || fieldIndex(fields, "date") != dateColumn || fieldIndex(fields, "time") != timeColumn {
+		return errors.New(iot.DataFilePath + ": the date and time columns of the summary and the header differ")
+	}
+	parameters := getProgramParameters(programArgs, toniotParameters)
+	zone, ambiguous := parameters["timezone"], parameters["ambiguous"]
+	if len(zone) == 0 {
+		zone = toniotTimezone
+	}
+	if len(ambiguous) == 0 {
+		ambiguous = filesystem.AmbiguousEarlier // the data spans the end of daylight saving time on 7 April 2019
+	}
+	parser, err := filesystem.NewTimestampParser(toniotLayouts, zone, ambiguous, filesystem.EpochSeconds)
+	if err != nil {
+		return err
+	}
+
+	// utc_timestamp replaces date; time is dropped from the header, the rows and the summary.
+	for r, row := range iot.Dataset {
+		if len(row) <= max(dateColumn, timeColumn) {
+			continue
+		}
+		if r == 0 {
+			row[dateColumn] = toniotTimeColumn
+		} else if t, err := parser.Parse(strings.TrimSpace(row[dateColumn]) + " " + strings.TrimSpace(row[timeColumn])); err == nil {
+			row[dateColumn] = strconv.FormatInt(t.UTC().Unix(), 10)
+		} else {
+			row[dateColumn] = "" // rejected as a bad time by ValidateDataset
+		}
+		iot.Dataset[r] = append(row[:timeColumn], row[timeColumn+1:]...)
+	}
+	sumColumn, minColumn, maxColumn := iot.GetColumnNumberFromName("sum"), iot.GetColumnNumberFromName("min"), iot.GetColumnNumberFromName("max")
+	unitsColumn := iot.GetColumnNumberFromName(unitsName)
+	dateRow := iot.Summary[dateColumn+1]
+	dateRow[0], dateRow[1] = toniotTimeColumn, "Longint"
+	for _, column := range []int{sumColumn, minColumn, maxColumn} {
+		setSummaryCell(dateRow, column, "")
+	}
+	setSummaryCell(dateRow, unitsColumn, "unixutc")
+	iot.Summary = append(iot.Summary[:timeColumn+1], iot.Summary[timeColumn+2:]...)
+
+	iot.Adapted = make(map[string]string, 0)
+	for ndx, row := range iot.Summary[1:] {
+		name := strings.TrimSpace(row[0])
+		if name == endOfFields || name == interpolated {
+			break
+		}
+		alias, _ := StandardName(name)
+		if strings.EqualFold(name, labelColumnName) || strings.EqualFold(name, typeColumnName) {
+			iot.Adapted[alias] = ", 'annotation'='true'"
+			continue
+		}
+		if rowsXsdMap[row[1]] != "string" {
+			continue
+		}
+		categories, isBoolean := iot.categories(ndx)
+		switch {
+		case isBoolean:
+			row[1] = "boolean"
+			iot.Adapted[alias] = ", 'categories'='" + iot.mapCategories(ndx, func(value string) string { return strconv.FormatBool(booleanTokens[value]) }) + "'"
+		case len(categories) > 0:
+			codes := make(map[string]string, len(categories))
+			for code, value := range categories {
+				codes[value] = strconv.Itoa(code)
+			}
+			row[1] = "Integer"
+			setSummaryCell(row, minColumn, "0")
+			setSummaryCell(row, maxColumn, strconv.Itoa(len(categories)-1))
+			iot.Adapted[alias] = ", 'categories'='" + iot.mapCategories(ndx, func(value string) string { return codes[value] }) + "'"
+		default:
+			continue
+		}
+		setSummaryCell(row, sumColumn, "")
+	}
+
+	identifier := iot.Identifier
+	iot.XsvSummaryTypeMap()
+	if len(identifier) > 0 {
+		iot.Identifier = identifier
+	}
+	iot.TimeMeasurementName = toniotTimeColumn
+	iot.progress("ToN_IoT adapter: date and time merged into " + toniotTimeColumn + " (" + zone + ")")
+	return nil
+}
+
+// The sorted distinct values of a string column, lower case; isBoolean when they are all boolean tokens.
+// No categories when the column has more than maxCategories values or only numbers.
+func (iot *IoTDbCsvDataFile) categories(column int) ([]string, bool) {
+	seen := make(map[string]bool, 0)
+	isBoolean, isNumeric := true, true
+	for _, row := range iot.Dataset[1:] {
+		if column >= len(row) {
+			continue
+		}
+		value := strings.ToLower(strings.TrimSpace(row[column]))
+		if len(value) == 0 || seen[value] {
+			continue
+		}
+		seen[value] = true
+		if _, ok := booleanTokens[value]; !ok {
+			isBoolean = false
+		}
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			isNumeric = false
+		}
+		if len(seen) > maxCategories {
+			return nil, false
+		}
+	}
+	if len(seen) == 0 || isNumeric {
+		return nil, false
+	}
+	values := make([]string, 0, len(seen))
+	for value := range seen {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	return values, isBoolean
+}
+
+// Replace the values of a column by mapping them and return the mapping as value=mapped pairs.
+func (iot *IoTDbCsvDataFile) mapCategories(column int, mapping func(string) string) string {
+	pairs := make(map[string]string, 0)
+	for _, row := range iot.Dataset[1:] {
+		if column >= len(row) {
+			continue
+		}
+		value := strings.ToLower(strings.TrimSpace(row[column]))
+		if len(value) == 0 {
+			row[column] = ""
+			continue
+		}
+		row[column] = mapping(value)
+		pairs[value] = row[column]
+	}
+	values := make([]string, 0, len(pairs))
+	for value := range pairs {
+		values = append(values, value+"="+pairs[value])
+	}
+	sort.Strings(values)
+	return strings.Join(values, ",")
+}
+
+// ATTRIBUTES of a column changed by the adapter; "" otherwise.
+func (iot *IoTDbCsvDataFile) adapterAttributes(item *MeasurementItem) string {
+	return iot.Adapted[item.MeasurementAlias]
+}
@@ -0,0 +1,203 @@
+package main // validate.go checks the rows of a CSV dataset before they are inserted into IoTDB.
+// A row is rejected when its time cannot be parsed (see filesystem/timestamp.go) or does not increase at the IoTDB timestamp precision, when a value does not have the declared
+// type of its column, or when a number lies outside the min/max of the summary file. Empty values are nulls and pass.
+// Rejected rows go to quarantine_<dataset>.csv (row number, reason code, column, value, then the original row)
+// and each insert writes quality_<dataset>.json next to the data file, so bad data neither aborts nor truncates a block.
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Reason codes of rejected rows.
+const (
+	reasonBadTime       = "BAD_TIME"
+	reasonDuplicateTime = "DUPLICATE_TIME"
+	reasonTimeOrder     = "NON_MONOTONIC_TIME"
+	reasonColumnCount   = "COLUMN_COUNT"
+	reasonType          = "TYPE_MISMATCH"
+	reasonBelowMin      = "BELOW_MIN"
+	reasonAboveMax      = "ABOVE_MAX"
+)
+
+// ValidRow is a row of the dataset that may be inserted.
+type ValidRow struct {
+	Index     int   `json:"index"`     // row of Dataset
+	Timestamp int64 `json:"timestamp"` // IoTDB timestamp (precision.go)
+}
+
+// RowRejection says why a row of the dataset is quarantined.
+type RowRejection struct {
+	Index  int    `json:"index"`
+	Reason string `json:"reason"`
+	Column string `json:"column"`
+	Value  string `json:"value"`
+}
+
+// QualityReport summarizes one validation run.
+type QualityReport struct {
+	Dataset        string         `json:"dataset"`
+	DataFilePath   string         `json:"datafilepath"`
+	Rows           int            `json:"rows"`
+	Accepted       int            `json:"accepted"`
+	Rejected       int            `json:"rejected"`
+	Reasons        map[string]int `json:"reasons"`
+	Columns        map[string]int `json:"columns"` // rejections per column
+	NullValues     map[string]int `json:"nullvalues"`
+	FirstTimestamp int64          `json:"firsttimestamp"`
+	LastTimestamp  int64          `json:"lasttimestamp"`
+	QuarantinePath string         `json:"quarantinepath,omitempty"`
+	Rejections     []RowRejection `json:"-"`
+}
+
+// Check one value against the declared type and summary range of item; "" if it is acceptable.
+func (iot *IoTDbCsvDataFile) validateValue(item *MeasurementItem, value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) == 0 {
+		return ""
+	}
+	var number float64
+	var err error
+	switch strings.ToLower(item.MeasurementType) {
+	case "integer", "int", "int32":
+		var n int64
+		n, err = strconv.ParseInt(value, 10, 32)
+		number = float64(n)
+	case "int64", "longint":
+		var n int64
+		n, err = strconv.ParseInt(value, 10, 64)
+		number = float64(n)
+	case "float", "double", "decimal":
+		number, err = strconv.ParseFloat(value, 64)
+	default:
+		return ""
+	}
+	if err != nil {
+		return reasonType
+	}
+	if minimum, ok := iot.summaryFloat(item, "min"); ok && number < minimum {
+		return reasonBelowMin
+	}
+	if maximum, ok := iot.summaryFloat(item, "max"); ok && number > maximum {
+		return reasonAboveMax
+	}
+	return ""
+}
+
+// Validate the rows of Dataset (header first); timeIndex is the column of TimeMeasurementName.
+func (iot *IoTDbCsvDataFile) ValidateDataset(timeIndex int) ([]ValidRow, QualityReport) {
+	report := QualityReport{Dataset: iot.DatasetName, DataFilePath: iot.DataFilePath, Reasons: make(map[string]int, 0), Columns: make(map[string]int, 0), NullValues: make(map[string]int, 0)}
+	valid := make([]ValidRow, 0, len(iot.Dataset))
+	items := make([]*MeasurementItem, len(iot.Dataset[0]))
+	for _, item := range iot.Measurements {
+		if !item.Ignore && item.ColumnOrder < len(items) && item.MeasurementName != LastColumnName {
+			items[item.ColumnOrder] = item
+		}
+	}
+	seen := make(map[int64]bool, len(iot.Dataset))
+	lastTimestamp := int64(0)
+	reject := func(r int, reason, column, value string) {
+		report.Rejections = append(report.Rejections, RowRejection{Index: r, Reason: reason, Column: column, Value: value})
+		report.Reasons[reason]++
+		if len(column) > 0 {
+			report.Columns[column]++
+		}
+	}
+	for r := 1; r < len(iot.Dataset); r++ {
+		report.Rows++
+		row := iot.Dataset[r]
+		if len(row) != len(iot.Dataset[0]) || timeIndex < 0 || timeIndex >= len(row) {
+			reject(r, reasonColumnCount, "", strconv.Itoa(len(row)))
+			continue
+		}
+		startTime, err := iot.TimeParser.Parse(row[timeIndex])
+		if err != nil {
+			reject(r, reasonBadTime, iot.TimeMeasurementName, row[timeIndex])
+			continue
+		}
+		timestamp := iotdbTimestamp(startTime)
+		if seen[timestamp] {
+			reject(r, reasonDuplicateTime, iot.TimeMeasurementName, row[timeIndex])
+			continue
+		}
+		if len(valid) > 0 && timestamp < lastTimestamp {
+			reject(r, reasonTimeOrder, iot.TimeMeasurementName, row[timeIndex])
+			continue
+		}
+		rejected := false
+		for c, item := range items {
+			if item == nil || c == timeIndex {
+				continue
+			}
+			if len(strings.TrimSpace(row[c])) == 0 {
+				report.NullValues[item.MeasurementAlias]++
+			}
+			if reason := iot.validateValue(item, row[c]); len(reason) > 0 {
+				reject(r, reason, item.MeasurementAlias, row[c])
+				rejected = true
+				break
+			}
+		}
+		if rejected {
+			continue
+		}
+		seen[timestamp] = true
+		lastTimestamp = timestamp
+		valid = append(valid, ValidRow{Index: r, Timestamp: timestamp})
+	}
+	report.Accepted = len(valid)
+	report.Rejected = len(report.Rejections)
+	if len(valid) > 0 {
+		report.FirstTimestamp = valid[0].Timestamp
+		report.LastTimestamp = valid[len(valid)-1].Timestamp
+	}
+	return valid, report
+}
+
+// Write the rejected rows to quarantine_<dataset>.csv in the folder of the data file.
+func (iot *IoTDbCsvDataFile) WriteQuarantine(report *QualityReport) error {
+	quarantinePath := filepath.Join(sourceFolder(iot.DataFilePath), "quarantine_"+iot.DatasetName+csvExtension)
+	if report.Rejected == 0 {
+		os.Remove(quarantinePath) // from an earlier run
+		return nil
+	}
+	report.QuarantinePath = quarantinePath
+	file, err := os.Create(report.QuarantinePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	csvWriter := csv.NewWriter(file)
+	_ = csvWriter.Write(append([]string{"row", "reason", "column", "value"}, iot.Dataset[0]...))
+	for _, rejection := range report.Rejections {
+		_ = csvWriter.Write(append([]string{strconv.Itoa(rejection.Index), rejection.Reason, rejection.Column, rejection.Value}, iot.Dataset[rejection.Index]...))
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// Print the report and write it to quality_<dataset>.json in the folder of the data file.
+func (iot *IoTDbCsvDataFile) WriteQualityReport(report QualityReport) error {
+	iot.progressf("Validated %d rows of %s: %d accepted, %d rejected\n", report.Rows, report.Dataset, report.Accepted, report.Rejected)
+	reasons := make([]string, 0, len(report.Reasons))
+	for reason := range report.Reasons {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		iot.progressf("  %-20s %d\n", reason, report.Reasons[reason])
+	}
+	if len(report.QuarantinePath) > 0 {
+		iot.progress("Rejected rows written to " + report.QuarantinePath)
+	}
+	jsonReport, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(sourceFolder(iot.DataFilePath), "quality_"+iot.DatasetName+".json"), jsonReport, 0644)
+}